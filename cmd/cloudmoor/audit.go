@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/binGhzal/cloudmoor/internal/vault"
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditStorePath     string
+	auditSince         string
+	auditUntil         string
+	auditKey           string
+	auditOperation     string
+	auditActor         string
+	auditOutcome       string
+	auditLimit         int
+	auditNewest        bool
+	auditFormat        string
+	auditRetention     time.Duration
+	auditHMACKeyFile   string
+	auditPublicKeyFile string
+	auditCheckpoints   int
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Search and maintain audit trails",
+}
+
+var auditQueryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Search the audit store",
+	Long: `Print the audit events matching every given filter, oldest first, as JSON
+or CSV. --since and --until take an RFC 3339 time or a duration before now,
+such as 24h.`,
+	Args: cobra.NoArgs,
+	RunE: runAuditQuery,
+}
+
+var auditPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Delete audit events older than the retention period",
+	Long: `Delete events older than --retention from the audit store. The purge is
+itself recorded in the store as an "audit_purge" event. It fails while another
+process, such as a running vault, has the store open for writing.`,
+	Args: cobra.NoArgs,
+	RunE: runAuditPurge,
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify <log>",
+	Short: "Verify a tamper-evident audit log",
+	Long: `Check the hash chain of an audit log and the signature of every checkpoint,
+and report the first altered or deleted record. Pass the HMAC key or ed25519
+public key the log was checkpointed with; without one only the chain is checked.
+With a key, the log must hold a checkpoint and at most --checkpoint-every
+records may follow the last one.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAuditVerify,
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{auditQueryCmd, auditPurgeCmd} {
+		cmd.Flags().StringVar(&auditStorePath, "store", "", "path to the audit store")
+		_ = cmd.MarkFlagRequired("store")
+	}
+
+	auditQueryCmd.Flags().StringVar(&auditSince, "since", "", "only events at or after this time")
+	auditQueryCmd.Flags().StringVar(&auditUntil, "until", "", "only events before this time")
+	auditQueryCmd.Flags().StringVar(&auditKey, "key", "", "only events for this secret key")
+	auditQueryCmd.Flags().StringVar(&auditOperation, "op", "", "only events for this operation, e.g. get")
+	auditQueryCmd.Flags().StringVar(&auditActor, "actor", "", "only events by this actor")
+	auditQueryCmd.Flags().StringVar(&auditOutcome, "outcome", "", "only successful or failed operations: success or failure")
+	auditQueryCmd.Flags().IntVar(&auditLimit, "limit", 0, "maximum number of events to print (0 for all)")
+	auditQueryCmd.Flags().BoolVar(&auditNewest, "newest", false, "print the most recent events first")
+	auditQueryCmd.Flags().StringVar(&auditFormat, "format", "json", "output format: json or csv")
+
+	auditPurgeCmd.Flags().DurationVar(&auditRetention, "retention", 0, "keep events newer than this, e.g. 2160h for 90 days")
+	_ = auditPurgeCmd.MarkFlagRequired("retention")
+
+	auditVerifyCmd.Flags().StringVar(&auditHMACKeyFile, "hmac-key-file", "", "file holding the HMAC checkpoint key")
+	auditVerifyCmd.Flags().StringVar(&auditPublicKeyFile, "public-key-file", "", "PEM file holding the ed25519 checkpoint public key")
+	auditVerifyCmd.MarkFlagsMutuallyExclusive("hmac-key-file", "public-key-file")
+	auditVerifyCmd.Flags().IntVar(&auditCheckpoints, "checkpoint-every", 0, "records between checkpoints the log was written with (0 for the default)")
+
+	auditCmd.AddCommand(auditQueryCmd, auditPurgeCmd, auditVerifyCmd)
+}
+
+func runAuditQuery(cmd *cobra.Command, args []string) error {
+	if auditFormat != "json" && auditFormat != "csv" {
+		return fmt.Errorf("unknown format %q: use json or csv", auditFormat)
+	}
+	now := time.Now()
+	since, err := parseTimeFlag(auditSince, now)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	until, err := parseTimeFlag(auditUntil, now)
+	if err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+
+	store, err := vault.OpenAuditStoreReadOnly(auditStorePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	events, err := store.Query(vault.AuditQuery{
+		Since:     since,
+		Until:     until,
+		Key:       auditKey,
+		Operation: auditOperation,
+		Actor:     auditActor,
+		Outcome:   vault.AuditOutcome(auditOutcome),
+		Limit:     auditLimit,
+		Newest:    auditNewest,
+	})
+	if err != nil {
+		return err
+	}
+
+	if auditFormat == "csv" {
+		return writeAuditCSV(cmd.OutOrStdout(), events)
+	}
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	enc.SetIndent("", "  ")
+	return enc.Encode(events)
+}
+
+func runAuditPurge(cmd *cobra.Command, args []string) error {
+	if auditRetention <= 0 {
+		return fmt.Errorf("--retention must be positive")
+	}
+	store, err := vault.OpenAuditStore(auditStorePath, vault.AuditStoreOptions{Retention: auditRetention})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	n, err := store.Purge(cliContext())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "✓ Purged %d events older than %s\n", n, auditRetention)
+	return nil
+}
+
+// parseTimeFlag accepts an RFC 3339 time or a duration before now. Empty
+// means unbounded.
+func parseTimeFlag(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a duration", s)
+	}
+	return now.Add(-d), nil
+}
+
+// writeAuditCSV writes events as CSV with a header row. Metadata is written
+// as a JSON object in the last column.
+func writeAuditCSV(out io.Writer, events []vault.AuditEvent) error {
+	w := csv.NewWriter(out)
+	header := []string{"timestamp", "operation", "key", "success", "error", "actor",
+		"auth_method", "client_addr", "request_id", "component", "metadata"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, e := range events {
+		metadata := ""
+		if len(e.Metadata) > 0 {
+			b, err := json.Marshal(e.Metadata)
+			if err != nil {
+				return err
+			}
+			metadata = string(b)
+		}
+		record := []string{
+			e.Timestamp.Format(time.RFC3339Nano), e.Operation, e.Key, strconv.FormatBool(e.Success), e.Error,
+			e.Actor, e.AuthMethod, e.ClientAddr, e.RequestID, string(e.Component), metadata,
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func runAuditVerify(cmd *cobra.Command, args []string) error {
+	verifier, err := loadAuditVerifier()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	out := cmd.OutOrStdout()
+	result, err := vault.VerifyAuditLog(f, verifier, auditCheckpoints)
+	if err != nil {
+		if result.BrokenSeq != 0 {
+			fmt.Fprintf(out, "✗ First bad record: %d (line %d)\n", result.BrokenSeq, result.BrokenLine)
+		}
+		return err
+	}
+
+	fmt.Fprintf(out, "✓ %d records intact, %d checkpoints verified\n", result.Records, result.Checkpoints)
+	if verifier == nil {
+		fmt.Fprintln(out, "  Checkpoint signatures were not checked; pass a key to detect a rewritten chain.")
+	} else if result.Unsigned > 0 {
+		fmt.Fprintf(out, "  %d records after the last checkpoint are not signed yet.\n", result.Unsigned)
+	}
+	fmt.Fprintf(out, "  Head: %s\n", result.Head)
+	return nil
+}
+
+// loadAuditSigner reads the checkpoint signing key from an HMAC key file or a
+// PEM file holding a PKCS #8 ed25519 private key. Both empty means no signer.
+func loadAuditSigner(hmacKeyFile, privateKeyFile string) (vault.AuditSigner, error) {
+	switch {
+	case hmacKeyFile != "":
+		key, err := os.ReadFile(hmacKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HMAC key: %w", err)
+		}
+		return vault.HMACAuditKey(key), nil
+	case privateKeyFile != "":
+		data, err := os.ReadFile(privateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signing key: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("signing key file is not PEM encoded")
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key: %w", err)
+		}
+		private, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key is not an ed25519 key")
+		}
+		return vault.Ed25519AuditSigner(private), nil
+	default:
+		return nil, nil
+	}
+}
+
+// loadAuditVerifier reads the checkpoint key named by the flags, if any.
+func loadAuditVerifier() (vault.AuditVerifier, error) {
+	switch {
+	case auditHMACKeyFile != "":
+		key, err := os.ReadFile(auditHMACKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HMAC key: %w", err)
+		}
+		return vault.HMACAuditKey(key), nil
+	case auditPublicKeyFile != "":
+		data, err := os.ReadFile(auditPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("public key file is not PEM encoded")
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+		public, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key is not an ed25519 key")
+		}
+		return vault.Ed25519AuditVerifier(public), nil
+	default:
+		return nil, nil
+	}
+}
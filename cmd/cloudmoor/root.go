@@ -12,5 +12,5 @@ through a persistent daemon and intuitive CLI.`,
 }
 
 func init() {
-	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(configCmd, auditCmd)
 }
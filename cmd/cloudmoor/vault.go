@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/user"
 	"path/filepath"
 
 	"github.com/binGhzal/cloudmoor/internal/vault"
@@ -25,17 +26,46 @@ This command validates that the vault is properly configured and operational.`,
 	RunE: runVaultTest,
 }
 
+var (
+	vaultTestAuditLog        string
+	vaultTestAuditHMACKey    string
+	vaultTestAuditSigningKey string
+	vaultTestAuditStore      string
+)
+
 func init() {
+	vaultTestCmd.Flags().StringVar(&vaultTestAuditLog, "audit-log", "", "also append audit events to this hash-chained log")
+	vaultTestCmd.Flags().StringVar(&vaultTestAuditHMACKey, "audit-hmac-key-file", "", "sign audit log checkpoints with the HMAC key in this file")
+	vaultTestCmd.Flags().StringVar(&vaultTestAuditSigningKey, "audit-signing-key-file", "", "sign audit log checkpoints with this PEM ed25519 private key")
+	vaultTestCmd.MarkFlagsMutuallyExclusive("audit-hmac-key-file", "audit-signing-key-file")
+	vaultTestCmd.Flags().StringVar(&vaultTestAuditStore, "audit-store", "", "also record audit events in this queryable audit store")
 	vaultCmd.AddCommand(vaultTestCmd)
 }
 
+// cliContext returns a context that attributes vault operations to the local
+// user running the CLI.
+func cliContext() context.Context {
+	info := vault.RequestInfo{
+		AuthMethod: "local",
+		RequestID:  vault.NewRequestID(),
+		Component:  vault.ComponentCLI,
+	}
+	if u, err := user.Current(); err == nil {
+		info.Actor = u.Username
+	}
+	return vault.WithRequestInfo(context.Background(), info)
+}
+
 func runVaultTest(cmd *cobra.Command, args []string) error {
-	ctx := context.Background()
+	ctx := cliContext()
 
-	// Use temp directory for test key
-	tmpDir := os.TempDir()
-	keyPath := filepath.Join(tmpDir, "cloudmoor-test-vault.key")
-	defer os.Remove(keyPath) // Cleanup
+	// Use temp directory for test key and its rotation journal
+	tmpDir, err := os.MkdirTemp("", "cloudmoor-test-vault-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir) // Cleanup
+	keyPath := filepath.Join(tmpDir, "vault.key")
 
 	fmt.Printf("Creating test vault with key at: %s\n", keyPath)
 
@@ -50,7 +80,46 @@ func runVaultTest(cmd *cobra.Command, args []string) error {
 		auditEvents = append(auditEvents, e)
 	}
 
-	store := vault.NewAESGCMStore(keyProvider, auditHook)
+	// Optionally also record them durably
+	var sinks []vault.AuditSink
+	var storeOpts []vault.StoreOption
+	signer, err := loadAuditSigner(vaultTestAuditHMACKey, vaultTestAuditSigningKey)
+	if err != nil {
+		return err
+	}
+	if signer != nil && vaultTestAuditLog == "" {
+		return fmt.Errorf("--audit-hmac-key-file and --audit-signing-key-file require --audit-log")
+	}
+	if vaultTestAuditLog != "" {
+		auditLog, err := vault.OpenAuditLog(vaultTestAuditLog, vault.AuditLogOptions{Signer: signer})
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+		// Appended synchronously, so the vault stops as soon as it fails.
+		storeOpts = append(storeOpts, vault.WithAuditLog(auditLog))
+	}
+	if vaultTestAuditStore != "" {
+		auditStore, err := vault.OpenAuditStore(vaultTestAuditStore, vault.AuditStoreOptions{})
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, auditStore)
+	}
+	if len(sinks) > 0 {
+		pipeline, err := vault.NewAuditPipeline(vault.AuditPipelineOptions{}, sinks...)
+		if err != nil {
+			return err
+		}
+		defer pipeline.Close(ctx)
+		collect, deliver := auditHook, pipeline.Hook()
+		auditHook = func(e vault.AuditEvent) {
+			collect(e)
+			deliver(e)
+		}
+	}
+
+	store := vault.NewAESGCMStore(keyProvider, auditHook, storeOpts...)
 
 	fmt.Println("\n✓ Vault initialized")
 
@@ -73,12 +142,13 @@ func runVaultTest(cmd *cobra.Command, args []string) error {
 
 	// Get operation
 	fmt.Printf("Retrieving secret '%s'... ", testKey)
-	retrieved, err := store.Get(ctx, testKey)
+	retrieved, err := store.(vault.SecretReader).GetSecret(ctx, testKey)
 	if err != nil {
 		return fmt.Errorf("get failed: %w", err)
 	}
-	if string(retrieved) != string(testValue) {
-		return fmt.Errorf("round trip failed: expected %q, got %q", testValue, retrieved)
+	defer retrieved.Destroy()
+	if string(retrieved.Bytes()) != string(testValue) {
+		return fmt.Errorf("round trip failed: expected %q, got %q", testValue, retrieved.Bytes())
 	}
 	fmt.Println("✓ PASS")
 
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/binGhzal/cloudmoor/internal/vault"
+	"github.com/spf13/cobra"
+)
+
+var (
+	shamirKeyFile   string
+	shamirShares    int
+	shamirThreshold int
+)
+
+// errInputEnded reports that stdin ran out before the ceremony completed.
+var errInputEnded = errors.New("input ended before enough shares were submitted")
+
+var vaultInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Initialize a Shamir-protected vault key",
+	Long: `Generate a new master key protected by an unseal key split into N shares,
+any M of which are required to unseal the vault. The shares are printed once and
+never stored; hand each one to a different operator.`,
+	RunE: runVaultInit,
+}
+
+var vaultUnsealCmd = &cobra.Command{
+	Use:   "unseal",
+	Short: "Unseal a Shamir-protected vault key",
+	Long: `Submit unseal shares one at a time (one base64 share per line on stdin)
+until the threshold is reached, then verify the reconstructed master key.
+
+Shares are held only in this process's memory and never written to disk, so
+every operator must submit theirs before it exits. If input ends first, the
+shares submitted so far are discarded and the ceremony starts over.`,
+	RunE: runVaultUnseal,
+}
+
+var vaultRekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Issue new unseal shares",
+	Long: `Run a re-key ceremony: submit the threshold of current shares (one per
+line on stdin) and receive a new set of shares. The master key and all stored
+secrets are unchanged; the old shares stop working.`,
+	RunE: runVaultRekey,
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{vaultInitCmd, vaultUnsealCmd, vaultRekeyCmd} {
+		cmd.Flags().StringVar(&shamirKeyFile, "key-file", "", "path to the Shamir key file")
+		_ = cmd.MarkFlagRequired("key-file")
+	}
+	for _, cmd := range []*cobra.Command{vaultInitCmd, vaultRekeyCmd} {
+		cmd.Flags().IntVar(&shamirShares, "shares", 5, "number of shares to issue")
+		cmd.Flags().IntVar(&shamirThreshold, "threshold", 3, "number of shares required to unseal")
+	}
+
+	vaultCmd.AddCommand(vaultInitCmd, vaultUnsealCmd, vaultRekeyCmd)
+}
+
+func runVaultInit(cmd *cobra.Command, args []string) error {
+	_, shares, err := vault.InitShamirKeyProvider(shamirKeyFile, shamirShares, shamirThreshold)
+	if err != nil {
+		return fmt.Errorf("failed to initialize vault key: %w", err)
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Vault key written to %s\n\n", shamirKeyFile)
+	printShares(out, shares)
+	fmt.Fprintf(out, "\n%d of these %d shares are required to unseal the vault.\n", shamirThreshold, len(shares))
+	fmt.Fprintln(out, "They are not stored anywhere; distribute them now.")
+	return nil
+}
+
+func runVaultUnseal(cmd *cobra.Command, args []string) error {
+	keyProvider, err := vault.NewShamirKeyProvider(shamirKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to open vault key: %w", err)
+	}
+
+	ctx := context.Background()
+	out := cmd.OutOrStdout()
+	err = submitShares(cmd.InOrStdin(), out, func(share []byte) (vault.ShamirProgress, error) {
+		return keyProvider.SubmitShare(ctx, share)
+	})
+	if errors.Is(err, errInputEnded) {
+		keyProvider.ResetUnseal()
+		return fmt.Errorf("%w; the submitted shares were discarded, start the ceremony again", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := keyProvider.HealthCheck(context.Background()); err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	fmt.Fprintln(out, "✓ Vault unsealed")
+	return nil
+}
+
+func runVaultRekey(cmd *cobra.Command, args []string) error {
+	keyProvider, err := vault.NewShamirKeyProvider(shamirKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to open vault key: %w", err)
+	}
+	if err := keyProvider.BeginRekey(shamirShares, shamirThreshold); err != nil {
+		return err
+	}
+
+	var newShares [][]byte
+	out := cmd.OutOrStdout()
+	err = submitShares(cmd.InOrStdin(), out, func(share []byte) (vault.ShamirProgress, error) {
+		progress, issued, err := keyProvider.SubmitRekeyShare(share)
+		newShares = issued
+		return progress, err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "\n✓ Re-key complete; previous shares are no longer valid")
+	fmt.Fprintln(out)
+	printShares(out, newShares)
+	fmt.Fprintf(out, "\n%d of these %d shares are required to unseal the vault.\n", shamirThreshold, len(newShares))
+	return nil
+}
+
+// submitShares reads base64 shares line by line and hands each to submit,
+// reporting progress, until the ceremony completes.
+func submitShares(in io.Reader, out io.Writer, submit func([]byte) (vault.ShamirProgress, error)) error {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "Enter share: ")
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return fmt.Errorf("failed to read share: %w", err)
+			}
+			return errInputEnded
+		}
+
+		share, err := base64.StdEncoding.DecodeString(strings.TrimSpace(scanner.Text()))
+		if err != nil {
+			return fmt.Errorf("invalid share encoding: %w", err)
+		}
+
+		progress, err := submit(share)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Accepted share %d of %d\n", progress.Submitted, progress.Threshold)
+		if progress.Complete() {
+			return nil
+		}
+	}
+}
+
+func printShares(out io.Writer, shares [][]byte) {
+	for i, share := range shares {
+		fmt.Fprintf(out, "Share %d: %s\n", i+1, base64.StdEncoding.EncodeToString(share))
+	}
+}
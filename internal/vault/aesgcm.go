@@ -5,118 +5,298 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// aesgcmStore implements Store using AES-256-GCM envelope encryption.
+// aesgcmStore implements Store using AES-256-GCM envelope encryption: each
+// secret is encrypted under its own data key, which is wrapped by the master key.
 type aesgcmStore struct {
 	keyProvider KeyProvider
 	auditHook   AuditHook
+	auditGate   func() error // Refuses operations when auditing is unavailable; may be nil
+	keyring     *Keyring
 	mu          sync.RWMutex
-	secrets     map[string][]byte // Encrypted values stored in memory
+	secrets     map[string]*secretEntry // Encrypted version history per key
+	revision    int64                   // Store-wide revision, bumped by every commit
+	path        string                  // Backing file; empty for memory-only stores
+	lock        *os.File                // Held lock on the backing file's sidecar
+	closed      bool                    // Set by Close; the backing file is no longer ours
+	policy      NamespacePolicy         // Nil allows every operation
+	watchers    watchers
+
+	keepVersions    int         // Versions retained per secret; zero or less keeps all
+	strictEnvelopes bool        // Refuse to open envelopes older than version 3
+	legacyEntries   atomic.Bool // Unsigned entries from a pre-v3 file remain; see verifyEntry
+
+	// Seal state; stateMu is always acquired after mu when both are held.
+	stateMu        sync.Mutex
+	sealed         bool
+	autoSeal       time.Duration
+	lastUsed       time.Time
+	idleTimer      *time.Timer
+	holdsCoreDumps bool // Whether this store has core dumps disabled
 }
 
 // NewAESGCMStore creates a new Store using AES-GCM encryption.
-func NewAESGCMStore(keyProvider KeyProvider, auditHook AuditHook) Store {
+func NewAESGCMStore(keyProvider KeyProvider, auditHook AuditHook, opts ...StoreOption) Store {
 	if auditHook == nil {
 		auditHook = func(AuditEvent) {} // No-op hook
 	}
-	return &aesgcmStore{
+	s := &aesgcmStore{
 		keyProvider: keyProvider,
 		auditHook:   auditHook,
-		secrets:     make(map[string][]byte),
+		keyring:     NewKeyring(),
+		secrets:     make(map[string]*secretEntry),
+
+		keepVersions: DefaultVersionRetention,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// addAuditGate makes the store also refuse operations while gate returns an
+// error.
+func (s *aesgcmStore) addAuditGate(gate func() error) {
+	previous := s.auditGate
+	if previous == nil {
+		s.auditGate = gate
+		return
+	}
+	s.auditGate = func() error {
+		if err := previous(); err != nil {
+			return err
+		}
+		return gate()
+	}
+}
+
+// errLegacyEnvelope is returned in strict mode for entries sealed in a format
+// without key-name binding.
+var errLegacyEnvelope = errors.New("envelope predates key-name binding and strict mode is on")
+
+// WithStrictEnvelopes makes the store refuse entries in any format older than
+// version 3, and entries without a MAC even in a store file older than version
+// 3, so neither an old ciphertext nor a file rewritten in an old format can be
+// swapped in. Run Migrate before enabling it; legacy entries cannot be read,
+// or migrated, afterwards.
+func WithStrictEnvelopes() StoreOption {
+	return func(s *aesgcmStore) {
+		s.strictEnvelopes = true
 	}
 }
 
 func (s *aesgcmStore) Put(ctx context.Context, key string, value []byte) error {
+	_, err := s.put(ctx, key, value, nil, anyRevision)
+	return err
+}
+
+// put stores value as a new version of key and returns the new revision. A nil
+// meta keeps the metadata the secret already has, except the expiry, which
+// applied to the old value. Unless ifRevision is anyRevision, the write fails
+// with ErrConflict when the secret's revision differs from it.
+func (s *aesgcmStore) put(ctx context.Context, key string, value []byte, meta *SecretMetadata, ifRevision int64) (int64, error) {
 	start := time.Now()
 	event := AuditEvent{
 		Timestamp: start,
 		Operation: "put",
 		Key:       key,
 	}
-	defer func() { s.auditHook(event) }()
+	defer func() { s.audit(ctx, event) }()
 
-	if key == "" {
+	if err := s.checkUnsealed(); err != nil {
 		event.Success = false
-		event.Error = ErrKeyEmpty.Error()
-		return ErrKeyEmpty
+		event.Error = err.Error()
+		return 0, err
+	}
+	// Only new keys must be well-formed; that is checked under the lock.
+	keyErr := validateKey(key)
+	if errors.Is(keyErr, ErrKeyEmpty) {
+		event.Success = false
+		event.Error = keyErr.Error()
+		return 0, keyErr
+	}
+	if err := s.authorize(ctx, event.Operation, key); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return 0, err
 	}
 	if len(value) == 0 {
 		event.Success = false
 		event.Error = ErrValueEmpty.Error()
-		return ErrValueEmpty
+		return 0, ErrValueEmpty
+	}
+	if meta != nil {
+		if err := meta.validate(); err != nil {
+			event.Success = false
+			event.Error = err.Error()
+			return 0, err
+		}
 	}
 
-	masterKey, err := s.keyProvider.GetKey(ctx)
+	keyID, err := s.activeKey(ctx)
+	if errors.Is(err, ErrSealed) {
+		event.Success = false
+		event.Error = err.Error()
+		return 0, err
+	}
 	if err != nil {
 		event.Success = false
 		event.Error = fmt.Sprintf("%v: %v", ErrKeyProvider, err)
-		return fmt.Errorf("%w: %v", ErrKeyProvider, err)
+		return 0, fmt.Errorf("%w: %v", ErrKeyProvider, err)
 	}
 
-	encrypted, err := s.encrypt(masterKey, value)
+	encrypted, err := s.seal(key, keyID, value)
 	if err != nil {
 		event.Success = false
 		event.Error = fmt.Sprintf("%v: %v", ErrEncryption, err)
-		return fmt.Errorf("%w: %v", ErrEncryption, err)
+		return 0, fmt.Errorf("%w: %v", ErrEncryption, err)
 	}
 
 	s.mu.Lock()
-	s.secrets[key] = encrypted
+	previous := s.secrets[key]
+	if previous == nil && keyErr != nil {
+		s.mu.Unlock()
+		event.Success = false
+		event.Error = keyErr.Error()
+		return 0, keyErr
+	}
+	if err := checkRevision(key, previous, ifRevision); err != nil {
+		s.mu.Unlock()
+		event.Success = false
+		event.Error = err.Error()
+		return 0, err
+	}
+	if previous != nil {
+		// The new entry inherits the old one's metadata and history, so they
+		// must not be signed unless they are authentic.
+		if err := s.verifyEntry(key, previous); err != nil {
+			s.mu.Unlock()
+			event.Success = false
+			event.Error = err.Error()
+			return 0, err
+		}
+	}
+	entry := previous.withVersion(encrypted, time.Now(), ActorFromContext(ctx), s.keepVersions)
+	if meta != nil {
+		entry.Metadata = entry.Metadata.replacedBy(*meta)
+	} else {
+		entry.Metadata.ExpiresAt = time.Time{}
+	}
+	revision, err := s.commitLocked(map[string]*secretEntry{key: entry})
 	s.mu.Unlock()
+	if err != nil {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", ErrPersistence, err)
+		return 0, fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
 
 	event.Success = true
-	event.Metadata = map[string]string{"size": fmt.Sprintf("%d", len(value))}
-	return nil
+	event.Metadata = map[string]string{
+		"size":     fmt.Sprintf("%d", len(value)),
+		"version":  fmt.Sprintf("%d", entry.current().Number),
+		"revision": fmt.Sprintf("%d", revision),
+	}
+	if t := entry.Metadata.Type; t != "" {
+		event.Metadata["type"] = string(t)
+	}
+	return revision, nil
 }
 
 func (s *aesgcmStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, _, err := s.get(ctx, key)
+	return value, err
+}
+
+func (s *aesgcmStore) GetSecret(ctx context.Context, key string) (*SecretValue, error) {
+	value, _, err := s.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return newSecretValue(value)
+}
+
+// get returns the current value of key together with its revision.
+func (s *aesgcmStore) get(ctx context.Context, key string) ([]byte, int64, error) {
 	start := time.Now()
 	event := AuditEvent{
 		Timestamp: start,
 		Operation: "get",
 		Key:       key,
 	}
-	defer func() { s.auditHook(event) }()
+	defer func() { s.audit(ctx, event) }()
 
+	if err := s.checkUnsealed(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, 0, err
+	}
 	if key == "" {
 		event.Success = false
 		event.Error = ErrKeyEmpty.Error()
-		return nil, ErrKeyEmpty
+		return nil, 0, ErrKeyEmpty
+	}
+	if err := s.authorize(ctx, event.Operation, key); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, 0, err
 	}
 
 	s.mu.RLock()
-	encrypted, exists := s.secrets[key]
+	entry, exists := s.secrets[key]
 	s.mu.RUnlock()
 
 	if !exists {
 		event.Success = false
 		event.Error = ErrNotFound.Error()
-		return nil, ErrNotFound
+		return nil, 0, ErrNotFound
 	}
 
-	masterKey, err := s.keyProvider.GetKey(ctx)
-	if err != nil {
+	// Make sure the keyring is loaded before opening the entry.
+	if _, err := s.activeKey(ctx); errors.Is(err, ErrSealed) {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, 0, err
+	} else if err != nil {
 		event.Success = false
 		event.Error = fmt.Sprintf("%v: %v", ErrKeyProvider, err)
-		return nil, fmt.Errorf("%w: %v", ErrKeyProvider, err)
+		return nil, 0, fmt.Errorf("%w: %v", ErrKeyProvider, err)
+	}
+
+	if err := s.verifyEntry(key, entry); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, 0, err
+	}
+	if entry.Metadata.expired(time.Now()) {
+		event.Success = false
+		event.Error = ErrExpired.Error()
+		return nil, 0, ErrExpired
 	}
 
-	plaintext, err := s.decrypt(masterKey, encrypted)
+	current := entry.current()
+	plaintext, _, err := s.open(key, current.Data)
 	if err != nil {
 		event.Success = false
 		event.Error = fmt.Sprintf("%v: %v", ErrDecryption, err)
-		return nil, fmt.Errorf("%w: %v", ErrDecryption, err)
+		return nil, 0, fmt.Errorf("%w: %v", ErrDecryption, err)
 	}
 
 	event.Success = true
-	event.Metadata = map[string]string{"size": fmt.Sprintf("%d", len(plaintext))}
-	return plaintext, nil
+	event.Metadata = map[string]string{
+		"size":     fmt.Sprintf("%d", len(plaintext)),
+		"version":  fmt.Sprintf("%d", current.Number),
+		"revision": fmt.Sprintf("%d", entry.Revision),
+	}
+	return plaintext, entry.Revision, nil
 }
 
 func (s *aesgcmStore) Delete(ctx context.Context, key string) error {
@@ -126,13 +306,29 @@ func (s *aesgcmStore) Delete(ctx context.Context, key string) error {
 		Operation: "delete",
 		Key:       key,
 	}
-	defer func() { s.auditHook(event) }()
+	defer func() { s.audit(ctx, event) }()
 
+	if err := s.checkUnsealed(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
 	if key == "" {
 		event.Success = false
 		event.Error = ErrKeyEmpty.Error()
 		return ErrKeyEmpty
 	}
+	if err := s.authorize(ctx, event.Operation, key); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
+	// Writing the file signs any legacy entries still unsigned.
+	if err := s.loadKeys(ctx); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -143,8 +339,14 @@ func (s *aesgcmStore) Delete(ctx context.Context, key string) error {
 		return ErrNotFound
 	}
 
-	delete(s.secrets, key)
+	revision, err := s.commitLocked(map[string]*secretEntry{key: nil})
+	if err != nil {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", ErrPersistence, err)
+		return fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
 	event.Success = true
+	event.Metadata = map[string]string{"revision": fmt.Sprintf("%d", revision)}
 	return nil
 }
 
@@ -154,15 +356,28 @@ func (s *aesgcmStore) List(ctx context.Context) ([]string, error) {
 		Timestamp: start,
 		Operation: "list",
 	}
-	defer func() { s.auditHook(event) }()
+	defer func() { s.audit(ctx, event) }()
+
+	if err := s.checkUnsealed(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	}
 
 	s.mu.RLock()
-	keys := make([]string, 0, len(s.secrets))
+	all := make([]string, 0, len(s.secrets))
 	for k := range s.secrets {
-		keys = append(keys, k)
+		all = append(all, k)
 	}
 	s.mu.RUnlock()
 
+	allowed := s.permits(ctx, "list")
+	keys := make([]string, 0, len(all))
+	for _, k := range all {
+		if allowed(k) {
+			keys = append(keys, k)
+		}
+	}
 	sort.Strings(keys)
 	event.Success = true
 	event.Metadata = map[string]string{"count": fmt.Sprintf("%d", len(keys))}
@@ -175,7 +390,23 @@ func (s *aesgcmStore) HealthCheck(ctx context.Context) error {
 		Timestamp: start,
 		Operation: "health",
 	}
-	defer func() { s.auditHook(event) }()
+	defer func() { s.audit(ctx, event) }()
+
+	if s.Sealed() {
+		event.Success = false
+		event.Error = ErrSealed.Error()
+		event.Metadata = map[string]string{"state": "sealed"}
+		return ErrSealed
+	}
+	event.Metadata = map[string]string{"state": "unsealed"}
+
+	if s.auditGate != nil {
+		if err := s.auditGate(); err != nil {
+			event.Success = false
+			event.Error = err.Error()
+			return err
+		}
+	}
 
 	if err := s.keyProvider.HealthCheck(ctx); err != nil {
 		event.Success = false
@@ -184,7 +415,8 @@ func (s *aesgcmStore) HealthCheck(ctx context.Context) error {
 	}
 
 	// Verify we can get the master key
-	_, err := s.keyProvider.GetKey(ctx)
+	key, err := s.keyProvider.GetKey(ctx)
+	wipe(key)
 	if err != nil {
 		event.Success = false
 		event.Error = fmt.Sprintf("%v: %v", ErrUnhealthy, err)
@@ -195,10 +427,231 @@ func (s *aesgcmStore) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
+// currentKey fetches the provider's master key, makes it the active keyring key,
+// and returns its ID. Retired keys are loaded whenever the active key changes
+// so entries sealed before a rotation stay readable. The provider's copies are
+// wiped once they are in the keyring, and core dumps are disabled from the
+// first key on. Callers must hold s.stateMu.
+func (s *aesgcmStore) currentKey(ctx context.Context) (string, error) {
+	key, err := s.keyProvider.GetKey(ctx)
+	defer wipe(key)
+	if err != nil {
+		return "", err
+	}
+	if !s.holdsCoreDumps {
+		coreDumps.acquire()
+		s.holdsCoreDumps = true
+	}
+	id, err := s.keyring.Add(key)
+	if err != nil {
+		return "", err
+	}
+	if active, err := s.keyring.ActiveID(); err == nil && active == id {
+		return id, nil
+	}
+
+	if retired, ok := s.keyProvider.(RetiredKeyProvider); ok {
+		keys, err := retired.RetiredKeys(ctx)
+		defer func() {
+			for _, k := range keys {
+				wipe(k)
+			}
+		}()
+		if err != nil {
+			return "", fmt.Errorf("failed to load retired keys: %w", err)
+		}
+		for _, k := range keys {
+			if _, err := s.keyring.Add(k); err != nil {
+				return "", fmt.Errorf("invalid retired key: %w", err)
+			}
+		}
+	}
+
+	if err := s.keyring.SetActive(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// seal encrypts plaintext under a fresh random data key and wraps that data key
+// with the keyring key keyID, producing a version 3 envelope bound to name. The
+// master key never touches the secret itself.
+func (s *aesgcmStore) seal(name, keyID string, plaintext []byte) ([]byte, error) {
+	dek, err := newDataKey()
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(dek)
+
+	payload, err := s.encryptWithAD(dek, plaintext, currentEnvelope(keyID).payloadAD(name))
+	if err != nil {
+		return nil, err
+	}
+	return s.wrap(name, keyID, dek, payload)
+}
+
+// wrap seals dek with the keyring key keyID and assembles the envelope around
+// an already encrypted payload. The payload must have been sealed with the
+// current envelope's payload additional data for name.
+func (s *aesgcmStore) wrap(name, keyID string, dek, payload []byte) ([]byte, error) {
+	env := currentEnvelope(keyID)
+	var wrapped []byte
+	err := s.keyring.use(keyID, func(key []byte) error {
+		var err error
+		wrapped, err = s.encryptWithAD(key, dek, env.wrapAD(name))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	env.WrappedKey = wrapped
+	env.Payload = payload
+	return env.marshal()
+}
+
+// unwrap returns the plaintext data key and encrypted payload of the entry
+// stored under name so it can be re-wrapped under another master key without
+// decrypting the secret. Entries in older formats are upgraded in memory: their
+// plaintext is re-encrypted under a fresh data key with the current additional
+// data. Callers must wipe dek.
+func (s *aesgcmStore) unwrap(name string, data []byte) (dek, payload []byte, err error) {
+	if env, err := parseEnvelope(data); err == nil && env.Version == envelopeVersion3 {
+		dek, err := s.unwrapDataKey(name, env)
+		if err != nil {
+			return nil, nil, err
+		}
+		return dek, env.Payload, nil
+	}
+
+	plaintext, _, err := s.open(name, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer wipe(plaintext)
+
+	dek, err = newDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	// The payload additional data does not cover the key ID, so none is needed here.
+	payload, err = s.encryptWithAD(dek, plaintext, currentEnvelope("").payloadAD(name))
+	if err != nil {
+		wipe(dek)
+		return nil, nil, err
+	}
+	return dek, payload, nil
+}
+
+// open decrypts the entry stored under name with whichever keyring key sealed
+// it and returns the plaintext along with that key's ID. Legacy headerless
+// entries are tried against every key in the ring and report an empty key ID.
+// Version 3 entries fail to open if they were moved from another name. In
+// strict mode only version 3 entries open at all.
+func (s *aesgcmStore) open(name string, data []byte) ([]byte, string, error) {
+	if err := s.checkEnvelope(data); err != nil {
+		return nil, "", err
+	}
+	if env, err := parseEnvelope(data); err == nil {
+		if env.Version == envelopeVersion1 {
+			var plaintext []byte
+			err := s.keyring.use(env.KeyID, func(key []byte) error {
+				var err error
+				plaintext, err = s.decrypt(key, env.Payload)
+				return err
+			})
+			if err != nil {
+				return nil, "", err
+			}
+			return plaintext, env.KeyID, nil
+		}
+
+		dek, err := s.unwrapDataKey(name, env)
+		if err != nil {
+			return nil, "", err
+		}
+		defer wipe(dek)
+
+		plaintext, err := s.decryptWithAD(dek, env.Payload, env.payloadAD(name))
+		if err != nil {
+			return nil, "", err
+		}
+		return plaintext, env.KeyID, nil
+	}
+
+	for _, id := range s.keyring.IDs() {
+		var plaintext []byte
+		err := s.keyring.use(id, func(key []byte) error {
+			var err error
+			plaintext, err = s.decrypt(key, data)
+			return err
+		})
+		if err == nil {
+			return plaintext, "", nil
+		}
+	}
+	return nil, "", fmt.Errorf("no key in keyring opens legacy entry")
+}
+
+// checkEnvelope returns errLegacyEnvelope in strict mode unless data is a
+// version 3 envelope.
+func (s *aesgcmStore) checkEnvelope(data []byte) error {
+	if !s.strictEnvelopes {
+		return nil
+	}
+	if env, err := parseEnvelope(data); err != nil || env.Version != envelopeVersion3 {
+		return errLegacyEnvelope
+	}
+	return nil
+}
+
+// unwrapDataKey decrypts the data key of a version 2 or 3 envelope stored under
+// name with the keyring key that wrapped it. Callers must wipe the result.
+func (s *aesgcmStore) unwrapDataKey(name string, env envelope) ([]byte, error) {
+	var dek []byte
+	err := s.keyring.use(env.KeyID, func(key []byte) error {
+		var err error
+		dek, err = s.decryptWithAD(key, env.WrappedKey, env.wrapAD(name))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dek, nil
+}
+
+// currentEnvelope returns the header of the format new entries are written in.
+func currentEnvelope(keyID string) envelope {
+	return envelope{
+		Version:   envelopeVersion3,
+		Algorithm: algAES256GCM,
+		KeyID:     keyID,
+	}
+}
+
+// newDataKey generates a random 32-byte AES-256 data key.
+func newDataKey() ([]byte, error) {
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return dek, nil
+}
+
 // encrypt uses AES-256-GCM to encrypt plaintext with the master key.
 // Returns: nonce || ciphertext || tag
 func (s *aesgcmStore) encrypt(masterKey, plaintext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(masterKey)
+	return s.encryptWithAD(masterKey, plaintext, nil)
+}
+
+// decrypt uses AES-256-GCM to decrypt ciphertext with the master key.
+// Expects: nonce || ciphertext || tag
+func (s *aesgcmStore) decrypt(masterKey, ciphertext []byte) ([]byte, error) {
+	return s.decryptWithAD(masterKey, ciphertext, nil)
+}
+
+// encryptWithAD is encrypt with additional authenticated data.
+func (s *aesgcmStore) encryptWithAD(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
@@ -214,14 +667,14 @@ func (s *aesgcmStore) encrypt(masterKey, plaintext []byte) ([]byte, error) {
 	}
 
 	// Seal prepends nonce and appends tag
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+	ciphertext := gcm.Seal(nonce, nonce, plaintext, additionalData)
 	return ciphertext, nil
 }
 
-// decrypt uses AES-256-GCM to decrypt ciphertext with the master key.
-// Expects: nonce || ciphertext || tag
-func (s *aesgcmStore) decrypt(masterKey, ciphertext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(masterKey)
+// decryptWithAD is decrypt with additional authenticated data. Decryption fails
+// unless additionalData matches what was supplied at encryption time.
+func (s *aesgcmStore) decryptWithAD(key, ciphertext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
@@ -237,7 +690,7 @@ func (s *aesgcmStore) decrypt(masterKey, ciphertext []byte) ([]byte, error) {
 	}
 
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		return nil, err
 	}
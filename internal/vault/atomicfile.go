@@ -0,0 +1,81 @@
+package vault
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// tempSuffix marks in-progress writes so they can be cleaned up after a crash.
+const tempSuffix = ".tmp-"
+
+// writeFileAtomic replaces path with data so that readers observe either the
+// previous contents or the new contents, never a partial write. The data is
+// written to a temporary file in the same directory, fsynced, renamed over the
+// destination, and the directory entry is fsynced to make the rename durable.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+tempSuffix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	// Remove the temp file on any failure path; after a successful rename this is a no-op.
+	defer os.Remove(tmpPath)
+
+	if err := tmp.Chmod(perm); err != nil && runtime.GOOS != "windows" {
+		tmp.Close()
+		return fmt.Errorf("failed to set temp file permissions: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir flushes directory metadata so a preceding rename survives power loss.
+// Windows does not support fsync on directories, so it is skipped there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
+
+// removeStaleTempFiles deletes temp files left behind by writes that were
+// interrupted before their rename.
+func removeStaleTempFiles(path string) error {
+	matches, err := filepath.Glob(path + tempSuffix + "*")
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		if err := os.Remove(m); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale temp file %s: %w", m, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+)
+
+// Component identifies the part of CloudMoor an operation originated from.
+type Component string
+
+// Components that call into the vault.
+const (
+	ComponentCLI       Component = "cli"
+	ComponentDaemon    Component = "daemon"
+	ComponentScheduler Component = "scheduler"
+)
+
+// RequestIDHeader is the HTTP header carrying a request ID between clients and
+// the daemon API.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestInfo says who is performing a vault operation and from where. It is
+// carried in a context.Context and copied onto every audit event the store
+// emits for operations run under that context.
+type RequestInfo struct {
+	Actor      string    `json:"actor,omitempty"`
+	AuthMethod string    `json:"auth_method,omitempty"` // e.g. "token", "mtls", "local"
+	ClientAddr string    `json:"client_addr,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Component  Component `json:"component,omitempty"`
+}
+
+// requestInfoKey is the context key under which RequestInfo is stored.
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a copy of ctx carrying info, replacing any
+// RequestInfo already there.
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFromContext returns the RequestInfo carried by ctx, or the zero
+// value if there is none.
+func RequestInfoFromContext(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info
+}
+
+// WithActor returns a copy of ctx that attributes vault operations to actor.
+// The actor is recorded on every secret version written under ctx.
+func WithActor(ctx context.Context, actor string) context.Context {
+	info := RequestInfoFromContext(ctx)
+	info.Actor = actor
+	return WithRequestInfo(ctx, info)
+}
+
+// ActorFromContext returns the actor set by WithActor, or "" if there is none.
+func ActorFromContext(ctx context.Context) string {
+	return RequestInfoFromContext(ctx).Actor
+}
+
+// WithAuthenticatedActor records the actor an authentication layer verified
+// and how, keeping the request details already in ctx.
+func WithAuthenticatedActor(ctx context.Context, actor, authMethod string) context.Context {
+	info := RequestInfoFromContext(ctx)
+	info.Actor = actor
+	info.AuthMethod = authMethod
+	return WithRequestInfo(ctx, info)
+}
+
+// WithComponent returns a copy of ctx whose operations are attributed to
+// component.
+func WithComponent(ctx context.Context, component Component) context.Context {
+	info := RequestInfoFromContext(ctx)
+	info.Component = component
+	return WithRequestInfo(ctx, info)
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestInfoMiddleware attaches the client address, a request ID and
+// component to the context of every request handled by next, and echoes the
+// request ID in the response. A valid incoming RequestIDHeader is kept so IDs
+// can be correlated across services. Authentication middleware further down
+// the chain should call WithAuthenticatedActor once it knows the caller.
+func RequestInfoMiddleware(component Component, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = NewRequestID()
+		}
+		clientAddr := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(clientAddr); err == nil {
+			clientAddr = host
+		}
+
+		info := RequestInfoFromContext(r.Context())
+		info.ClientAddr = clientAddr
+		info.RequestID = requestID
+		info.Component = component
+
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(WithRequestInfo(r.Context(), info)))
+	})
+}
+
+// validRequestID accepts short IDs of printable, non-space ASCII so a client
+// cannot inject arbitrary text into audit records.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestInfo returns event stamped with the request details in ctx.
+func withRequestInfo(ctx context.Context, event AuditEvent) AuditEvent {
+	info := RequestInfoFromContext(ctx)
+	event.Actor = info.Actor
+	event.AuthMethod = info.AuthMethod
+	event.ClientAddr = info.ClientAddr
+	event.RequestID = info.RequestID
+	event.Component = info.Component
+	return event
+}
+
+// audit stamps event with the request details in ctx and emits it.
+func (s *aesgcmStore) audit(ctx context.Context, event AuditEvent) {
+	s.auditHook(withRequestInfo(ctx, event))
+}
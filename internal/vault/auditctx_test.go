@@ -0,0 +1,124 @@
+package vault
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestInfoContext(t *testing.T) {
+	ctx := context.Background()
+	require.Equal(t, RequestInfo{}, RequestInfoFromContext(ctx))
+
+	ctx = WithRequestInfo(ctx, RequestInfo{RequestID: "req-1", ClientAddr: "10.0.0.1", Component: ComponentDaemon})
+	ctx = WithAuthenticatedActor(ctx, "alice", "token")
+	require.Equal(t, RequestInfo{
+		Actor:      "alice",
+		AuthMethod: "token",
+		ClientAddr: "10.0.0.1",
+		RequestID:  "req-1",
+		Component:  ComponentDaemon,
+	}, RequestInfoFromContext(ctx), "authentication keeps the request details")
+
+	ctx = WithActor(ctx, "bob")
+	require.Equal(t, "bob", ActorFromContext(ctx))
+	require.Equal(t, "req-1", RequestInfoFromContext(ctx).RequestID)
+}
+
+func TestRequestInfoMiddleware(t *testing.T) {
+	var got RequestInfo
+	handler := RequestInfoMiddleware(ComponentDaemon, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestInfoFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{name: "incoming ID is kept", header: "abc-123", keep: true},
+		{name: "missing ID is generated"},
+		{name: "unsafe ID is replaced", header: "abc\n{\"forged\":true}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/secrets", nil)
+			req.RemoteAddr = "192.0.2.7:51234"
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, "192.0.2.7", got.ClientAddr)
+			require.Equal(t, ComponentDaemon, got.Component)
+			require.NotEmpty(t, got.RequestID)
+			require.Equal(t, got.RequestID, rec.Header().Get(RequestIDHeader))
+			if tt.keep {
+				require.Equal(t, tt.header, got.RequestID)
+			} else {
+				require.NotEqual(t, tt.header, got.RequestID)
+			}
+		})
+	}
+}
+
+func TestAESGCMStore_AuditRequestInfo(t *testing.T) {
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var auditEvents []AuditEvent
+	store := NewAESGCMStore(keyProvider, func(e AuditEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		auditEvents = append(auditEvents, e)
+	}).(*aesgcmStore)
+
+	info := RequestInfo{
+		Actor:      "alice",
+		AuthMethod: "token",
+		ClientAddr: "192.0.2.7",
+		RequestID:  "req-1",
+		Component:  ComponentDaemon,
+	}
+	ctx := WithRequestInfo(context.Background(), info)
+	require.NoError(t, store.Put(ctx, "token", []byte("v")))
+	_, err = store.Get(ctx, "token")
+	require.NoError(t, err)
+	_, err = store.Get(ctx, "missing")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, store.Seal(ctx))
+
+	require.Len(t, auditEvents, 4)
+	for _, e := range auditEvents {
+		require.Equal(t, info, RequestInfo{
+			Actor:      e.Actor,
+			AuthMethod: e.AuthMethod,
+			ClientAddr: e.ClientAddr,
+			RequestID:  e.RequestID,
+			Component:  e.Component,
+		}, e.Operation)
+	}
+
+	t.Run("sweeper", func(t *testing.T) {
+		require.NoError(t, store.Unseal(context.Background()))
+		require.NoError(t, store.PutWithMetadata(context.Background(), "session", []byte("s"), SecretMetadata{ExpiresAt: time.Now().Add(-time.Second)}))
+
+		sweepCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go RunExpirySweeper(sweepCtx, store, 10*time.Millisecond)
+
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			last := auditEvents[len(auditEvents)-1]
+			return last.Operation == "expired" && last.Component == ComponentScheduler
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
@@ -0,0 +1,496 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The audit log is a JSON-lines file. Every line holds a sequence number, the
+// SHA-256 of the previous line and either an audit event or a checkpoint, so
+// editing, reordering or removing a line breaks the chain at that point.
+// Checkpoints sign the chain head with an HMAC or ed25519 key; someone who
+// rewrites every line after an edit still cannot produce a valid checkpoint.
+// Records after the last checkpoint are only protected by the chain. A
+// verifier holding the key refuses a log with no checkpoint or with more
+// unsigned records than the checkpoint interval, but truncation back to a
+// checkpoint goes unnoticed, so keep the head from the last verification
+// elsewhere if that matters.
+
+// DefaultAuditCheckpointEvery is how many records are written between
+// checkpoints when AuditLogOptions.CheckpointEvery is zero.
+const DefaultAuditCheckpointEvery = 100
+
+// auditGenesis is the previous-record hash of the first record.
+var auditGenesis = strings.Repeat("0", sha256.Size*2)
+
+// AuditSigner signs audit log checkpoints.
+type AuditSigner interface {
+	Algorithm() string
+	Sign(message []byte) ([]byte, error)
+}
+
+// AuditVerifier checks audit log checkpoint signatures.
+type AuditVerifier interface {
+	Algorithm() string
+	Verify(message, signature []byte) bool
+}
+
+// HMACAuditKey signs and verifies checkpoints with HMAC-SHA256. The same key
+// is needed to verify, so it must be kept away from whoever can write the log.
+type HMACAuditKey []byte
+
+// Algorithm returns "hmac-sha256".
+func (k HMACAuditKey) Algorithm() string { return "hmac-sha256" }
+
+// Sign returns the HMAC of message.
+func (k HMACAuditKey) Sign(message []byte) ([]byte, error) {
+	if len(k) < 32 {
+		return nil, fmt.Errorf("vault: HMAC audit key must be at least 32 bytes")
+	}
+	mac := hmac.New(sha256.New, k)
+	mac.Write(message)
+	return mac.Sum(nil), nil
+}
+
+// Verify reports whether signature is the HMAC of message.
+func (k HMACAuditKey) Verify(message, signature []byte) bool {
+	expected, err := k.Sign(message)
+	return err == nil && hmac.Equal(expected, signature)
+}
+
+// Ed25519AuditSigner signs checkpoints with an ed25519 private key. It also
+// verifies them, but verifiers only need the public key.
+type Ed25519AuditSigner ed25519.PrivateKey
+
+// Algorithm returns "ed25519".
+func (k Ed25519AuditSigner) Algorithm() string { return "ed25519" }
+
+// Sign returns the ed25519 signature of message.
+func (k Ed25519AuditSigner) Sign(message []byte) ([]byte, error) {
+	if len(k) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("vault: invalid ed25519 audit key")
+	}
+	return ed25519.Sign(ed25519.PrivateKey(k), message), nil
+}
+
+// Verify checks signature against the signer's public key.
+func (k Ed25519AuditSigner) Verify(message, signature []byte) bool {
+	if len(k) != ed25519.PrivateKeySize {
+		return false
+	}
+	public := ed25519.PrivateKey(k).Public().(ed25519.PublicKey)
+	return Ed25519AuditVerifier(public).Verify(message, signature)
+}
+
+// Ed25519AuditVerifier verifies checkpoints with an ed25519 public key.
+type Ed25519AuditVerifier ed25519.PublicKey
+
+// Algorithm returns "ed25519".
+func (k Ed25519AuditVerifier) Algorithm() string { return "ed25519" }
+
+// Verify reports whether signature is a valid ed25519 signature of message.
+func (k Ed25519AuditVerifier) Verify(message, signature []byte) bool {
+	return len(k) == ed25519.PublicKeySize && ed25519.Verify(ed25519.PublicKey(k), message, signature)
+}
+
+// auditRecord is one line of the audit log. Exactly one of Event and
+// Checkpoint is set.
+type auditRecord struct {
+	Seq        uint64           `json:"seq"`
+	Prev       string           `json:"prev"` // Hex SHA-256 of the previous line
+	Event      *AuditEvent      `json:"event,omitempty"`
+	Checkpoint *auditCheckpoint `json:"checkpoint,omitempty"`
+}
+
+// auditCheckpoint signs the chain up to the record before it.
+type auditCheckpoint struct {
+	Algorithm string    `json:"alg"`
+	Time      time.Time `json:"time"`
+	Signature []byte    `json:"sig"`
+}
+
+// checkpointMessage is what a checkpoint at seq signs: the hash of every
+// record before it, by way of the chain.
+func checkpointMessage(seq uint64, prev string, at time.Time) []byte {
+	return []byte(fmt.Sprintf("cloudmoor-audit-checkpoint/v1\n%d\n%s\n%s", seq, prev, at.UTC().Format(time.RFC3339Nano)))
+}
+
+// hashLine returns the chain hash of a log line without its newline.
+func hashLine(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditLogOptions configures an AuditLog.
+type AuditLogOptions struct {
+	// Signer signs checkpoints. Without one the log is hash-chained only.
+	Signer AuditSigner
+
+	// CheckpointEvery writes a checkpoint after this many events; zero uses
+	// DefaultAuditCheckpointEvery.
+	CheckpointEvery int
+
+	// CheckpointInterval also writes a checkpoint with the first event after
+	// this much time has passed since the previous one; zero disables it.
+	CheckpointInterval time.Duration
+}
+
+// AuditLog is a durable, tamper-evident audit sink that appends events to a
+// hash-chained JSON-lines file. It is safe for concurrent use.
+//
+// A failed write may leave a partial record behind, so after the first one the
+// log refuses further appends; Err reports it, and a store opened with
+// WithAuditLog refuses operations from then on.
+type AuditLog struct {
+	mu              sync.Mutex
+	f               *os.File
+	lock            *os.File // Held lock on the file's sidecar
+	path            string
+	opts            AuditLogOptions
+	seq             uint64 // Sequence number of the last record written
+	prev            string // Hash of the last record written
+	sinceCheckpoint int
+	lastCheckpoint  time.Time
+	err             error // First write failure; nothing is appended after it
+}
+
+// OpenAuditLog opens or creates the audit log at path and continues its chain.
+// A final record left incomplete by a crash is discarded; the rest of the file
+// is not checked, so use VerifyAuditLog for that.
+//
+// The log holds an exclusive lock on path+".lock" until Close, so a second
+// writer, in this process or another, fails with ErrStoreLocked instead of
+// forking the chain.
+func OpenAuditLog(path string, opts AuditLogOptions) (*AuditLog, error) {
+	if path == "" {
+		return nil, fmt.Errorf("vault: audit log path cannot be empty")
+	}
+	if opts.CheckpointEvery == 0 {
+		opts.CheckpointEvery = DefaultAuditCheckpointEvery
+	}
+
+	lock, err := lockStoreFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	l := &AuditLog{f: f, lock: lock, path: path, opts: opts, prev: auditGenesis, lastCheckpoint: time.Now()}
+	if err := l.recover(); err != nil {
+		f.Close()
+		lock.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// WithAuditLog also appends the store's audit events to l, after the store's
+// other audit hook, and refuses operations with ErrAuditUnavailable once l
+// cannot record them. Apply it after WithAuditPipeline, which replaces the
+// hook.
+func WithAuditLog(l *AuditLog) StoreOption {
+	return func(s *aesgcmStore) {
+		next, hook := s.auditHook, l.Hook()
+		s.auditHook = func(event AuditEvent) {
+			next(event)
+			hook(event)
+		}
+		s.addAuditGate(l.Err)
+	}
+}
+
+// recover reads the existing log to find the chain head.
+func (l *AuditLog) recover() error {
+	r := bufio.NewReader(l.f)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				// Torn write: no later record links to it, so dropping it
+				// leaves the chain intact.
+				if err := l.f.Truncate(offset); err != nil {
+					return fmt.Errorf("failed to truncate incomplete audit record: %w", err)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read audit log: %w", err)
+		}
+		offset += int64(len(line))
+		line = bytes.TrimSuffix(line, []byte("\n"))
+
+		var rec auditRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("%w: malformed record after seq %d: %v", ErrAuditTampered, l.seq, err)
+		}
+		l.seq = rec.Seq
+		l.prev = hashLine(line)
+		if rec.Checkpoint != nil {
+			l.sinceCheckpoint = 0
+			l.lastCheckpoint = rec.Checkpoint.Time
+		} else {
+			l.sinceCheckpoint++
+		}
+	}
+}
+
+// Append writes event to the log and syncs it to disk, adding a checkpoint
+// when one is due.
+func (l *AuditLog) Append(event AuditEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.writableLocked(); err != nil {
+		return err
+	}
+	l.err = l.appendLocked(event)
+	return l.err
+}
+
+// appendLocked does the work of Append. Callers must hold l.mu.
+func (l *AuditLog) appendLocked(event AuditEvent) error {
+	if err := l.writeLocked(auditRecord{Event: &event}); err != nil {
+		return err
+	}
+	l.sinceCheckpoint++
+
+	if l.opts.Signer != nil && (l.sinceCheckpoint >= l.opts.CheckpointEvery ||
+		(l.opts.CheckpointInterval > 0 && time.Since(l.lastCheckpoint) >= l.opts.CheckpointInterval)) {
+		if err := l.checkpointLocked(); err != nil {
+			return err
+		}
+	}
+	return l.syncLocked()
+}
+
+// Checkpoint signs the records written since the last checkpoint. It is a
+// no-op when there are none.
+func (l *AuditLog) Checkpoint() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.writableLocked(); err != nil {
+		return err
+	}
+	if l.opts.Signer == nil {
+		return fmt.Errorf("vault: audit log has no checkpoint signer")
+	}
+	if l.sinceCheckpoint == 0 {
+		return nil
+	}
+	if err := l.checkpointLocked(); err != nil {
+		l.err = err
+		return err
+	}
+	l.err = l.syncLocked()
+	return l.err
+}
+
+// Hook returns an AuditHook that appends to the log. AuditHook cannot return
+// errors; a failure is reported by Err from then on, and by Close.
+func (l *AuditLog) Hook() AuditHook {
+	return func(event AuditEvent) {
+		_ = l.Append(event)
+	}
+}
+
+// Err returns an error wrapping ErrAuditUnavailable once the log can no longer
+// record events, because a write failed or it has been closed, and nil before.
+func (l *AuditLog) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.writableLocked(); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrAuditUnavailable, l.Name(), err)
+	}
+	return nil
+}
+
+// writableLocked returns why nothing can be appended, if anything. Callers must
+// hold l.mu.
+func (l *AuditLog) writableLocked() error {
+	if l.f == nil {
+		return fmt.Errorf("vault: audit log is closed")
+	}
+	return l.err
+}
+
+// Close writes a final checkpoint if a signer is configured, closes the file
+// and releases its lock. It returns the write failure that stopped the log,
+// if any.
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+	err := l.err
+	if err == nil && l.opts.Signer != nil && l.sinceCheckpoint > 0 {
+		err = l.checkpointLocked()
+	}
+	if err == nil {
+		err = l.syncLocked()
+	}
+	if cerr := l.f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to close audit log: %w", cerr)
+	}
+	l.f = nil
+	l.lock.Close()
+	l.lock = nil
+	return err
+}
+
+// checkpointLocked appends a signed checkpoint. Callers must hold l.mu.
+func (l *AuditLog) checkpointLocked() error {
+	now := time.Now().UTC()
+	sig, err := l.opts.Signer.Sign(checkpointMessage(l.seq+1, l.prev, now))
+	if err != nil {
+		return fmt.Errorf("failed to sign audit checkpoint: %w", err)
+	}
+	err = l.writeLocked(auditRecord{Checkpoint: &auditCheckpoint{
+		Algorithm: l.opts.Signer.Algorithm(),
+		Time:      now,
+		Signature: sig,
+	}})
+	if err != nil {
+		return err
+	}
+	l.sinceCheckpoint = 0
+	l.lastCheckpoint = now
+	return nil
+}
+
+// writeLocked links rec into the chain and appends it. Callers must hold l.mu.
+func (l *AuditLog) writeLocked(rec auditRecord) error {
+	rec.Seq = l.seq + 1
+	rec.Prev = l.prev
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	l.seq = rec.Seq
+	l.prev = hashLine(line)
+	return nil
+}
+
+// syncLocked flushes the log to disk. Callers must hold l.mu.
+func (l *AuditLog) syncLocked() error {
+	if err := l.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	return nil
+}
+
+// AuditVerification summarises an audit log check.
+type AuditVerification struct {
+	Records     int    `json:"records"`     // Events in the intact part of the log
+	Checkpoints int    `json:"checkpoints"` // Checkpoints verified
+	Unsigned    int    `json:"unsigned"`    // Events after the last checkpoint
+	Head        string `json:"head"`        // Hash of the last intact record
+
+	// BrokenSeq and BrokenLine locate the first altered or missing record
+	// when verification fails; both are zero otherwise.
+	BrokenSeq  uint64 `json:"broken_seq,omitempty"`
+	BrokenLine int    `json:"broken_line,omitempty"`
+}
+
+// VerifyAuditLog checks the hash chain of an audit log and, when verifier is
+// not nil, the signature of every checkpoint. On failure it returns an error
+// wrapping ErrAuditTampered, and the result locates the first altered or
+// deleted record. Without a verifier only the chain is checked, which catches
+// careless edits but not a rewritten chain.
+//
+// With a verifier the log must also hold at least one checkpoint, and no more
+// than checkpointEvery records may follow the last one (zero means
+// DefaultAuditCheckpointEvery). Pass the CheckpointEvery the log was written
+// with: a writer checkpoints at least that often, so a longer unsigned tail
+// was not written by it.
+func VerifyAuditLog(r io.Reader, verifier AuditVerifier, checkpointEvery int) (AuditVerification, error) {
+	if checkpointEvery <= 0 {
+		checkpointEvery = DefaultAuditCheckpointEvery
+	}
+	var result AuditVerification
+	result.Head = auditGenesis
+
+	broken := func(seq uint64, line int, format string, args ...any) (AuditVerification, error) {
+		result.BrokenSeq = seq
+		result.BrokenLine = line
+		return result, fmt.Errorf("%w: record %d (line %d): %s", ErrAuditTampered, seq, line, fmt.Sprintf(format, args...))
+	}
+
+	br := bufio.NewReader(r)
+	expected := uint64(1)
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				return broken(expected, lineNo, "record is incomplete")
+			}
+			if verifier != nil && result.Checkpoints == 0 {
+				return result, fmt.Errorf("%w: log has no checkpoint, so none of it can be verified", ErrAuditTampered)
+			}
+			return result, nil
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+
+		var rec auditRecord
+		if err := json.Unmarshal(line, &rec); err != nil || (rec.Event == nil) == (rec.Checkpoint == nil) {
+			return broken(expected, lineNo, "record is malformed")
+		}
+		switch {
+		case rec.Seq > expected:
+			return broken(expected, lineNo, "records %d to %d are missing", expected, rec.Seq-1)
+		case rec.Seq < expected:
+			return broken(expected, lineNo, "found record %d out of sequence", rec.Seq)
+		}
+		if rec.Prev != result.Head {
+			// The previous line no longer hashes to the link this record holds.
+			if expected == 1 {
+				return broken(1, lineNo, "first record does not start the chain")
+			}
+			return broken(expected-1, lineNo-1, "record was altered")
+		}
+
+		if rec.Checkpoint != nil {
+			cp := rec.Checkpoint
+			if verifier != nil {
+				if cp.Algorithm != verifier.Algorithm() || !verifier.Verify(checkpointMessage(rec.Seq, rec.Prev, cp.Time), cp.Signature) {
+					return broken(rec.Seq, lineNo, "checkpoint signature is invalid; records before it were rewritten")
+				}
+				result.Checkpoints++
+			}
+			result.Unsigned = 0
+		} else {
+			result.Records++
+			result.Unsigned++
+			if verifier != nil && result.Unsigned > checkpointEvery {
+				return broken(rec.Seq, lineNo, "more than %d records follow the last checkpoint", checkpointEvery)
+			}
+		}
+		result.Head = hashLine(line)
+		expected++
+	}
+}
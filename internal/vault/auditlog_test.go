@@ -0,0 +1,275 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testAuditKey = HMACAuditKey(bytes.Repeat([]byte{0x42}, 32))
+
+// writeAuditLog writes n events to a new log at path and closes it.
+func writeAuditLog(t *testing.T, path string, n int, opts AuditLogOptions) {
+	t.Helper()
+	l, err := OpenAuditLog(path, opts)
+	require.NoError(t, err)
+	hook := l.Hook()
+	for i := 0; i < n; i++ {
+		hook(AuditEvent{Timestamp: time.Now(), Operation: "put", Key: "token", Success: true})
+	}
+	require.NoError(t, l.Close())
+}
+
+// auditLines returns the lines of the log at path.
+func auditLines(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+}
+
+// rechain recomputes every link after lines have been edited, as someone
+// covering their tracks would.
+func rechain(t *testing.T, lines [][]byte) [][]byte {
+	t.Helper()
+	prev := auditGenesis
+	out := make([][]byte, 0, len(lines))
+	for _, line := range lines {
+		var rec auditRecord
+		require.NoError(t, json.Unmarshal(line, &rec))
+		rec.Prev = prev
+		line, err := json.Marshal(rec)
+		require.NoError(t, err)
+		prev = hashLine(line)
+		out = append(out, line)
+	}
+	return out
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	opts := AuditLogOptions{Signer: testAuditKey, CheckpointEvery: 3}
+	writeAuditLog(t, path, 7, opts)
+
+	// Reopening continues the chain.
+	writeAuditLog(t, path, 2, opts)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	result, err := VerifyAuditLog(f, testAuditKey, 3)
+	require.NoError(t, err)
+	require.Equal(t, 9, result.Records)
+	require.Equal(t, 4, result.Checkpoints, "every 3 events plus one on each close")
+	require.Zero(t, result.Unsigned)
+	require.Len(t, auditLines(t, path), 13)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestVerifyAuditLog_Tampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeAuditLog(t, path, 6, AuditLogOptions{Signer: testAuditKey, CheckpointEvery: 3})
+	// Lines: events 1-3, checkpoint 4, events 5-7, checkpoint 8.
+	original := auditLines(t, path)
+	require.Len(t, original, 8)
+
+	edit := func(lines [][]byte, i int) [][]byte {
+		out := append([][]byte(nil), lines...)
+		out[i] = bytes.Replace(out[i], []byte(`"key":"token"`), []byte(`"key":"other"`), 1)
+		return out
+	}
+	remove := func(lines [][]byte, i int) [][]byte {
+		return append(append([][]byte(nil), lines[:i]...), lines[i+1:]...)
+	}
+
+	tests := []struct {
+		name     string
+		lines    [][]byte
+		verifier AuditVerifier
+		wantSeq  uint64
+	}{
+		{name: "altered record", lines: edit(original, 1), verifier: testAuditKey, wantSeq: 2},
+		{name: "deleted record", lines: remove(original, 4), verifier: testAuditKey, wantSeq: 5},
+		{name: "deleted first record", lines: remove(original, 0), verifier: testAuditKey, wantSeq: 1},
+		{name: "swapped records", lines: [][]byte{original[1], original[0]}, verifier: testAuditKey, wantSeq: 1},
+		{name: "rewritten chain", lines: rechain(t, edit(original, 5)), verifier: testAuditKey, wantSeq: 8},
+		{name: "wrong key", lines: original, verifier: HMACAuditKey(bytes.Repeat([]byte{1}, 32)), wantSeq: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append(bytes.Join(tt.lines, []byte("\n")), '\n')
+			result, err := VerifyAuditLog(bytes.NewReader(data), tt.verifier, 3)
+			require.ErrorIs(t, err, ErrAuditTampered)
+			require.Equal(t, tt.wantSeq, result.BrokenSeq)
+		})
+	}
+
+	t.Run("chain only", func(t *testing.T) {
+		data := append(bytes.Join(rechain(t, edit(original, 5)), []byte("\n")), '\n')
+		_, err := VerifyAuditLog(bytes.NewReader(data), nil, 0)
+		require.NoError(t, err, "without a verifier a rewritten chain goes unnoticed")
+	})
+
+	t.Run("incomplete record", func(t *testing.T) {
+		data := bytes.Join(original, []byte("\n"))
+		_, err := VerifyAuditLog(bytes.NewReader(data[:len(data)-5]), testAuditKey, 3)
+		require.ErrorIs(t, err, ErrAuditTampered)
+	})
+}
+
+func TestVerifyAuditLog_UnsignedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeAuditLog(t, path, 6, AuditLogOptions{Signer: testAuditKey, CheckpointEvery: 3})
+	// Lines: events 1-3, checkpoint 4, events 5-7, checkpoint 8.
+	signed := auditLines(t, path)
+
+	t.Run("no checkpoint", func(t *testing.T) {
+		unsigned := filepath.Join(t.TempDir(), "audit.log")
+		writeAuditLog(t, unsigned, 2, AuditLogOptions{})
+		data, err := os.ReadFile(unsigned)
+		require.NoError(t, err)
+
+		_, err = VerifyAuditLog(bytes.NewReader(data), nil, 3)
+		require.NoError(t, err)
+		_, err = VerifyAuditLog(bytes.NewReader(data), testAuditKey, 3)
+		require.ErrorIs(t, err, ErrAuditTampered)
+		_, err = VerifyAuditLog(bytes.NewReader(nil), testAuditKey, 3)
+		require.ErrorIs(t, err, ErrAuditTampered, "an emptied log proves nothing")
+	})
+
+	// A writer that crashed before its next checkpoint leaves at most the
+	// interval's worth of unsigned records; a longer tail was added by
+	// someone else.
+	writeAuditLog(t, path, 3, AuditLogOptions{})
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	result, err := VerifyAuditLog(bytes.NewReader(data), testAuditKey, 3)
+	require.NoError(t, err)
+	require.Equal(t, 3, result.Unsigned)
+
+	writeAuditLog(t, path, 1, AuditLogOptions{})
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	result, err = VerifyAuditLog(bytes.NewReader(data), testAuditKey, 3)
+	require.ErrorIs(t, err, ErrAuditTampered)
+	require.Equal(t, uint64(len(signed)+4), result.BrokenSeq)
+}
+
+func TestAuditLog_RecoversTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeAuditLog(t, path, 2, AuditLogOptions{})
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"seq":3,"prev":"ab`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	writeAuditLog(t, path, 1, AuditLogOptions{})
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	result, err := VerifyAuditLog(bytes.NewReader(data), nil, 0)
+	require.NoError(t, err)
+	require.Equal(t, 3, result.Records)
+	require.Equal(t, 3, result.Unsigned)
+}
+
+func TestAuditLog_Ed25519(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeAuditLog(t, path, 4, AuditLogOptions{Signer: Ed25519AuditSigner(private)})
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	result, err := VerifyAuditLog(bytes.NewReader(data), Ed25519AuditVerifier(public), 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Checkpoints)
+
+	_, err = VerifyAuditLog(bytes.NewReader(data), testAuditKey, 0)
+	require.ErrorIs(t, err, ErrAuditTampered, "checkpoint algorithm must match")
+}
+
+func TestAuditLog_StoreHook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := OpenAuditLog(path, AuditLogOptions{Signer: testAuditKey})
+	require.NoError(t, err)
+
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	ctx := context.Background()
+	store := NewAESGCMStore(keyProvider, l.Hook())
+	require.NoError(t, store.Put(ctx, "token", []byte("v")))
+	_, err = store.Get(ctx, "token")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	require.NoError(t, store.Put(ctx, "token", []byte("v2")), "a closed log does not fail the store")
+	require.NoError(t, l.Close(), "Close is idempotent")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	result, err := VerifyAuditLog(bytes.NewReader(data), testAuditKey, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Records)
+	require.Contains(t, string(data), `"operation":"get"`)
+	require.NotContains(t, string(data), `"v"`, "values never reach the log")
+}
+
+func TestAuditLog_SingleWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := OpenAuditLog(path, AuditLogOptions{})
+	require.NoError(t, err)
+
+	_, err = OpenAuditLog(path, AuditLogOptions{})
+	require.ErrorIs(t, err, ErrStoreLocked, "a second writer would fork the chain")
+
+	require.NoError(t, l.Close())
+	l, err = OpenAuditLog(path, AuditLogOptions{})
+	require.NoError(t, err, "Close releases the lock")
+	require.NoError(t, l.Close())
+}
+
+func TestWithAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := OpenAuditLog(path, AuditLogOptions{})
+	require.NoError(t, err)
+
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	ctx := context.Background()
+	var collected []AuditEvent
+	store := NewAESGCMStore(keyProvider, func(e AuditEvent) { collected = append(collected, e) }, WithAuditLog(l))
+	require.NoError(t, store.Put(ctx, "token", []byte("v")))
+	require.Len(t, collected, 1, "the store's own hook still runs")
+	require.NoError(t, l.Err())
+
+	// Make the next append fail.
+	require.NoError(t, l.f.Close())
+	require.NoError(t, store.Put(ctx, "token", []byte("v2")), "the failure is found while recording this put")
+	require.ErrorIs(t, l.Err(), ErrAuditUnavailable)
+
+	_, err = store.Get(ctx, "token")
+	require.ErrorIs(t, err, ErrAuditUnavailable, "nothing else happens unrecorded")
+	require.ErrorIs(t, store.HealthCheck(ctx), ErrAuditUnavailable)
+	require.Error(t, l.Append(AuditEvent{Operation: "get"}), "the log stays stopped")
+	require.Error(t, l.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	result, err := VerifyAuditLog(bytes.NewReader(data), nil, 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Records)
+}
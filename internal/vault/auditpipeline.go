@@ -0,0 +1,289 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Audit pipeline defaults.
+const (
+	DefaultAuditQueueSize    = 1024
+	DefaultAuditMaxAttempts  = 3
+	DefaultAuditRetryBackoff = 100 * time.Millisecond
+	DefaultAuditDrainTimeout = 10 * time.Second
+
+	// maxAuditRetryBackoff caps the doubling delay between delivery attempts.
+	maxAuditRetryBackoff = 30 * time.Second
+)
+
+// AuditQueuePolicy says what happens to an event when a sink's queue is full.
+type AuditQueuePolicy string
+
+// Queue policies.
+const (
+	// AuditQueueDrop discards the event for that sink and counts it as
+	// dropped. Vault operations are never slowed by auditing.
+	AuditQueueDrop AuditQueuePolicy = "drop"
+	// AuditQueueBlock makes the operation that emitted the event wait until
+	// there is room, slowing the vault down to the pace of its slowest sink.
+	AuditQueueBlock AuditQueuePolicy = "block"
+	// AuditQueueFailClosed is for compliance deployments where nothing may
+	// happen unaudited. Deliveries are retried until they succeed, and while
+	// any queue is full the store refuses secret operations with
+	// ErrAuditUnavailable. Events for operations that already succeeded wait
+	// for room; events for failed operations, which include those refusals,
+	// are dropped instead because they changed nothing.
+	AuditQueueFailClosed AuditQueuePolicy = "fail-closed"
+)
+
+// AuditPipelineOptions configures an AuditPipeline.
+type AuditPipelineOptions struct {
+	// QueueSize bounds each sink's queue. Defaults to DefaultAuditQueueSize.
+	QueueSize int
+
+	// Policy applies when a queue is full. Defaults to AuditQueueBlock.
+	Policy AuditQueuePolicy
+
+	// MaxAttempts is how many times delivery of an event to a sink is tried
+	// before it is dropped. Defaults to DefaultAuditMaxAttempts; ignored under
+	// AuditQueueFailClosed.
+	MaxAttempts int
+
+	// RetryBackoff is the delay before the first retry; it doubles after each
+	// failure up to 30 seconds. Defaults to DefaultAuditRetryBackoff.
+	RetryBackoff time.Duration
+
+	// DrainTimeout bounds how long Close waits for queues to drain, so a dead
+	// sink cannot hold up shutdown forever, even under AuditQueueFailClosed.
+	// Defaults to DefaultAuditDrainTimeout.
+	DrainTimeout time.Duration
+}
+
+// AuditSinkStats reports one sink's delivery counters.
+type AuditSinkStats struct {
+	Name      string `json:"name"`
+	Queued    int    `json:"queued"`
+	Delivered int64  `json:"delivered"`
+	Dropped   int64  `json:"dropped"` // Queue full, retries exhausted, or pipeline closed
+	Retries   int64  `json:"retries"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// AuditPipeline fans audit events out to several sinks. Each sink has its own
+// bounded queue and delivery goroutine, so a slow or failing sink neither
+// delays the others nor, except as the queue policy allows, the vault.
+type AuditPipeline struct {
+	opts    AuditPipelineOptions
+	workers []*auditWorker
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	// mu guards closed; senders hold it for reading so Close cannot close a
+	// queue mid-send.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// auditWorker delivers one sink's queue.
+type auditWorker struct {
+	sink      AuditSink
+	queue     chan AuditEvent
+	delivered atomic.Int64
+	dropped   atomic.Int64
+	retries   atomic.Int64
+
+	mu      sync.Mutex
+	lastErr string
+}
+
+// NewAuditPipeline starts delivering to sinks. Pass it to the store with
+// WithAuditPipeline, and Close it after the store is no longer in use.
+func NewAuditPipeline(opts AuditPipelineOptions, sinks ...AuditSink) (*AuditPipeline, error) {
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("vault: audit pipeline needs at least one sink")
+	}
+	switch opts.Policy {
+	case "":
+		opts.Policy = AuditQueueBlock
+	case AuditQueueDrop, AuditQueueBlock, AuditQueueFailClosed:
+	default:
+		return nil, fmt.Errorf("vault: unknown audit queue policy %q", opts.Policy)
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultAuditQueueSize
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultAuditMaxAttempts
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = DefaultAuditRetryBackoff
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = DefaultAuditDrainTimeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &AuditPipeline{opts: opts, cancel: cancel}
+	for _, sink := range sinks {
+		w := &auditWorker{sink: sink, queue: make(chan AuditEvent, opts.QueueSize)}
+		p.workers = append(p.workers, w)
+		p.wg.Add(1)
+		go func(w *auditWorker) {
+			defer p.wg.Done()
+			for event := range w.queue {
+				p.deliver(ctx, w, event)
+			}
+		}(w)
+	}
+	return p, nil
+}
+
+// WithAuditPipeline sends the store's audit events through p instead of the
+// hook passed to the constructor. Under AuditQueueFailClosed the store also
+// consults p before every secret operation.
+func WithAuditPipeline(p *AuditPipeline) StoreOption {
+	return func(s *aesgcmStore) {
+		s.auditHook = p.Hook()
+		if p.opts.Policy == AuditQueueFailClosed {
+			s.addAuditGate(p.Ready)
+		}
+	}
+}
+
+// Hook returns an AuditHook that queues events for every sink according to
+// the queue policy. Events arriving after Close are dropped.
+func (p *AuditPipeline) Hook() AuditHook {
+	return p.enqueue
+}
+
+// enqueue queues event for every sink.
+func (p *AuditPipeline) enqueue(event AuditEvent) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, w := range p.workers {
+		if p.closed {
+			w.dropped.Add(1)
+			continue
+		}
+		if p.opts.Policy == AuditQueueDrop || (p.opts.Policy == AuditQueueFailClosed && !event.Success) {
+			select {
+			case w.queue <- event:
+			default:
+				w.dropped.Add(1)
+			}
+			continue
+		}
+		w.queue <- event
+	}
+}
+
+// deliver writes event to w's sink, retrying with backoff. It drops the event
+// once ctx is done.
+func (p *AuditPipeline) deliver(ctx context.Context, w *auditWorker, event AuditEvent) {
+	if ctx.Err() != nil {
+		w.dropped.Add(1)
+		return
+	}
+	backoff := p.opts.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := w.sink.Write(ctx, event)
+		if err == nil {
+			w.delivered.Add(1)
+			return
+		}
+		w.mu.Lock()
+		w.lastErr = err.Error()
+		w.mu.Unlock()
+
+		if ctx.Err() != nil || (p.opts.Policy != AuditQueueFailClosed && attempt >= p.opts.MaxAttempts) {
+			w.dropped.Add(1)
+			return
+		}
+		w.retries.Add(1)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+		backoff = min(backoff*2, maxAuditRetryBackoff)
+	}
+}
+
+// Ready returns ErrAuditUnavailable if a sink's queue is full or the pipeline
+// is closed, meaning new events could not be recorded promptly.
+func (p *AuditPipeline) Ready() error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return fmt.Errorf("%w: pipeline is closed", ErrAuditUnavailable)
+	}
+	for _, w := range p.workers {
+		if len(w.queue) == cap(w.queue) {
+			return fmt.Errorf("%w: %s queue is full", ErrAuditUnavailable, w.sink.Name())
+		}
+	}
+	return nil
+}
+
+// Stats reports each sink's counters, in the order the sinks were given.
+func (p *AuditPipeline) Stats() []AuditSinkStats {
+	stats := make([]AuditSinkStats, 0, len(p.workers))
+	for _, w := range p.workers {
+		w.mu.Lock()
+		lastErr := w.lastErr
+		w.mu.Unlock()
+		stats = append(stats, AuditSinkStats{
+			Name:      w.sink.Name(),
+			Queued:    len(w.queue),
+			Delivered: w.delivered.Load(),
+			Dropped:   w.dropped.Load(),
+			Retries:   w.retries.Load(),
+			LastError: lastErr,
+		})
+	}
+	return stats
+}
+
+// Close stops accepting events, waits until every queue is delivered, ctx is
+// done or the drain timeout passes, and closes the sinks. Events still queued
+// then are dropped and the context error is returned along with any sink
+// close errors.
+func (p *AuditPipeline) Close(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.opts.DrainTimeout)
+	defer cancel()
+
+	// Once ctx ends, abandon retries so blocked senders and workers finish,
+	// dropping whatever is left.
+	stop := context.AfterFunc(ctx, p.cancel)
+	defer stop()
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	for _, w := range p.workers {
+		close(w.queue)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	p.cancel()
+
+	var errs []error
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, w := range p.workers {
+		if err := w.sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", w.sink.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
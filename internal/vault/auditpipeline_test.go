@@ -0,0 +1,251 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testSink records events. It fails the first failures writes and, when
+// release is set, holds every write until release is closed.
+type testSink struct {
+	name     string
+	release  chan struct{}
+	started  chan struct{} // Receives a value as each write begins, if set
+	mu       sync.Mutex
+	failures int
+	events   []AuditEvent
+	closed   bool
+}
+
+func (s *testSink) Name() string { return s.name }
+
+func (s *testSink) Write(ctx context.Context, event AuditEvent) error {
+	if s.started != nil {
+		s.started <- struct{}{}
+	}
+	if s.release != nil {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures != 0 {
+		s.failures--
+		return errors.New("sink unavailable")
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *testSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *testSink) operations() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ops := make([]string, 0, len(s.events))
+	for _, e := range s.events {
+		ops = append(ops, e.Operation)
+	}
+	return ops
+}
+
+func TestAuditPipeline_FanOut(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var received []AuditEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var e AuditEvent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		mu.Lock()
+		received = append(received, e)
+		mu.Unlock()
+	}))
+	defer server.Close()
+	webhook, err := NewWebhookAuditSink(WebhookAuditSinkConfig{
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	memory := &testSink{name: "memory"}
+	pipeline, err := NewAuditPipeline(AuditPipelineOptions{}, memory, NewWriterAuditSink("buffer", &buf), webhook)
+	require.NoError(t, err)
+
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil, WithAuditPipeline(pipeline))
+	require.NoError(t, store.Put(ctx, "token", []byte("v")))
+	_, err = store.Get(ctx, "token")
+	require.NoError(t, err)
+
+	require.NoError(t, pipeline.Close(ctx))
+	require.True(t, memory.closed)
+	require.Equal(t, []string{"put", "get"}, memory.operations())
+	require.Equal(t, 2, bytes.Count(buf.Bytes(), []byte("\n")))
+	require.Len(t, received, 2)
+
+	for _, stats := range pipeline.Stats() {
+		require.Equal(t, int64(2), stats.Delivered, stats.Name)
+		require.Zero(t, stats.Dropped, stats.Name)
+	}
+
+	pipeline.Hook()(AuditEvent{Operation: "late"})
+	require.Equal(t, int64(1), pipeline.Stats()[0].Dropped, "events after Close are dropped")
+}
+
+func TestAuditPipeline_Retry(t *testing.T) {
+	ctx := context.Background()
+	flaky := &testSink{name: "flaky", failures: 2}
+	broken := &testSink{name: "broken", failures: -1}
+	pipeline, err := NewAuditPipeline(AuditPipelineOptions{MaxAttempts: 3, RetryBackoff: time.Millisecond}, flaky, broken)
+	require.NoError(t, err)
+
+	pipeline.Hook()(AuditEvent{Operation: "put"})
+	require.NoError(t, pipeline.Close(ctx))
+
+	stats := pipeline.Stats()
+	require.Equal(t, AuditSinkStats{Name: "flaky", Delivered: 1, Retries: 2, LastError: "sink unavailable"}, stats[0])
+	require.Equal(t, AuditSinkStats{Name: "broken", Dropped: 1, Retries: 2, LastError: "sink unavailable"}, stats[1])
+}
+
+func TestAuditPipeline_QueuePolicies(t *testing.T) {
+	// newStuck returns a sink whose first write has started and is held.
+	newStuck := func(t *testing.T, policy AuditQueuePolicy) (*testSink, *AuditPipeline) {
+		sink := &testSink{name: "stuck", release: make(chan struct{}), started: make(chan struct{}, 16)}
+		pipeline, err := NewAuditPipeline(AuditPipelineOptions{QueueSize: 1, Policy: policy}, sink)
+		require.NoError(t, err)
+		pipeline.Hook()(AuditEvent{Operation: "first", Success: true})
+		<-sink.started
+		return sink, pipeline
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		sink, pipeline := newStuck(t, AuditQueueDrop)
+		hook := pipeline.Hook()
+		hook(AuditEvent{Operation: "queued", Success: true})
+		hook(AuditEvent{Operation: "dropped", Success: true})
+		require.Equal(t, int64(1), pipeline.Stats()[0].Dropped)
+
+		close(sink.release)
+		require.NoError(t, pipeline.Close(context.Background()))
+		require.Equal(t, []string{"first", "queued"}, sink.operations())
+	})
+
+	t.Run("block", func(t *testing.T) {
+		sink, pipeline := newStuck(t, AuditQueueBlock)
+		hook := pipeline.Hook()
+		hook(AuditEvent{Operation: "queued", Success: true})
+
+		done := make(chan struct{})
+		go func() {
+			hook(AuditEvent{Operation: "waited", Success: true})
+			close(done)
+		}()
+		select {
+		case <-done:
+			t.Fatal("hook returned while the queue was full")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(sink.release)
+		<-done
+		require.NoError(t, pipeline.Close(context.Background()))
+		require.Equal(t, []string{"first", "queued", "waited"}, sink.operations())
+	})
+
+	t.Run("fail closed", func(t *testing.T) {
+		ctx := context.Background()
+		sink := &testSink{name: "stuck", release: make(chan struct{}), started: make(chan struct{}, 16)}
+		pipeline, err := NewAuditPipeline(AuditPipelineOptions{QueueSize: 1, Policy: AuditQueueFailClosed}, sink)
+		require.NoError(t, err)
+		keyProvider, err := NewInMemoryKeyProvider()
+		require.NoError(t, err)
+		store := NewAESGCMStore(keyProvider, nil, WithAuditPipeline(pipeline))
+
+		require.NoError(t, store.Put(ctx, "token", []byte("v1")))
+		<-sink.started
+		require.NoError(t, store.Put(ctx, "token", []byte("v2")), "the queue still had room")
+
+		err = store.Put(ctx, "token", []byte("v3"))
+		require.ErrorIs(t, err, ErrAuditUnavailable)
+		_, err = store.Get(ctx, "token")
+		require.ErrorIs(t, err, ErrAuditUnavailable)
+		require.ErrorIs(t, store.HealthCheck(ctx), ErrAuditUnavailable)
+
+		close(sink.release)
+		require.Eventually(t, func() bool { return pipeline.Ready() == nil }, 5*time.Second, 10*time.Millisecond)
+		value, err := store.Get(ctx, "token")
+		require.NoError(t, err)
+		require.Equal(t, []byte("v2"), value, "refused writes never happened")
+
+		require.NoError(t, pipeline.Close(ctx))
+		require.Equal(t, []string{"put", "put", "get"}, sink.operations())
+		require.ErrorIs(t, store.HealthCheck(ctx), ErrAuditUnavailable, "a closed pipeline stays closed")
+	})
+
+	t.Run("fail closed retries until ctx ends", func(t *testing.T) {
+		broken := &testSink{name: "broken", failures: -1}
+		pipeline, err := NewAuditPipeline(AuditPipelineOptions{Policy: AuditQueueFailClosed, RetryBackoff: time.Millisecond}, broken)
+		require.NoError(t, err)
+		pipeline.Hook()(AuditEvent{Operation: "put", Success: true})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, pipeline.Close(ctx), context.DeadlineExceeded)
+		stats := pipeline.Stats()[0]
+		require.Equal(t, int64(1), stats.Dropped)
+		require.Greater(t, stats.Retries, int64(DefaultAuditMaxAttempts))
+	})
+
+	t.Run("close gives up on a dead sink after the drain timeout", func(t *testing.T) {
+		broken := &testSink{name: "broken", failures: -1}
+		pipeline, err := NewAuditPipeline(AuditPipelineOptions{
+			Policy:       AuditQueueFailClosed,
+			RetryBackoff: time.Millisecond,
+			DrainTimeout: 50 * time.Millisecond,
+		}, broken)
+		require.NoError(t, err)
+		pipeline.Hook()(AuditEvent{Operation: "put", Success: true})
+
+		start := time.Now()
+		require.ErrorIs(t, pipeline.Close(context.Background()), context.DeadlineExceeded)
+		require.Less(t, time.Since(start), 5*time.Second)
+		require.Equal(t, int64(1), pipeline.Stats()[0].Dropped)
+	})
+}
+
+func TestWebhookAuditSink_Status(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	sink, err := NewWebhookAuditSink(WebhookAuditSinkConfig{URL: server.URL})
+	require.NoError(t, err)
+	require.ErrorContains(t, sink.Write(context.Background(), AuditEvent{Operation: "put"}), "503")
+
+	_, err = NewWebhookAuditSink(WebhookAuditSinkConfig{})
+	require.Error(t, err)
+	_, err = NewAuditPipeline(AuditPipelineOptions{Policy: "sometimes"}, sink)
+	require.Error(t, err)
+}
@@ -0,0 +1,133 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditSink is a destination for audit events fed by an AuditPipeline. Write
+// is called from a single goroutine per sink and may be retried with the same
+// event after an error.
+type AuditSink interface {
+	// Name identifies the sink in pipeline statistics, e.g. "stdout".
+	Name() string
+	Write(ctx context.Context, event AuditEvent) error
+	Close() error
+}
+
+// writerSink writes events as JSON lines to an io.Writer.
+type writerSink struct {
+	name string
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+// NewWriterAuditSink returns a sink that writes each event as a JSON line to
+// w. It does not close w.
+func NewWriterAuditSink(name string, w io.Writer) AuditSink {
+	return &writerSink{name: name, w: w}
+}
+
+// NewStdoutAuditSink returns a sink that writes JSON lines to standard output,
+// for collection by a container runtime or service manager.
+func NewStdoutAuditSink() AuditSink {
+	return NewWriterAuditSink("stdout", os.Stdout)
+}
+
+func (s *writerSink) Name() string { return s.name }
+
+func (s *writerSink) Write(ctx context.Context, event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+func (s *writerSink) Close() error { return nil }
+
+// Name returns "file:" followed by the log's path.
+func (l *AuditLog) Name() string { return "file:" + l.path }
+
+// Write appends event to the log, making AuditLog usable as a pipeline sink.
+func (l *AuditLog) Write(ctx context.Context, event AuditEvent) error {
+	return l.Append(event)
+}
+
+// WebhookAuditSinkConfig configures a sink that POSTs events to an HTTP
+// endpoint such as a SIEM collector.
+type WebhookAuditSinkConfig struct {
+	// URL receives one JSON-encoded event per POST request.
+	URL string
+
+	// Headers are added to every request, e.g. an Authorization header.
+	Headers map[string]string
+
+	// TLS configures server verification and optional client certificates.
+	TLS ClientTLSConfig
+
+	// Timeout bounds each request. Defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// webhookSink POSTs events to an HTTP endpoint.
+type webhookSink struct {
+	cfg    WebhookAuditSinkConfig
+	client *http.Client
+}
+
+// NewWebhookAuditSink returns a sink that POSTs each event to cfg.URL. Any
+// response other than 2xx counts as a failed delivery.
+func NewWebhookAuditSink(cfg WebhookAuditSinkConfig) (AuditSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook: URL is required")
+	}
+	client, err := newHTTPClient(cfg.TLS, cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("webhook: %w", err)
+	}
+	return &webhookSink{cfg: cfg, client: client}, nil
+}
+
+func (s *webhookSink) Name() string { return "webhook:" + s.cfg.URL }
+
+func (s *webhookSink) Write(ctx context.Context, event AuditEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,47 @@
+//go:build !windows && !plan9
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+)
+
+// syslogSink writes events to a syslog daemon.
+type syslogSink struct {
+	name string
+	w    *syslog.Writer
+}
+
+// NewSyslogAuditSink returns a sink that sends each event as a JSON message
+// with the auth facility. Failed operations are logged at warning severity and
+// the rest at info. An empty network and address use the local syslog daemon;
+// otherwise network is "udp" or "tcp" and raddr is "host:port".
+func NewSyslogAuditSink(network, raddr, tag string) (AuditSink, error) {
+	w, err := syslog.Dial(network, raddr, syslog.LOG_AUTH|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("syslog: %w", err)
+	}
+	name := "syslog"
+	if raddr != "" {
+		name += ":" + raddr
+	}
+	return &syslogSink{name: name, w: w}, nil
+}
+
+func (s *syslogSink) Name() string { return s.name }
+
+func (s *syslogSink) Write(ctx context.Context, event AuditEvent) error {
+	msg, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	if !event.Success {
+		return s.w.Warning(string(msg))
+	}
+	return s.w.Info(string(msg))
+}
+
+func (s *syslogSink) Close() error { return s.w.Close() }
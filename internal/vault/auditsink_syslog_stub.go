@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package vault
+
+import "errors"
+
+// NewSyslogAuditSink always fails on platforms without syslog.
+func NewSyslogAuditSink(network, raddr, tag string) (AuditSink, error) {
+	return nil, errors.New("syslog: not supported on this platform")
+}
@@ -0,0 +1,486 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AuditOutcome selects events by whether the operation succeeded.
+type AuditOutcome string
+
+// Audit outcomes.
+const (
+	AuditOutcomeSuccess AuditOutcome = "success"
+	AuditOutcomeFailure AuditOutcome = "failure"
+)
+
+// AuditQuery selects events from an AuditStore. Empty fields match everything.
+type AuditQuery struct {
+	Since     time.Time // Inclusive
+	Until     time.Time // Exclusive
+	Key       string
+	Operation string
+	Actor     string
+	Outcome   AuditOutcome
+
+	// Limit caps the number of events returned; zero or less returns all.
+	Limit int
+
+	// Newest returns the most recent matches first instead of the oldest.
+	Newest bool
+}
+
+var errAuditStoreReadOnly = errors.New("vault: audit store is open read-only")
+
+// AuditStoreOptions configures an AuditStore.
+type AuditStoreOptions struct {
+	// Retention is how long events are kept by Purge and RunRetention; zero
+	// or less keeps them forever.
+	Retention time.Duration
+}
+
+// AuditStore keeps audit events in a JSON-lines file and indexes them in
+// memory by time, key, operation, actor and outcome. It implements AuditSink
+// so it can be fed by an AuditPipeline. It is a query store, not a
+// tamper-evident record; use an AuditLog alongside it for that.
+type AuditStore struct {
+	mu        sync.RWMutex
+	path      string
+	f         *os.File
+	lock      *os.File // Held lock on the file's sidecar; nil when read-only
+	readOnly  bool
+	retention time.Duration
+
+	// events is ordered by timestamp; the indexes hold positions in it, in
+	// ascending order.
+	events      []AuditEvent
+	byKey       map[string][]int
+	byOperation map[string][]int
+	byActor     map[string][]int
+	byOutcome   map[bool][]int
+}
+
+// OpenAuditStore opens or creates the audit store at path and indexes the
+// events already in it.
+//
+// The store holds an exclusive lock on path+".lock" until Close, so a second
+// writer, such as a purge run from another process while a vault is
+// appending, fails with ErrStoreLocked instead of replacing the file under
+// the first one. Read-only opens take no lock.
+func OpenAuditStore(path string, opts AuditStoreOptions) (*AuditStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("vault: audit store path cannot be empty")
+	}
+	lock, err := lockStoreFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := removeStaleTempFiles(path); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	events, err := loadAuditEvents(path, true)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to open audit store: %w", err)
+	}
+
+	s := &AuditStore{path: path, f: f, lock: lock, retention: opts.Retention, events: events}
+	s.reindex()
+	return s, nil
+}
+
+// OpenAuditStoreReadOnly opens the existing audit store at path for queries
+// only. It never modifies the file: a final line still being written is
+// skipped rather than truncated, and a missing file is an error rather than a
+// new store. Write and Purge fail on the returned store.
+func OpenAuditStoreReadOnly(path string) (*AuditStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("vault: audit store path cannot be empty")
+	}
+	events, err := loadAuditEvents(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &AuditStore{path: path, readOnly: true, events: events}
+	s.reindex()
+	return s, nil
+}
+
+// loadAuditEvents reads the events in the file at path, ordered by timestamp.
+// With repair set, a missing file is an empty store and a final line left
+// incomplete by a crash is truncated away; otherwise the file must exist and
+// such a line is skipped.
+func loadAuditEvents(path string, repair bool) ([]AuditEvent, error) {
+	flag := os.O_RDONLY
+	if repair {
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(path, flag, 0)
+	if repair && errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit store: %w", err)
+	}
+	defer f.Close()
+
+	var events []AuditEvent
+	var offset int64
+	r := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 && repair {
+				if err := f.Truncate(offset); err != nil {
+					return nil, fmt.Errorf("failed to truncate incomplete audit event: %w", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit store: %w", err)
+		}
+		offset += int64(len(line))
+
+		var e AuditEvent
+		if err := json.Unmarshal(bytes.TrimSuffix(line, []byte("\n")), &e); err != nil {
+			return nil, fmt.Errorf("failed to parse audit store line %d: %w", lineNo, err)
+		}
+		events = append(events, e)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+	return events, nil
+}
+
+// reindex rebuilds every index from s.events. Callers must hold s.mu.
+func (s *AuditStore) reindex() {
+	s.byKey = make(map[string][]int)
+	s.byOperation = make(map[string][]int)
+	s.byActor = make(map[string][]int)
+	s.byOutcome = make(map[bool][]int)
+	for i := range s.events {
+		s.indexLocked(i)
+	}
+}
+
+// indexLocked adds the event at position i to the indexes. Callers must hold
+// s.mu.
+func (s *AuditStore) indexLocked(i int) {
+	e := s.events[i]
+	if e.Key != "" {
+		s.byKey[e.Key] = insertPosition(s.byKey[e.Key], i)
+	}
+	s.byOperation[e.Operation] = insertPosition(s.byOperation[e.Operation], i)
+	if e.Actor != "" {
+		s.byActor[e.Actor] = insertPosition(s.byActor[e.Actor], i)
+	}
+	s.byOutcome[e.Success] = insertPosition(s.byOutcome[e.Success], i)
+}
+
+// shiftLocked moves every indexed position from i on up by one after an event
+// has been inserted at i. Only the lists holding the events after it are
+// touched. Callers must hold s.mu.
+func (s *AuditStore) shiftLocked(i int) {
+	keys := make(map[string]bool)
+	operations := make(map[string]bool)
+	actors := make(map[string]bool)
+	outcomes := make(map[bool]bool)
+	for _, e := range s.events[i+1:] {
+		if e.Key != "" {
+			keys[e.Key] = true
+		}
+		operations[e.Operation] = true
+		if e.Actor != "" {
+			actors[e.Actor] = true
+		}
+		outcomes[e.Success] = true
+	}
+	for k := range keys {
+		shiftPositions(s.byKey[k], i)
+	}
+	for op := range operations {
+		shiftPositions(s.byOperation[op], i)
+	}
+	for a := range actors {
+		shiftPositions(s.byActor[a], i)
+	}
+	for o := range outcomes {
+		shiftPositions(s.byOutcome[o], i)
+	}
+}
+
+// insertPosition adds i to the ascending list positions.
+func insertPosition(positions []int, i int) []int {
+	j := sort.SearchInts(positions, i)
+	positions = append(positions, 0)
+	copy(positions[j+1:], positions[j:])
+	positions[j] = i
+	return positions
+}
+
+// shiftPositions increments the positions at or after i in the ascending list
+// positions.
+func shiftPositions(positions []int, i int) {
+	for j := sort.SearchInts(positions, i); j < len(positions); j++ {
+		positions[j]++
+	}
+}
+
+// Name returns "store:" followed by the store's path.
+func (s *AuditStore) Name() string { return "store:" + s.path }
+
+// Write appends event to the store and indexes it.
+func (s *AuditStore) Write(ctx context.Context, event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appendLocked(event)
+}
+
+// appendLocked writes, syncs and indexes event. Callers must hold s.mu.
+func (s *AuditStore) appendLocked(event AuditEvent) error {
+	if s.readOnly {
+		return errAuditStoreReadOnly
+	}
+	if s.f == nil {
+		return fmt.Errorf("vault: audit store is closed")
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit store: %w", err)
+	}
+	if err := s.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit store: %w", err)
+	}
+
+	// Events mostly arrive in order. One that doesn't is inserted at its
+	// place in time, moving only the positions of the events after it.
+	i := sort.Search(len(s.events), func(i int) bool {
+		return s.events[i].Timestamp.After(event.Timestamp)
+	})
+	s.events = append(s.events, AuditEvent{})
+	copy(s.events[i+1:], s.events[i:])
+	s.events[i] = event
+	if i < len(s.events)-1 {
+		s.shiftLocked(i)
+	}
+	s.indexLocked(i)
+	return nil
+}
+
+// Query returns the events matching q, ordered by time.
+func (s *AuditStore) Query(q AuditQuery) ([]AuditEvent, error) {
+	if q.Outcome != "" && q.Outcome != AuditOutcomeSuccess && q.Outcome != AuditOutcomeFailure {
+		return nil, fmt.Errorf("vault: unknown audit outcome %q", q.Outcome)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// The time range bounds a scan of every event; an index lookup narrows
+	// it further when the query has an exact-match filter.
+	lo := 0
+	if !q.Since.IsZero() {
+		lo = sort.Search(len(s.events), func(i int) bool { return !s.events[i].Timestamp.Before(q.Since) })
+	}
+	hi := len(s.events)
+	if !q.Until.IsZero() {
+		hi = sort.Search(len(s.events), func(i int) bool { return !s.events[i].Timestamp.Before(q.Until) })
+	}
+	var candidates []int
+	indexed := false
+	narrow := func(positions []int) {
+		if !indexed || len(positions) < len(candidates) {
+			candidates, indexed = positions, true
+		}
+	}
+	if q.Key != "" {
+		narrow(s.byKey[q.Key])
+	}
+	if q.Operation != "" {
+		narrow(s.byOperation[q.Operation])
+	}
+	if q.Actor != "" {
+		narrow(s.byActor[q.Actor])
+	}
+	if q.Outcome != "" {
+		narrow(s.byOutcome[q.Outcome == AuditOutcomeSuccess])
+	}
+
+	if hi < lo {
+		hi = lo
+	}
+	n := hi - lo
+	at := func(k int) int { return lo + k }
+	if indexed {
+		candidates = candidates[sort.SearchInts(candidates, lo):sort.SearchInts(candidates, hi)]
+		n = len(candidates)
+		at = func(k int) int { return candidates[k] }
+	}
+
+	results := make([]AuditEvent, 0)
+	for j := 0; j < n; j++ {
+		k := j
+		if q.Newest {
+			k = n - 1 - j
+		}
+		e := s.events[at(k)]
+		if !q.matches(e) {
+			continue
+		}
+		results = append(results, e)
+		if q.Limit > 0 && len(results) == q.Limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+// matches reports whether e passes every exact-match filter in q.
+func (q AuditQuery) matches(e AuditEvent) bool {
+	return (q.Key == "" || e.Key == q.Key) &&
+		(q.Operation == "" || e.Operation == q.Operation) &&
+		(q.Actor == "" || e.Actor == q.Actor) &&
+		(q.Outcome == "" || e.Success == (q.Outcome == AuditOutcomeSuccess))
+}
+
+// Purge deletes events older than the retention period and records an
+// "audit_purge" event, attributed to the actor in ctx, saying how many were
+// removed. It does nothing when retention is disabled or nothing is due.
+func (s *AuditStore) Purge(ctx context.Context) (int, error) {
+	if s.retention <= 0 {
+		return 0, nil
+	}
+	now := time.Now()
+	cutoff := now.Add(-s.retention)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.readOnly {
+		return 0, errAuditStoreReadOnly
+	}
+	if s.f == nil {
+		return 0, fmt.Errorf("vault: audit store is closed")
+	}
+	n := sort.Search(len(s.events), func(i int) bool { return !s.events[i].Timestamp.Before(cutoff) })
+	if n == 0 {
+		return 0, nil
+	}
+
+	event := withRequestInfo(ctx, AuditEvent{
+		Timestamp: now,
+		Operation: "audit_purge",
+		Metadata: map[string]string{
+			"count":     fmt.Sprintf("%d", n),
+			"cutoff":    cutoff.UTC().Format(time.RFC3339),
+			"retention": s.retention.String(),
+		},
+	})
+
+	err := s.rewriteLocked(s.events[n:])
+	if err != nil {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", ErrPersistence, err)
+		if werr := s.appendLocked(event); werr != nil {
+			err = errors.Join(err, werr)
+		}
+		return 0, fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+	s.events = append([]AuditEvent(nil), s.events[n:]...)
+	s.reindex()
+
+	event.Success = true
+	if err := s.appendLocked(event); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// rewriteLocked atomically replaces the file with events and reopens it for
+// appending. Callers must hold s.mu.
+func (s *AuditStore) rewriteLocked(events []AuditEvent) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("failed to encode audit event: %w", err)
+		}
+	}
+	if err := writeFileAtomic(s.path, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to reopen audit store: %w", err)
+	}
+	s.f.Close()
+	s.f = f
+	return nil
+}
+
+// RunRetention calls Purge every interval until ctx is done. Purges are
+// attributed to the scheduler unless ctx names another component, and
+// failures are recorded as failed "audit_purge" events. Run it in its own
+// goroutine.
+func (s *AuditStore) RunRetention(ctx context.Context, interval time.Duration) {
+	if RequestInfoFromContext(ctx).Component == "" {
+		ctx = WithComponent(ctx, ComponentScheduler)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = s.Purge(ctx)
+		}
+	}
+}
+
+// Close flushes and closes the store's file and releases its lock.
+func (s *AuditStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Sync()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f = nil
+	if cerr := s.lock.Close(); err == nil {
+		err = cerr
+	}
+	s.lock = nil
+	if err != nil {
+		return fmt.Errorf("failed to close audit store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditStore_Query(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	store, err := OpenAuditStore(path, AuditStoreOptions{})
+	require.NoError(t, err)
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	events := []AuditEvent{
+		{Timestamp: base, Operation: "put", Key: "mounts/abc/token", Success: true, Actor: "alice"},
+		{Timestamp: base.Add(time.Hour), Operation: "get", Key: "mounts/abc/token", Success: true, Actor: "bob"},
+		{Timestamp: base.Add(2 * time.Hour), Operation: "get", Key: "oauth/dropbox/refresh", Success: false, Actor: "bob", Error: "vault: access denied"},
+		{Timestamp: base.Add(4 * time.Hour), Operation: "delete", Key: "mounts/abc/token", Success: true, Actor: "alice"},
+		// Arrives late but sorts by its timestamp.
+		{Timestamp: base.Add(3 * time.Hour), Operation: "get", Key: "mounts/abc/token", Success: true, Actor: "scheduler"},
+	}
+	for _, e := range events {
+		require.NoError(t, store.Write(ctx, e))
+	}
+
+	ops := func(events []AuditEvent) []string {
+		var out []string
+		for _, e := range events {
+			out = append(out, e.Operation+" "+e.Actor)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name  string
+		query AuditQuery
+		want  []string
+	}{
+		{name: "all", query: AuditQuery{}, want: []string{"put alice", "get bob", "get bob", "get scheduler", "delete alice"}},
+		{name: "key", query: AuditQuery{Key: "mounts/abc/token"}, want: []string{"put alice", "get bob", "get scheduler", "delete alice"}},
+		{name: "operation and actor", query: AuditQuery{Operation: "get", Actor: "bob"}, want: []string{"get bob", "get bob"}},
+		{name: "failures", query: AuditQuery{Outcome: AuditOutcomeFailure}, want: []string{"get bob"}},
+		{name: "time range", query: AuditQuery{Since: base.Add(time.Hour), Until: base.Add(3 * time.Hour)}, want: []string{"get bob", "get bob"}},
+		{name: "indexed time range", query: AuditQuery{Key: "mounts/abc/token", Since: base.Add(30 * time.Minute)}, want: []string{"get bob", "get scheduler", "delete alice"}},
+		{name: "newest first", query: AuditQuery{Operation: "get", Newest: true, Limit: 2}, want: []string{"get scheduler", "get bob"}},
+		{name: "limit", query: AuditQuery{Limit: 1}, want: []string{"put alice"}},
+		{name: "no match", query: AuditQuery{Actor: "mallory"}, want: nil},
+		{name: "inverted range", query: AuditQuery{Key: "mounts/abc/token", Since: base.Add(time.Hour), Until: base}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.Query(tt.query)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, ops(got))
+		})
+	}
+
+	_, err = store.Query(AuditQuery{Outcome: "maybe"})
+	require.Error(t, err)
+
+	// Reopening rebuilds the same indexes from the file.
+	require.NoError(t, store.Close())
+	reopened, err := OpenAuditStore(path, AuditStoreOptions{})
+	require.NoError(t, err)
+	defer reopened.Close()
+	got, err := reopened.Query(AuditQuery{Key: "mounts/abc/token"})
+	require.NoError(t, err)
+	require.Len(t, got, 4)
+	require.Equal(t, "scheduler", got[2].Actor)
+}
+
+func TestAuditStore_OutOfOrderIndex(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenAuditStore(filepath.Join(t.TempDir(), "audit.jsonl"), AuditStoreOptions{})
+	require.NoError(t, err)
+	defer store.Close()
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		require.NoError(t, store.Write(ctx, AuditEvent{
+			Timestamp: base.Add(time.Duration(rng.Intn(50)) * time.Minute),
+			Operation: []string{"get", "put", "delete"}[rng.Intn(3)],
+			Key:       fmt.Sprintf("key-%d", rng.Intn(7)),
+			Actor:     []string{"", "alice", "bob"}[rng.Intn(3)],
+			Success:   rng.Intn(4) != 0,
+		}))
+	}
+
+	// Indexes maintained incrementally match ones rebuilt from scratch.
+	store.mu.Lock()
+	got := []any{store.byKey, store.byOperation, store.byActor, store.byOutcome}
+	store.reindex()
+	want := []any{store.byKey, store.byOperation, store.byActor, store.byOutcome}
+	store.mu.Unlock()
+	require.Equal(t, want, got)
+
+	events, err := store.Query(AuditQuery{})
+	require.NoError(t, err)
+	require.True(t, sort.SliceIsSorted(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	}))
+}
+
+func TestAuditStore_Purge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	store, err := OpenAuditStore(path, AuditStoreOptions{Retention: 24 * time.Hour})
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	require.NoError(t, store.Write(ctx, AuditEvent{Timestamp: now.Add(-72 * time.Hour), Operation: "put", Key: "old", Success: true}))
+	require.NoError(t, store.Write(ctx, AuditEvent{Timestamp: now.Add(-48 * time.Hour), Operation: "get", Key: "old", Success: true}))
+	require.NoError(t, store.Write(ctx, AuditEvent{Timestamp: now.Add(-time.Hour), Operation: "get", Key: "recent", Success: true}))
+
+	n, err := store.Purge(WithActor(ctx, "admin"))
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	events, err := store.Query(AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, "recent", events[0].Key)
+	purge := events[1]
+	require.Equal(t, "audit_purge", purge.Operation)
+	require.True(t, purge.Success)
+	require.Equal(t, "admin", purge.Actor)
+	require.Equal(t, "2", purge.Metadata["count"])
+	require.Equal(t, "24h0m0s", purge.Metadata["retention"])
+
+	n, err = store.Purge(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n, "nothing left to purge, nothing recorded")
+
+	// Writes after a purge land in the rewritten file.
+	require.NoError(t, store.Write(ctx, AuditEvent{Timestamp: now, Operation: "list", Success: true}))
+	require.NoError(t, store.Close())
+	reopened, err := OpenAuditStore(path, AuditStoreOptions{})
+	require.NoError(t, err)
+	defer reopened.Close()
+	events, err = reopened.Query(AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+}
+
+func TestAuditStore_TornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	store, err := OpenAuditStore(path, AuditStoreOptions{})
+	require.NoError(t, err)
+	require.NoError(t, store.Write(context.Background(), AuditEvent{Timestamp: time.Now(), Operation: "put", Success: true}))
+	require.NoError(t, store.Close())
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"timestamp":"20`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	store, err = OpenAuditStore(path, AuditStoreOptions{})
+	require.NoError(t, err)
+	require.NoError(t, store.Write(context.Background(), AuditEvent{Timestamp: time.Now(), Operation: "get", Success: true}))
+	require.NoError(t, store.Close())
+
+	store, err = OpenAuditStore(path, AuditStoreOptions{})
+	require.NoError(t, err)
+	defer store.Close()
+	events, err := store.Query(AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+}
+
+func TestAuditStore_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	_, err := OpenAuditStoreReadOnly(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.NoFileExists(t, path, "a mistyped path is not created")
+
+	store, err := OpenAuditStore(path, AuditStoreOptions{})
+	require.NoError(t, err)
+	require.NoError(t, store.Write(ctx, AuditEvent{Timestamp: time.Now(), Operation: "put", Success: true}))
+	require.NoError(t, store.Close())
+
+	// A writer is part way through its next line.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"timestamp":"20`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	reader, err := OpenAuditStoreReadOnly(path)
+	require.NoError(t, err)
+	defer reader.Close()
+	events, err := reader.Query(AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	require.Error(t, reader.Write(ctx, AuditEvent{Timestamp: time.Now(), Operation: "get"}))
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, before, after, "the file is left untouched")
+}
+
+func TestAuditStore_SingleWriter(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	writer, err := OpenAuditStore(path, AuditStoreOptions{})
+	require.NoError(t, err)
+	require.NoError(t, writer.Write(ctx, AuditEvent{Timestamp: time.Now().Add(-2 * time.Hour), Operation: "put", Success: true}))
+
+	// A purge from another process would replace the file under the writer.
+	_, err = OpenAuditStore(path, AuditStoreOptions{Retention: time.Hour})
+	require.ErrorIs(t, err, ErrStoreLocked)
+
+	reader, err := OpenAuditStoreReadOnly(path)
+	require.NoError(t, err, "queries do not need the lock")
+	events, err := reader.Query(AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, events, 1, "appended events are on disk")
+
+	require.NoError(t, writer.Close())
+	purger, err := OpenAuditStore(path, AuditStoreOptions{Retention: time.Hour})
+	require.NoError(t, err)
+	defer purger.Close()
+	n, err := purger.Purge(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+}
+
+func TestAuditStore_PipelineSink(t *testing.T) {
+	ctx := context.Background()
+	auditStore, err := OpenAuditStore(filepath.Join(t.TempDir(), "audit.jsonl"), AuditStoreOptions{})
+	require.NoError(t, err)
+	pipeline, err := NewAuditPipeline(AuditPipelineOptions{}, auditStore)
+	require.NoError(t, err)
+
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil, WithAuditPipeline(pipeline))
+	require.NoError(t, store.Put(WithActor(ctx, "alice"), "token", []byte("v")))
+	require.NoError(t, pipeline.Close(ctx))
+
+	_, err = auditStore.Query(AuditQuery{})
+	require.NoError(t, err, "queries work after the sink is closed")
+	events, err := auditStore.Query(AuditQuery{Actor: "alice"})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "put", events[0].Operation)
+}
@@ -0,0 +1,223 @@
+package vault
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal AWS Signature Version 4 signing and credential discovery for the KMS
+// key provider, so the vault does not depend on the AWS SDK.
+
+const (
+	sigV4Algorithm       = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat      = "20060102T150405Z"
+	sigV4DateFormat      = "20060102"
+	defaultIMDSEndpoint  = "http://169.254.169.254"
+	imdsTokenTTLSeconds  = "21600"
+	credentialRefreshGap = 5 * time.Minute
+)
+
+// AWSCredentials are the access keys used to sign AWS requests.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Expiration is set for temporary credentials; zero means they do not expire.
+	Expiration time.Time
+}
+
+// awsCredentialSource resolves credentials: static, then environment, then
+// the EC2 instance metadata service (IMDSv2), caching temporary credentials
+// until shortly before they expire.
+type awsCredentialSource struct {
+	static       *AWSCredentials
+	imdsEndpoint string
+	client       *http.Client
+
+	mu     sync.Mutex
+	cached *AWSCredentials
+}
+
+func (s *awsCredentialSource) get(ctx context.Context) (AWSCredentials, error) {
+	if s.static != nil {
+		return *s.static, nil
+	}
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return AWSCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached != nil && (s.cached.Expiration.IsZero() || time.Until(s.cached.Expiration) > credentialRefreshGap) {
+		return *s.cached, nil
+	}
+	creds, err := s.fetchInstanceCredentials(ctx)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("no AWS credentials configured and instance metadata unavailable: %w", err)
+	}
+	s.cached = &creds
+	return creds, nil
+}
+
+// fetchInstanceCredentials reads the instance role credentials using an IMDSv2 session token.
+func (s *awsCredentialSource) fetchInstanceCredentials(ctx context.Context) (AWSCredentials, error) {
+	endpoint := s.imdsEndpoint
+	if endpoint == "" {
+		endpoint = defaultIMDSEndpoint
+	}
+	endpoint = strings.TrimRight(endpoint, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", imdsTokenTTLSeconds)
+	token, err := s.imdsRead(req)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("failed to get metadata token: %w", err)
+	}
+
+	get := func(path string) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		return s.imdsRead(req)
+	}
+
+	const credsPath = "/latest/meta-data/iam/security-credentials/"
+	roles, err := get(credsPath)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("failed to list instance roles: %w", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return AWSCredentials{}, fmt.Errorf("no instance role attached")
+	}
+
+	data, err := get(credsPath + role)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("failed to read role credentials: %w", err)
+	}
+	var resp struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return AWSCredentials{}, fmt.Errorf("failed to parse role credentials: %w", err)
+	}
+	return AWSCredentials{
+		AccessKeyID:     resp.AccessKeyID,
+		SecretAccessKey: resp.SecretAccessKey,
+		SessionToken:    resp.Token,
+		Expiration:      resp.Expiration,
+	}, nil
+}
+
+func (s *awsCredentialSource) imdsRead(req *http.Request) ([]byte, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: status %d", req.Method, req.URL.Path, resp.StatusCode)
+	}
+	return data, nil
+}
+
+// signSigV4 adds AWS Signature Version 4 headers to req. body must be the
+// exact request payload.
+func signSigV4(req *http.Request, body []byte, creds AWSCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(sigV4TimeFormat)
+	date := now.Format(sigV4DateFormat)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	payloadHash := sha256.Sum256(body)
+	canonicalHeaders, signedHeaders := sigV4CanonicalHeaders(req)
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	signature := hex.EncodeToString(hmacSHA256(sigV4SigningKey(creds.SecretAccessKey, date, region, service), []byte(stringToSign)))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// sigV4CanonicalHeaders signs the host header and every header already set on
+// the request, lower-cased and sorted.
+func sigV4CanonicalHeaders(req *http.Request) (canonical, signed string) {
+	headers := map[string]string{"host": req.Host}
+	if req.Host == "" {
+		headers["host"] = req.URL.Host
+	}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(headers[name])
+		b.WriteByte('\n')
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+// sigV4SigningKey derives the per-day, per-region, per-service signing key.
+func sigV4SigningKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+	return hmacSHA256(key, []byte("aws4_request"))
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,288 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// anyRevision disables the revision check on a write. No secret ever has it.
+const anyRevision int64 = math.MinInt64
+
+// BatchOp is one write in an atomic batch. Build it with PutOp or DeleteOp.
+type BatchOp struct {
+	key        string
+	value      []byte
+	delete     bool
+	meta       *SecretMetadata
+	ifRevision int64
+}
+
+// PutOp stores value as a new version of key, keeping its metadata as Put does.
+func PutOp(key string, value []byte) BatchOp {
+	return BatchOp{key: key, value: value, ifRevision: anyRevision}
+}
+
+// DeleteOp removes key and all of its versions. The key must exist.
+func DeleteOp(key string) BatchOp {
+	return BatchOp{key: key, delete: true, ifRevision: anyRevision}
+}
+
+// WithMetadata makes a put replace the secret's metadata, as PutWithMetadata does.
+func (op BatchOp) WithMetadata(meta SecretMetadata) BatchOp {
+	op.meta = &meta
+	return op
+}
+
+// IfRevision makes the whole batch fail with ErrConflict unless the key is at
+// the given revision. Revision 0 requires the key not to exist.
+func (op BatchOp) IfRevision(revision int64) BatchOp {
+	op.ifRevision = revision
+	return op
+}
+
+// GetWithRevision retrieves a secret together with its revision, for use with
+// PutIfRevision.
+func (s *aesgcmStore) GetWithRevision(ctx context.Context, key string) ([]byte, int64, error) {
+	return s.get(ctx, key)
+}
+
+// PutIfRevision stores a secret only if it is still at the given revision and
+// returns its new revision. Revision 0 creates the secret only if it doesn't
+// exist yet.
+func (s *aesgcmStore) PutIfRevision(ctx context.Context, key string, value []byte, revision int64) (int64, error) {
+	return s.put(ctx, key, value, nil, revision)
+}
+
+// ApplyBatch applies ops as one commit: either every put and delete takes
+// effect under a single new revision, which is returned, or none does. Each key
+// may appear only once.
+func (s *aesgcmStore) ApplyBatch(ctx context.Context, ops []BatchOp) (int64, error) {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "batch",
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	fail := func(err error) (int64, error) {
+		event.Success = false
+		event.Error = err.Error()
+		return 0, err
+	}
+
+	if err := s.checkUnsealed(); err != nil {
+		return fail(err)
+	}
+	if len(ops) == 0 {
+		return fail(fmt.Errorf("%w: no operations", ErrInvalidBatch))
+	}
+
+	keys := make([]string, 0, len(ops))
+	seen := make(map[string]bool, len(ops))
+	malformed := make(map[string]error)
+	puts := 0
+	for _, op := range ops {
+		// Only new keys must be well-formed; that is checked under the lock.
+		if err := validateKey(op.key); errors.Is(err, ErrKeyEmpty) {
+			return fail(err)
+		} else if err != nil {
+			malformed[op.key] = err
+		}
+		operation := "put"
+		if op.delete {
+			operation = "delete"
+		}
+		if err := s.authorize(ctx, operation, op.key); err != nil {
+			return fail(err)
+		}
+		if seen[op.key] {
+			return fail(fmt.Errorf("%w: key %q appears more than once", ErrInvalidBatch, op.key))
+		}
+		seen[op.key] = true
+		keys = append(keys, op.key)
+		if op.delete {
+			continue
+		}
+		puts++
+		if len(op.value) == 0 {
+			return fail(fmt.Errorf("%w: %q", ErrValueEmpty, op.key))
+		}
+		if op.meta != nil {
+			if err := op.meta.validate(); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	sort.Strings(keys)
+	event.Metadata = map[string]string{
+		"keys":    strings.Join(keys, ","),
+		"puts":    fmt.Sprintf("%d", puts),
+		"deletes": fmt.Sprintf("%d", len(ops)-puts),
+	}
+
+	keyID, err := s.activeKey(ctx)
+	if errors.Is(err, ErrSealed) {
+		return fail(err)
+	}
+	if err != nil {
+		return fail(fmt.Errorf("%w: %v", ErrKeyProvider, err))
+	}
+
+	// Encrypt everything before taking the lock.
+	sealed := make([][]byte, len(ops))
+	for i, op := range ops {
+		if op.delete {
+			continue
+		}
+		if sealed[i], err = s.seal(op.key, keyID, op.value); err != nil {
+			return fail(fmt.Errorf("%w: %v", ErrEncryption, err))
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	actor := ActorFromContext(ctx)
+	changes := make(map[string]*secretEntry, len(ops))
+	for i, op := range ops {
+		previous, exists := s.secrets[op.key]
+		if err := malformed[op.key]; err != nil && !exists && !op.delete {
+			return fail(err)
+		}
+		if err := checkRevision(op.key, previous, op.ifRevision); err != nil {
+			return fail(err)
+		}
+		if op.delete {
+			if !exists {
+				return fail(fmt.Errorf("%w: %q", ErrNotFound, op.key))
+			}
+			changes[op.key] = nil
+			continue
+		}
+
+		if exists {
+			if err := s.verifyEntry(op.key, previous); err != nil {
+				return fail(err)
+			}
+		}
+		entry := previous.withVersion(sealed[i], now, actor, s.keepVersions)
+		if op.meta != nil {
+			entry.Metadata = entry.Metadata.replacedBy(*op.meta)
+		} else {
+			entry.Metadata.ExpiresAt = time.Time{}
+		}
+		changes[op.key] = entry
+	}
+
+	revision, err := s.commitLocked(changes)
+	if err != nil {
+		return fail(fmt.Errorf("%w: %v", ErrPersistence, err))
+	}
+
+	event.Success = true
+	event.Metadata["revision"] = fmt.Sprintf("%d", revision)
+	return revision, nil
+}
+
+// checkRevision returns ErrConflict unless entry, which is nil for a missing
+// key, is at revision want.
+func checkRevision(key string, entry *secretEntry, want int64) error {
+	if want == anyRevision {
+		return nil
+	}
+	var have int64
+	if entry != nil {
+		have = entry.Revision
+	}
+	if have != want {
+		return fmt.Errorf("%w: %q is at revision %d, not %d", ErrConflict, key, have, want)
+	}
+	return nil
+}
+
+// commitLocked installs changes under a new store revision and persists them,
+// putting everything back if persisting fails. A nil entry deletes its key;
+// watchers see EventPut for the other keys and EventDelete for those. Entries
+// must be fresh copies; their Revision and MAC are set here, the MAC under the
+// active key, and legacy entries left unsigned are signed along with them.
+// Callers must hold s.mu for writing.
+func (s *aesgcmStore) commitLocked(changes map[string]*secretEntry) (int64, error) {
+	return s.commitAsLocked(changes, EventDelete)
+}
+
+// commitAsLocked is commitLocked with deleted keys published to watchers as
+// removedEvent instead of EventDelete.
+func (s *aesgcmStore) commitAsLocked(changes map[string]*secretEntry, removedEvent WatchEventType) (int64, error) {
+	needsKey := s.legacyEntries.Load()
+	for _, entry := range changes {
+		needsKey = needsKey || entry != nil
+	}
+	var keyID string
+	if needsKey {
+		id, err := s.keyring.ActiveID()
+		if err != nil {
+			return 0, fmt.Errorf("failed to authenticate entries: %w", err)
+		}
+		keyID = id
+	}
+	for key, entry := range changes {
+		if entry == nil {
+			continue
+		}
+		if err := s.signEntry(key, entry, keyID); err != nil {
+			return 0, fmt.Errorf("failed to authenticate entry %q: %w", key, err)
+		}
+	}
+	previous, err := s.signLegacyLocked(keyID)
+	if err != nil {
+		return 0, err
+	}
+
+	revision := s.revision + 1
+	for key, entry := range changes {
+		if _, signed := previous[key]; !signed {
+			previous[key] = s.secrets[key]
+		}
+		if entry == nil {
+			delete(s.secrets, key)
+			continue
+		}
+		entry.Revision = revision
+		s.secrets[key] = entry
+	}
+	s.revision = revision
+
+	if err := s.persistLocked(); err != nil {
+		for key, entry := range previous {
+			if entry == nil {
+				delete(s.secrets, key)
+			} else {
+				s.secrets[key] = entry
+			}
+		}
+		s.revision = revision - 1
+		return 0, err
+	}
+
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	events := make([]WatchEvent, len(keys))
+	for i, key := range keys {
+		events[i] = WatchEvent{Type: removedEvent, Key: key, Revision: revision}
+		if entry := changes[key]; entry != nil {
+			events[i].Type = EventPut
+			events[i].Version = entry.current().Number
+		}
+	}
+	s.publish(events...)
+	return revision, nil
+}
@@ -0,0 +1,176 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAESGCMStore_PutIfRevision(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+
+	rev, err := store.PutIfRevision(ctx, "token", []byte("v1"), 0)
+	require.NoError(t, err, "revision 0 creates")
+	_, err = store.PutIfRevision(ctx, "token", []byte("again"), 0)
+	require.ErrorIs(t, err, ErrConflict, "revision 0 does not overwrite")
+
+	value, got, err := store.GetWithRevision(ctx, "token")
+	require.NoError(t, err)
+	require.Equal(t, []byte("v1"), value)
+	require.Equal(t, rev, got)
+
+	// Another writer updates the secret in between.
+	require.NoError(t, store.Put(ctx, "token", []byte("other")))
+
+	_, err = store.PutIfRevision(ctx, "token", []byte("stale"), rev)
+	require.ErrorIs(t, err, ErrConflict)
+	value, rev, err = store.GetWithRevision(ctx, "token")
+	require.NoError(t, err)
+	require.Equal(t, []byte("other"), value)
+
+	next, err := store.PutIfRevision(ctx, "token", []byte("v3"), rev)
+	require.NoError(t, err)
+	require.Greater(t, next, rev)
+
+	t.Run("concurrent writers never lose an update", func(t *testing.T) {
+		_, err := store.PutIfRevision(ctx, "counter", []byte("0"), 0)
+		require.NoError(t, err)
+
+		const writers, increments = 4, 25
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < increments; {
+					value, rev, err := store.GetWithRevision(ctx, "counter")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					n, _ := strconv.Atoi(string(value))
+					_, err = store.PutIfRevision(ctx, "counter", []byte(strconv.Itoa(n+1)), rev)
+					if errors.Is(err, ErrConflict) {
+						continue
+					}
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					i++
+				}
+			}()
+		}
+		wg.Wait()
+
+		value, err := store.Get(ctx, "counter")
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprint(writers*increments), string(value))
+	})
+}
+
+func TestAESGCMStore_ApplyBatch(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	var auditEvents []AuditEvent
+	store := NewAESGCMStore(keyProvider, func(e AuditEvent) {
+		auditEvents = append(auditEvents, e)
+	}).(*aesgcmStore)
+	require.NoError(t, store.Put(ctx, "old", []byte("value")))
+
+	rev, err := store.ApplyBatch(ctx, []BatchOp{
+		PutOp("s3/access-key", []byte("AKIA")).WithMetadata(SecretMetadata{Type: SecretTypeAccessKeyPair}),
+		PutOp("s3/secret-key", []byte("secret")).IfRevision(0),
+		DeleteOp("old"),
+	})
+	require.NoError(t, err)
+
+	last := auditEvents[len(auditEvents)-1]
+	require.Equal(t, "batch", last.Operation)
+	require.True(t, last.Success)
+	require.Equal(t, "old,s3/access-key,s3/secret-key", last.Metadata["keys"])
+	require.Equal(t, "2", last.Metadata["puts"])
+	require.Equal(t, "1", last.Metadata["deletes"])
+
+	keys, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"s3/access-key", "s3/secret-key"}, keys)
+	for _, key := range keys {
+		_, got, err := store.GetWithRevision(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, rev, got, "a batch commits under one revision")
+	}
+	meta, err := store.GetMetadata(ctx, "s3/access-key")
+	require.NoError(t, err)
+	require.Equal(t, SecretTypeAccessKeyPair, meta.Type)
+
+	failures := []struct {
+		name string
+		ops  []BatchOp
+		want error
+	}{
+		{name: "empty", ops: nil, want: ErrInvalidBatch},
+		{name: "duplicate key", ops: []BatchOp{PutOp("a", []byte("1")), DeleteOp("a")}, want: ErrInvalidBatch},
+		{name: "empty key", ops: []BatchOp{PutOp("", []byte("1"))}, want: ErrKeyEmpty},
+		{name: "empty value", ops: []BatchOp{PutOp("new", nil)}, want: ErrValueEmpty},
+		{name: "invalid metadata", ops: []BatchOp{PutOp("new", []byte("1")).WithMetadata(SecretMetadata{Type: "bogus"})}, want: ErrInvalidMetadata},
+		{name: "missing delete", ops: []BatchOp{PutOp("new", []byte("1")), DeleteOp("missing")}, want: ErrNotFound},
+		{name: "stale revision", ops: []BatchOp{PutOp("new", []byte("1")), PutOp("s3/secret-key", []byte("x")).IfRevision(rev - 1)}, want: ErrConflict},
+	}
+	for _, tt := range failures {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := store.ApplyBatch(ctx, tt.ops)
+			require.ErrorIs(t, err, tt.want)
+
+			keys, err := store.List(ctx)
+			require.NoError(t, err)
+			require.Equal(t, []string{"s3/access-key", "s3/secret-key"}, keys, "failed batch changes nothing")
+		})
+	}
+}
+
+func TestFileStore_BatchPersistence(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	store, err := internalStore(NewFileStore(path, keyProvider, nil))
+	require.NoError(t, err)
+	rev, err := store.ApplyBatch(ctx, []BatchOp{PutOp("a", []byte("1")), PutOp("b", []byte("2"))})
+	require.NoError(t, err)
+
+	require.NoError(t, store.Close())
+	reopened, err := internalStore(NewFileStore(path, keyProvider, nil))
+	require.NoError(t, err)
+	_, got, err := reopened.GetWithRevision(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, rev, got, "revisions survive a restart")
+
+	next, err := reopened.PutIfRevision(ctx, "a", []byte("3"), rev)
+	require.NoError(t, err)
+	require.Greater(t, next, rev)
+
+	// A failed write rolls back every entry in the batch.
+	reopened.path = filepath.Join(t.TempDir(), "missing", "secrets.json")
+	_, err = reopened.ApplyBatch(ctx, []BatchOp{PutOp("a", []byte("4")), DeleteOp("b")})
+	require.ErrorIs(t, err, ErrPersistence)
+
+	value, got, err := reopened.GetWithRevision(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("3"), value)
+	require.Equal(t, next, got)
+	_, err = reopened.Get(ctx, "b")
+	require.NoError(t, err)
+}
@@ -0,0 +1,436 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"filippo.io/age"
+)
+
+// Bundle format identifiers. A bundle is an age-encrypted JSON document, so
+// any modification or truncation makes it fail to decrypt.
+const (
+	bundleFormat        = "cloudmoor-vault-bundle"
+	bundleFormatVersion = 1
+)
+
+// bundleScryptWorkFactor is the scrypt cost used for passphrase-protected
+// bundles (2^18 iterations, age's default).
+var bundleScryptWorkFactor = 18
+
+// ExportOptions configures Export. Exactly one of Passphrase and Recipients
+// must be set.
+type ExportOptions struct {
+	// Namespace limits the export to one namespace and those nested below it.
+	// Empty exports the whole vault.
+	Namespace string
+
+	// Passphrase protects the bundle with a passphrase unrelated to the
+	// vault's own master key.
+	Passphrase string
+
+	// Recipients are age ("age1...") or SSH public keys that may decrypt the
+	// bundle.
+	Recipients []string
+}
+
+// ImportMode says how Import treats secrets that already exist.
+type ImportMode string
+
+// Import modes.
+const (
+	// ImportMerge adds secrets missing from the vault and leaves existing
+	// ones untouched, reporting them as conflicts.
+	ImportMerge ImportMode = "merge"
+	// ImportReplace makes the bundle's namespace match the bundle exactly:
+	// conflicting secrets are overwritten and secrets absent from the bundle
+	// are removed.
+	ImportReplace ImportMode = "replace"
+)
+
+// ImportOptions configures Import. Set Passphrase for passphrase-protected
+// bundles, or IdentityFile for bundles exported to recipients.
+type ImportOptions struct {
+	Mode         ImportMode
+	DryRun       bool // Report what would change without writing
+	Passphrase   string
+	IdentityFile string // age identity file or OpenSSH private key
+}
+
+// ImportResult reports what Import changed, or would change on a dry run.
+// Each list is in alphabetical order.
+type ImportResult struct {
+	Imported  []string `json:"imported"`
+	Conflicts []string `json:"conflicts"` // Present in both the vault and the bundle
+	Removed   []string `json:"removed"`
+}
+
+// bundle is the decrypted content of an export.
+type bundle struct {
+	Format    string         `json:"format"`
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"created_at"`
+	Namespace string         `json:"namespace"`
+	Secrets   []bundleSecret `json:"secrets"`
+}
+
+// bundleSecret is one secret with its full retained history in plaintext.
+type bundleSecret struct {
+	Key      string          `json:"key"`
+	Metadata SecretMetadata  `json:"metadata"`
+	Versions []bundleVersion `json:"versions"` // Oldest first
+}
+
+// bundleVersion is one plaintext version of a secret.
+type bundleVersion struct {
+	Number    int       `json:"number"`
+	CreatedAt time.Time `json:"created_at"`
+	Actor     string    `json:"actor,omitempty"`
+	Value     []byte    `json:"value"`
+}
+
+// wipe overwrites every plaintext value in b.
+func (b *bundle) wipe() {
+	for _, secret := range b.Secrets {
+		for _, v := range secret.Versions {
+			wipe(v.Value)
+		}
+	}
+}
+
+// Export writes every secret in opts.Namespace, with its metadata and all
+// retained versions, to w as an encrypted bundle, and returns the number of
+// secrets written. Values are decrypted from the vault and re-encrypted for
+// the bundle, so it can be imported into a vault with a different master key.
+func (s *aesgcmStore) Export(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "export",
+		Key:       opts.Namespace,
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	fail := func(err error) (int, error) {
+		event.Success = false
+		event.Error = err.Error()
+		return 0, err
+	}
+
+	if err := s.checkUnsealed(); err != nil {
+		return fail(err)
+	}
+	recipients, err := bundleRecipients(opts)
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := s.activeKey(ctx); errors.Is(err, ErrSealed) {
+		return fail(err)
+	} else if err != nil {
+		return fail(fmt.Errorf("%w: %v", ErrKeyProvider, err))
+	}
+
+	s.mu.RLock()
+	entries := make(map[string]*secretEntry)
+	for key, entry := range s.secrets {
+		if InNamespace(key, opts.Namespace) {
+			entries[key] = entry
+		}
+	}
+	s.mu.RUnlock()
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		if err := s.authorize(ctx, "export", key); err != nil {
+			return fail(err)
+		}
+		// Import would refuse the bundle, so say so now.
+		if err := validateKey(key); err != nil {
+			return fail(fmt.Errorf("%w; move the secret to a well-formed key before exporting it", err))
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	b := bundle{
+		Format:    bundleFormat,
+		Version:   bundleFormatVersion,
+		CreatedAt: start,
+		Namespace: opts.Namespace,
+		Secrets:   make([]bundleSecret, 0, len(keys)),
+	}
+	defer b.wipe()
+	versions := 0
+	for _, key := range keys {
+		entry := entries[key]
+		if err := s.verifyEntry(key, entry); err != nil {
+			return fail(err)
+		}
+		secret := bundleSecret{Key: key, Metadata: entry.Metadata.clone()}
+		for _, v := range entry.Versions {
+			plaintext, _, err := s.open(key, v.Data)
+			if err != nil {
+				return fail(fmt.Errorf("%w: entry %q version %d: %v", ErrDecryption, key, v.Number, err))
+			}
+			secret.Versions = append(secret.Versions, bundleVersion{
+				Number:    v.Number,
+				CreatedAt: v.CreatedAt,
+				Actor:     v.Actor,
+				Value:     plaintext,
+			})
+			versions++
+		}
+		b.Secrets = append(b.Secrets, secret)
+	}
+
+	plaintext, err := json.Marshal(b)
+	if err != nil {
+		return fail(fmt.Errorf("failed to encode bundle: %w", err))
+	}
+	defer wipe(plaintext)
+
+	aw, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return fail(fmt.Errorf("%w: %v", ErrEncryption, err))
+	}
+	if _, err := aw.Write(plaintext); err != nil {
+		return fail(fmt.Errorf("failed to write bundle: %w", err))
+	}
+	if err := aw.Close(); err != nil {
+		return fail(fmt.Errorf("failed to write bundle: %w", err))
+	}
+
+	event.Success = true
+	event.Metadata = map[string]string{
+		"count":    fmt.Sprintf("%d", len(keys)),
+		"versions": fmt.Sprintf("%d", versions),
+	}
+	return len(keys), nil
+}
+
+// Import reads a bundle written by Export and applies it as one commit
+// according to opts.Mode. Imported secrets keep their metadata and version
+// history and are re-encrypted under the active master key.
+func (s *aesgcmStore) Import(ctx context.Context, r io.Reader, opts ImportOptions) (ImportResult, error) {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "import",
+		Metadata: map[string]string{
+			"mode":    string(opts.Mode),
+			"dry_run": fmt.Sprintf("%t", opts.DryRun),
+		},
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	fail := func(err error) (ImportResult, error) {
+		event.Success = false
+		event.Error = err.Error()
+		return ImportResult{}, err
+	}
+
+	if err := s.checkUnsealed(); err != nil {
+		return fail(err)
+	}
+	if opts.Mode != ImportMerge && opts.Mode != ImportReplace {
+		return fail(fmt.Errorf("vault: unknown import mode %q", opts.Mode))
+	}
+
+	b, err := readBundle(r, opts)
+	if err != nil {
+		return fail(err)
+	}
+	defer b.wipe()
+	event.Key = b.Namespace
+
+	for _, secret := range b.Secrets {
+		if err := s.authorize(ctx, "import", secret.Key); err != nil {
+			return fail(err)
+		}
+	}
+
+	keyID, err := s.activeKey(ctx)
+	if errors.Is(err, ErrSealed) {
+		return fail(err)
+	}
+	if err != nil {
+		return fail(fmt.Errorf("%w: %v", ErrKeyProvider, err))
+	}
+
+	// Encrypt everything before taking the lock; a dry run writes nothing.
+	sealed := make(map[string]*secretEntry, len(b.Secrets))
+	if !opts.DryRun {
+		for _, secret := range b.Secrets {
+			entry := &secretEntry{Metadata: secret.Metadata.clone()}
+			for _, v := range secret.Versions {
+				data, err := s.seal(secret.Key, keyID, v.Value)
+				if err != nil {
+					return fail(fmt.Errorf("%w: %v", ErrEncryption, err))
+				}
+				entry.Versions = append(entry.Versions, secretVersion{
+					Number:    v.Number,
+					CreatedAt: v.CreatedAt,
+					Actor:     v.Actor,
+					Data:      data,
+				})
+			}
+			if s.keepVersions > 0 && len(entry.Versions) > s.keepVersions {
+				entry.Versions = entry.Versions[len(entry.Versions)-s.keepVersions:]
+			}
+			sealed[secret.Key] = entry
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := ImportResult{Imported: []string{}, Conflicts: []string{}, Removed: []string{}}
+	changes := make(map[string]*secretEntry)
+	inBundle := make(map[string]bool, len(b.Secrets))
+	for _, secret := range b.Secrets {
+		inBundle[secret.Key] = true
+		if _, exists := s.secrets[secret.Key]; exists {
+			result.Conflicts = append(result.Conflicts, secret.Key)
+			if opts.Mode == ImportMerge {
+				continue
+			}
+		}
+		result.Imported = append(result.Imported, secret.Key)
+		changes[secret.Key] = sealed[secret.Key]
+	}
+	if opts.Mode == ImportReplace {
+		for key := range s.secrets {
+			if InNamespace(key, b.Namespace) && !inBundle[key] {
+				if err := s.authorize(ctx, "delete", key); err != nil {
+					return fail(err)
+				}
+				result.Removed = append(result.Removed, key)
+				changes[key] = nil
+			}
+		}
+	}
+	sort.Strings(result.Imported)
+	sort.Strings(result.Conflicts)
+	sort.Strings(result.Removed)
+
+	event.Metadata["imported"] = fmt.Sprintf("%d", len(result.Imported))
+	event.Metadata["conflicts"] = fmt.Sprintf("%d", len(result.Conflicts))
+	event.Metadata["removed"] = fmt.Sprintf("%d", len(result.Removed))
+
+	if !opts.DryRun && len(changes) > 0 {
+		revision, err := s.commitLocked(changes)
+		if err != nil {
+			return fail(fmt.Errorf("%w: %v", ErrPersistence, err))
+		}
+		event.Metadata["revision"] = fmt.Sprintf("%d", revision)
+	}
+
+	event.Success = true
+	return result, nil
+}
+
+// bundleRecipients returns the age recipients for an export.
+func bundleRecipients(opts ExportOptions) ([]age.Recipient, error) {
+	switch {
+	case opts.Passphrase != "" && len(opts.Recipients) > 0:
+		return nil, fmt.Errorf("vault: export takes a passphrase or recipients, not both")
+	case opts.Passphrase != "":
+		r, err := age.NewScryptRecipient(opts.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		r.SetWorkFactor(bundleScryptWorkFactor)
+		return []age.Recipient{r}, nil
+	case len(opts.Recipients) > 0:
+		recipients := make([]age.Recipient, 0, len(opts.Recipients))
+		for _, s := range opts.Recipients {
+			_, r, err := parseAgeRecipient(s)
+			if err != nil {
+				return nil, err
+			}
+			recipients = append(recipients, r)
+		}
+		return recipients, nil
+	default:
+		return nil, fmt.Errorf("vault: export needs a passphrase or recipients")
+	}
+}
+
+// readBundle decrypts and validates a bundle.
+func readBundle(r io.Reader, opts ImportOptions) (*bundle, error) {
+	var identities []age.Identity
+	switch {
+	case opts.Passphrase != "":
+		id, err := age.NewScryptIdentity(opts.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, id)
+	case opts.IdentityFile != "":
+		ids, err := loadAgeIdentities(opts.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		identities = ids
+	default:
+		return nil, fmt.Errorf("vault: import needs a passphrase or identity file")
+	}
+
+	ar, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	var buf bytes.Buffer
+	defer func() { wipe(buf.Bytes()) }()
+	if _, err := io.Copy(&buf, ar); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+
+	var b bundle
+	if err := json.Unmarshal(buf.Bytes(), &b); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if err := b.validate(); err != nil {
+		b.wipe()
+		return nil, err
+	}
+	return &b, nil
+}
+
+// validate checks that a decrypted bundle is well-formed.
+func (b *bundle) validate() error {
+	if b.Format != bundleFormat || b.Version != bundleFormatVersion {
+		return fmt.Errorf("%w: unsupported format %q version %d", ErrInvalidBundle, b.Format, b.Version)
+	}
+	seen := make(map[string]bool, len(b.Secrets))
+	for _, secret := range b.Secrets {
+		if err := validateKey(secret.Key); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+		if !InNamespace(secret.Key, b.Namespace) {
+			return fmt.Errorf("%w: %q is outside namespace %q", ErrInvalidBundle, secret.Key, b.Namespace)
+		}
+		if seen[secret.Key] {
+			return fmt.Errorf("%w: %q appears more than once", ErrInvalidBundle, secret.Key)
+		}
+		seen[secret.Key] = true
+		if err := secret.Metadata.validate(); err != nil {
+			return fmt.Errorf("%w: %q: %v", ErrInvalidBundle, secret.Key, err)
+		}
+		if len(secret.Versions) == 0 {
+			return fmt.Errorf("%w: %q has no versions", ErrInvalidBundle, secret.Key)
+		}
+		for i, v := range secret.Versions {
+			if len(v.Value) == 0 || (i > 0 && v.Number <= secret.Versions[i-1].Number) {
+				return fmt.Errorf("%w: %q has an invalid version %d", ErrInvalidBundle, secret.Key, v.Number)
+			}
+		}
+	}
+	return nil
+}
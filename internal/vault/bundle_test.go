@@ -0,0 +1,265 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newBundleStore returns an in-memory store holding a few secrets, one of them
+// with several versions and metadata.
+func newBundleStore(t *testing.T) *aesgcmStore {
+	t.Helper()
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+
+	require.NoError(t, store.Put(WithActor(ctx, "alice"), "mounts/abc/token", []byte("v1")))
+	require.NoError(t, store.PutWithMetadata(ctx, "mounts/abc/token", []byte("v2"), SecretMetadata{
+		Type:   SecretTypeOAuthToken,
+		Labels: map[string]string{"env": "prod"},
+	}))
+	require.NoError(t, store.Put(ctx, "mounts/xyz/token", []byte("xyz")))
+	require.NoError(t, store.Put(ctx, "oauth/dropbox/refresh", []byte("refresh")))
+	return store
+}
+
+func TestAESGCMStore_ExportImport(t *testing.T) {
+	defer func(f int) { bundleScryptWorkFactor = f }(bundleScryptWorkFactor)
+	bundleScryptWorkFactor = 10
+
+	ctx := context.Background()
+	source := newBundleStore(t)
+
+	var buf bytes.Buffer
+	n, err := source.Export(ctx, &buf, ExportOptions{Passphrase: "correct horse"})
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.NotContains(t, buf.String(), "refresh", "bundles are encrypted")
+
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	var auditEvents []AuditEvent
+	target := NewAESGCMStore(keyProvider, func(e AuditEvent) {
+		auditEvents = append(auditEvents, e)
+	}).(*aesgcmStore)
+
+	result, err := target.Import(ctx, bytes.NewReader(buf.Bytes()), ImportOptions{Mode: ImportMerge, Passphrase: "correct horse"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"mounts/abc/token", "mounts/xyz/token", "oauth/dropbox/refresh"}, result.Imported)
+	require.Empty(t, result.Conflicts)
+
+	last := auditEvents[len(auditEvents)-1]
+	require.Equal(t, "import", last.Operation)
+	require.True(t, last.Success)
+	require.Equal(t, "3", last.Metadata["imported"])
+
+	value, err := target.Get(ctx, "mounts/abc/token")
+	require.NoError(t, err)
+	require.Equal(t, []byte("v2"), value)
+	old, err := target.GetVersion(ctx, "mounts/abc/token", 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("v1"), old)
+
+	versions, err := target.ListVersions(ctx, "mounts/abc/token")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, "alice", versions[0].Actor, "version history is preserved")
+
+	meta, err := target.GetMetadata(ctx, "mounts/abc/token")
+	require.NoError(t, err)
+	require.Equal(t, SecretTypeOAuthToken, meta.Type)
+	require.Equal(t, "prod", meta.Labels["env"])
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		_, err := target.Import(ctx, bytes.NewReader(buf.Bytes()), ImportOptions{Mode: ImportMerge, Passphrase: "wrong"})
+		require.ErrorIs(t, err, ErrInvalidBundle)
+	})
+
+	t.Run("tampered bundle", func(t *testing.T) {
+		tampered := bytes.Clone(buf.Bytes())
+		tampered[len(tampered)-20] ^= 0x01
+		_, err := target.Import(ctx, bytes.NewReader(tampered), ImportOptions{Mode: ImportMerge, Passphrase: "correct horse"})
+		require.ErrorIs(t, err, ErrInvalidBundle)
+
+		_, err = target.Import(ctx, bytes.NewReader(buf.Bytes()[:buf.Len()-10]), ImportOptions{Mode: ImportMerge, Passphrase: "correct horse"})
+		require.ErrorIs(t, err, ErrInvalidBundle, "truncated bundles are rejected")
+	})
+
+	t.Run("options", func(t *testing.T) {
+		_, err := source.Export(ctx, &bytes.Buffer{}, ExportOptions{})
+		require.Error(t, err)
+		_, err = source.Export(ctx, &bytes.Buffer{}, ExportOptions{Passphrase: "p", Recipients: []string{"age1x"}})
+		require.Error(t, err)
+		_, err = target.Import(ctx, bytes.NewReader(buf.Bytes()), ImportOptions{Mode: "upsert", Passphrase: "correct horse"})
+		require.Error(t, err)
+	})
+}
+
+func TestAESGCMStore_ExportRecipients(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	identity, recipient := writeAgeIdentity(t, dir, "identity.txt")
+	other, _ := writeAgeIdentity(t, dir, "other.txt")
+	source := newBundleStore(t)
+
+	var buf bytes.Buffer
+	n, err := source.Export(ctx, &buf, ExportOptions{Namespace: "mounts/abc", Recipients: []string{recipient}})
+	require.NoError(t, err)
+	require.Equal(t, 1, n, "only the namespace is exported")
+
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	target := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+
+	_, err = target.Import(ctx, bytes.NewReader(buf.Bytes()), ImportOptions{Mode: ImportMerge, IdentityFile: other})
+	require.ErrorIs(t, err, ErrInvalidBundle)
+
+	result, err := target.Import(ctx, bytes.NewReader(buf.Bytes()), ImportOptions{Mode: ImportMerge, IdentityFile: identity})
+	require.NoError(t, err)
+	require.Equal(t, []string{"mounts/abc/token"}, result.Imported)
+}
+
+func TestAESGCMStore_ImportModes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	identity, recipient := writeAgeIdentity(t, dir, "identity.txt")
+
+	var buf bytes.Buffer
+	_, err := newBundleStore(t).Export(ctx, &buf, ExportOptions{Namespace: "mounts", Recipients: []string{recipient}})
+	require.NoError(t, err)
+	bundle := buf.Bytes()
+
+	// newTarget returns a store that shares one key with the bundle, has one
+	// key the bundle lacks, and one unrelated key outside its namespace.
+	newTarget := func(t *testing.T) *aesgcmStore {
+		keyProvider, err := NewInMemoryKeyProvider()
+		require.NoError(t, err)
+		target := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+		require.NoError(t, target.Put(ctx, "mounts/abc/token", []byte("local")))
+		require.NoError(t, target.Put(ctx, "mounts/old/token", []byte("stale")))
+		require.NoError(t, target.Put(ctx, "oauth/dropbox/refresh", []byte("keep")))
+		return target
+	}
+
+	tests := []struct {
+		name     string
+		opts     ImportOptions
+		want     ImportResult
+		wantKeys []string
+		wantABC  string
+	}{
+		{
+			name: "merge keeps existing secrets",
+			opts: ImportOptions{Mode: ImportMerge},
+			want: ImportResult{
+				Imported:  []string{"mounts/xyz/token"},
+				Conflicts: []string{"mounts/abc/token"},
+				Removed:   []string{},
+			},
+			wantKeys: []string{"mounts/abc/token", "mounts/old/token", "mounts/xyz/token", "oauth/dropbox/refresh"},
+			wantABC:  "local",
+		},
+		{
+			name: "replace mirrors the bundle namespace",
+			opts: ImportOptions{Mode: ImportReplace},
+			want: ImportResult{
+				Imported:  []string{"mounts/abc/token", "mounts/xyz/token"},
+				Conflicts: []string{"mounts/abc/token"},
+				Removed:   []string{"mounts/old/token"},
+			},
+			wantKeys: []string{"mounts/abc/token", "mounts/xyz/token", "oauth/dropbox/refresh"},
+			wantABC:  "v2",
+		},
+		{
+			name: "dry run writes nothing",
+			opts: ImportOptions{Mode: ImportReplace, DryRun: true},
+			want: ImportResult{
+				Imported:  []string{"mounts/abc/token", "mounts/xyz/token"},
+				Conflicts: []string{"mounts/abc/token"},
+				Removed:   []string{"mounts/old/token"},
+			},
+			wantKeys: []string{"mounts/abc/token", "mounts/old/token", "oauth/dropbox/refresh"},
+			wantABC:  "local",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTarget(t)
+			_, before, err := target.GetWithRevision(ctx, "oauth/dropbox/refresh")
+			require.NoError(t, err)
+
+			tt.opts.IdentityFile = identity
+			result, err := target.Import(ctx, bytes.NewReader(bundle), tt.opts)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, result)
+
+			keys, err := target.List(ctx)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantKeys, keys)
+			value, err := target.Get(ctx, "mounts/abc/token")
+			require.NoError(t, err)
+			require.Equal(t, tt.wantABC, string(value))
+
+			_, after, err := target.GetWithRevision(ctx, "oauth/dropbox/refresh")
+			require.NoError(t, err)
+			require.Equal(t, before, after, "keys outside the namespace are untouched")
+		})
+	}
+}
+
+func TestBundleValidate(t *testing.T) {
+	version := []bundleVersion{{Number: 1, CreatedAt: time.Now(), Value: []byte("v")}}
+	tests := []struct {
+		name   string
+		bundle bundle
+		valid  bool
+	}{
+		{
+			name:   "valid",
+			bundle: bundle{Format: bundleFormat, Version: bundleFormatVersion, Namespace: "mounts", Secrets: []bundleSecret{{Key: "mounts/abc/token", Versions: version}}},
+			valid:  true,
+		},
+		{
+			name:   "unknown format",
+			bundle: bundle{Format: "other", Version: bundleFormatVersion},
+		},
+		{
+			name:   "duplicate key",
+			bundle: bundle{Format: bundleFormat, Version: bundleFormatVersion, Secrets: []bundleSecret{{Key: "a", Versions: version}, {Key: "a", Versions: version}}},
+		},
+		{
+			name:   "key outside namespace",
+			bundle: bundle{Format: bundleFormat, Version: bundleFormatVersion, Namespace: "mounts", Secrets: []bundleSecret{{Key: "oauth/token", Versions: version}}},
+		},
+		{
+			name:   "invalid key",
+			bundle: bundle{Format: bundleFormat, Version: bundleFormatVersion, Secrets: []bundleSecret{{Key: "a//b", Versions: version}}},
+		},
+		{
+			name:   "no versions",
+			bundle: bundle{Format: bundleFormat, Version: bundleFormatVersion, Secrets: []bundleSecret{{Key: "a"}}},
+		},
+		{
+			name: "versions out of order",
+			bundle: bundle{Format: bundleFormat, Version: bundleFormatVersion, Secrets: []bundleSecret{{Key: "a", Versions: []bundleVersion{
+				{Number: 2, Value: []byte("v")}, {Number: 1, Value: []byte("v")},
+			}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.bundle.validate()
+			if tt.valid {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrInvalidBundle)
+		})
+	}
+}
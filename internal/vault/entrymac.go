@@ -0,0 +1,133 @@
+package vault
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Secret metadata and version headers are kept in clear so secrets can be
+// listed and filtered without decrypting them. Each entry therefore carries an
+// HMAC over its key name, metadata and version headers (with a digest of each
+// envelope), keyed by a subkey of a master key. Editing an expiry, a label or
+// a version on disk, or moving an entry to another name, breaks the MAC. What
+// it cannot catch is an entry replaced wholesale by an earlier authentic copy
+// of itself.
+
+// entryMACLabel derives the MAC subkey from a master key.
+var entryMACLabel = []byte("cloudmoor-entry-mac")
+
+// entryMACInput is the canonical form of an entry that its MAC covers.
+type entryMACInput struct {
+	Name     string            `json:"name"`
+	Metadata SecretMetadata    `json:"metadata"`
+	Versions []entryMACVersion `json:"versions"`
+}
+
+type entryMACVersion struct {
+	Number    int       `json:"number"`
+	CreatedAt time.Time `json:"created_at"`
+	Actor     string    `json:"actor,omitempty"`
+	Digest    []byte    `json:"digest"` // SHA-256 of the envelope
+}
+
+// entryMAC computes the MAC of the entry stored under name with master.
+func entryMAC(master []byte, name string, e *secretEntry) ([]byte, error) {
+	// Times are compared as instants, whatever zone they were parsed in.
+	meta := e.Metadata
+	meta.ExpiresAt = meta.ExpiresAt.UTC()
+	meta.RotateBy = meta.RotateBy.UTC()
+	meta.CreatedAt = meta.CreatedAt.UTC()
+	meta.UpdatedAt = meta.UpdatedAt.UTC()
+	in := entryMACInput{Name: name, Metadata: meta, Versions: make([]entryMACVersion, len(e.Versions))}
+	for i, v := range e.Versions {
+		digest := sha256.Sum256(v.Data)
+		in.Versions[i] = entryMACVersion{Number: v.Number, CreatedAt: v.CreatedAt.UTC(), Actor: v.Actor, Digest: digest[:]}
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode entry: %w", err)
+	}
+
+	sub := hmac.New(sha256.New, master)
+	sub.Write(entryMACLabel)
+	key := sub.Sum(nil)
+	defer wipe(key)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// signLegacyLocked signs every entry still without a MAC under keyID, so the
+// next write leaves none behind, and returns the entries it replaced for the
+// caller to put back if that write fails. These entries are trusted as loaded:
+// the upgrade to signed entries cannot vouch for what was on disk before it.
+// Callers must hold s.mu for writing.
+func (s *aesgcmStore) signLegacyLocked(keyID string) (map[string]*secretEntry, error) {
+	replaced := make(map[string]*secretEntry)
+	if !s.legacyEntries.Load() {
+		return replaced, nil
+	}
+	for key, entry := range s.secrets {
+		if len(entry.MAC) != 0 {
+			continue
+		}
+		signed := *entry
+		if err := s.signEntry(key, &signed, keyID); err != nil {
+			for key, entry := range replaced {
+				s.secrets[key] = entry
+			}
+			return nil, fmt.Errorf("failed to authenticate entry %q: %w", key, err)
+		}
+		replaced[key] = entry
+		s.secrets[key] = &signed
+	}
+	return replaced, nil
+}
+
+// signEntry sets the MAC of e, a fresh entry no one else can see yet, under
+// the master key keyID.
+func (s *aesgcmStore) signEntry(name string, e *secretEntry, keyID string) error {
+	return s.keyring.use(keyID, func(master []byte) error {
+		mac, err := entryMAC(master, name, e)
+		if err != nil {
+			return err
+		}
+		e.MACKey = keyID
+		e.MAC = mac
+		return nil
+	})
+}
+
+// verifyEntry returns an error wrapping ErrTampered unless e carries a valid
+// MAC for name. The only unsigned entries accepted are those loaded from a
+// store file older than version 3, and only until the store next writes the
+// file, which signs them; strict mode refuses even those. The keyring must be
+// loaded.
+func (s *aesgcmStore) verifyEntry(name string, e *secretEntry) error {
+	if len(e.MAC) == 0 {
+		switch {
+		case s.strictEnvelopes:
+			return fmt.Errorf("%w: %q is not authenticated and strict mode is on", ErrTampered, name)
+		case !s.legacyEntries.Load():
+			return fmt.Errorf("%w: %q is not authenticated", ErrTampered, name)
+		}
+		return nil
+	}
+	err := s.keyring.use(e.MACKey, func(master []byte) error {
+		mac, err := entryMAC(master, name, e)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal(mac, e.MAC) {
+			return fmt.Errorf("MAC does not match")
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %q: %v", ErrTampered, name, err)
+	}
+	return nil
+}
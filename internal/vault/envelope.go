@@ -0,0 +1,160 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Envelope format identifiers.
+const (
+	// envelopeVersion1 seals the secret directly with the master key.
+	envelopeVersion1 byte = 1
+
+	// envelopeVersion2 seals the secret with a per-secret data key (DEK) and
+	// stores that DEK wrapped by the master key alongside the payload.
+	envelopeVersion2 byte = 2
+
+	// envelopeVersion3 has the version 2 layout but authenticates the secret's
+	// key name and the envelope header as GCM additional data, so a ciphertext
+	// moved to another key name no longer opens.
+	envelopeVersion3 byte = 3
+
+	// algAES256GCM identifies AES-256-GCM with a 12-byte random nonce.
+	algAES256GCM byte = 1
+)
+
+// envelopeMagic prefixes every versioned ciphertext so it can be told apart
+// from legacy headerless entries.
+var envelopeMagic = []byte("CMV")
+
+// envelope is a sealed secret together with the information needed to open it.
+//
+// Wire layout:
+//
+//	version 1: magic "CMV" | version | algorithm | key ID length (1) | key ID | payload
+//	version 2, 3: magic "CMV" | version | algorithm | key ID length (1) | key ID |
+//	              wrapped DEK length (2, big endian) | wrapped DEK | payload
+//
+// Payloads and wrapped DEKs are algorithm-specific ciphertexts (nonce ||
+// ciphertext || tag for AES-256-GCM). KeyID names the master key that sealed the
+// payload (version 1) or wrapped the DEK (versions 2 and 3).
+type envelope struct {
+	Version    byte
+	Algorithm  byte
+	KeyID      string
+	WrappedKey []byte // Versions 2 and 3 only
+	Payload    []byte
+}
+
+// hasWrappedKey reports whether the envelope format carries a wrapped DEK.
+func (e envelope) hasWrappedKey() bool {
+	return e.Version == envelopeVersion2 || e.Version == envelopeVersion3
+}
+
+// wrapAD returns the additional data authenticated when wrapping the DEK: the
+// header through the key ID, followed by the length-prefixed key name. The
+// header includes the version byte, so a version 3 envelope relabelled as an
+// older format fails to open. Returns nil for formats that predate additional
+// data; WithStrictEnvelopes refuses those altogether.
+func (e envelope) wrapAD(name string) []byte {
+	if e.Version < envelopeVersion3 {
+		return nil
+	}
+	ad := make([]byte, 0, len(envelopeMagic)+3+len(e.KeyID)+4+len(name))
+	ad = append(ad, envelopeMagic...)
+	ad = append(ad, e.Version, e.Algorithm, byte(len(e.KeyID)))
+	ad = append(ad, e.KeyID...)
+	return appendName(ad, name)
+}
+
+// payloadAD returns the additional data authenticated with the payload. It omits
+// the key ID so that rotation can re-wrap the DEK without touching the payload;
+// the key ID is still bound through the DEK wrap. Returns nil for formats that
+// predate additional data.
+func (e envelope) payloadAD(name string) []byte {
+	if e.Version < envelopeVersion3 {
+		return nil
+	}
+	ad := make([]byte, 0, len(envelopeMagic)+2+4+len(name))
+	ad = append(ad, envelopeMagic...)
+	ad = append(ad, e.Version, e.Algorithm)
+	return appendName(ad, name)
+}
+
+// appendName appends a length-prefixed key name so that distinct names can never
+// produce the same additional data.
+func appendName(ad []byte, name string) []byte {
+	ad = binary.BigEndian.AppendUint32(ad, uint32(len(name)))
+	return append(ad, name...)
+}
+
+// marshal encodes the envelope into its wire layout.
+func (e envelope) marshal() ([]byte, error) {
+	if len(e.KeyID) == 0 || len(e.KeyID) > 255 {
+		return nil, fmt.Errorf("invalid key ID length: %d", len(e.KeyID))
+	}
+	if e.hasWrappedKey() && (len(e.WrappedKey) == 0 || len(e.WrappedKey) > 0xFFFF) {
+		return nil, fmt.Errorf("invalid wrapped key length: %d", len(e.WrappedKey))
+	}
+
+	out := make([]byte, 0, len(envelopeMagic)+5+len(e.KeyID)+len(e.WrappedKey)+len(e.Payload))
+	out = append(out, envelopeMagic...)
+	out = append(out, e.Version, e.Algorithm, byte(len(e.KeyID)))
+	out = append(out, e.KeyID...)
+	if e.hasWrappedKey() {
+		out = binary.BigEndian.AppendUint16(out, uint16(len(e.WrappedKey)))
+		out = append(out, e.WrappedKey...)
+	}
+	out = append(out, e.Payload...)
+	return out, nil
+}
+
+// isEnvelope reports whether data carries the versioned envelope header.
+// Entries written before envelopes existed are bare nonce || ciphertext || tag.
+func isEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, envelopeMagic)
+}
+
+// parseEnvelope decodes the wire layout produced by marshal.
+func parseEnvelope(data []byte) (envelope, error) {
+	if !isEnvelope(data) {
+		return envelope{}, fmt.Errorf("missing envelope header")
+	}
+	rest := data[len(envelopeMagic):]
+	if len(rest) < 3 {
+		return envelope{}, fmt.Errorf("envelope header truncated")
+	}
+
+	e := envelope{Version: rest[0], Algorithm: rest[1]}
+	idLen := int(rest[2])
+	rest = rest[3:]
+
+	if e.Version != envelopeVersion1 && !e.hasWrappedKey() {
+		return envelope{}, fmt.Errorf("unsupported envelope version: %d", e.Version)
+	}
+	if e.Algorithm != algAES256GCM {
+		return envelope{}, fmt.Errorf("unsupported algorithm: %d", e.Algorithm)
+	}
+	if idLen == 0 || len(rest) < idLen {
+		return envelope{}, fmt.Errorf("envelope key ID truncated")
+	}
+	e.KeyID = string(rest[:idLen])
+	rest = rest[idLen:]
+
+	if e.hasWrappedKey() {
+		if len(rest) < 2 {
+			return envelope{}, fmt.Errorf("envelope wrapped key truncated")
+		}
+		wrappedLen := int(binary.BigEndian.Uint16(rest))
+		rest = rest[2:]
+		if wrappedLen == 0 || len(rest) < wrappedLen {
+			return envelope{}, fmt.Errorf("envelope wrapped key truncated")
+		}
+		e.WrappedKey = rest[:wrappedLen]
+		rest = rest[wrappedLen:]
+	}
+
+	e.Payload = rest
+	return e, nil
+}
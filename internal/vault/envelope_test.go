@@ -0,0 +1,379 @@
+package vault
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	env := envelope{
+		Version:   envelopeVersion1,
+		Algorithm: algAES256GCM,
+		KeyID:     "0123456789abcdef",
+		Payload:   []byte("payload"),
+	}
+
+	data, err := env.marshal()
+	require.NoError(t, err)
+	require.True(t, isEnvelope(data))
+
+	parsed, err := parseEnvelope(data)
+	require.NoError(t, err)
+	require.Equal(t, env, parsed)
+}
+
+func TestEnvelope_WrappedKeyRoundTrip(t *testing.T) {
+	env := envelope{
+		Version:    envelopeVersion2,
+		Algorithm:  algAES256GCM,
+		KeyID:      "0123456789abcdef",
+		WrappedKey: []byte("wrapped-dek"),
+		Payload:    []byte("payload"),
+	}
+
+	data, err := env.marshal()
+	require.NoError(t, err)
+
+	parsed, err := parseEnvelope(data)
+	require.NoError(t, err)
+	require.Equal(t, env, parsed)
+
+	_, err = envelope{Version: envelopeVersion2, Algorithm: algAES256GCM, KeyID: "id"}.marshal()
+	require.ErrorContains(t, err, "invalid wrapped key length")
+}
+
+func TestEnvelope_ParseErrors(t *testing.T) {
+	cases := map[string]struct {
+		input   []byte
+		wantErr string
+	}{
+		"no header":           {input: []byte("raw-nonce-and-ciphertext"), wantErr: "missing envelope header"},
+		"truncated header":    {input: []byte("CMV\x01"), wantErr: "header truncated"},
+		"unknown version":     {input: []byte("CMV\x09\x01\x01a"), wantErr: "unsupported envelope version"},
+		"unknown algorithm":   {input: []byte("CMV\x01\x09\x01a"), wantErr: "unsupported algorithm"},
+		"truncated key ID":    {input: []byte("CMV\x01\x01\x05ab"), wantErr: "key ID truncated"},
+		"empty key ID length": {input: []byte("CMV\x01\x01\x00"), wantErr: "key ID truncated"},
+		"truncated wrap size": {input: []byte("CMV\x02\x01\x01a\x00"), wantErr: "wrapped key truncated"},
+		"truncated wrap":      {input: []byte("CMV\x02\x01\x01a\x00\x05ab"), wantErr: "wrapped key truncated"},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			_, err := parseEnvelope(tc.input)
+			require.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	k := NewKeyring()
+
+	_, _, err := k.Active()
+	require.Error(t, err, "empty keyring has no active key")
+
+	_, err = k.Add([]byte("short"))
+	require.Error(t, err)
+
+	first := make([]byte, 32)
+	second := make([]byte, 32)
+	second[0] = 1
+
+	id1, err := k.Add(first)
+	require.NoError(t, err)
+	require.Equal(t, KeyID(first), id1)
+	id2, err := k.Add(second)
+	require.NoError(t, err)
+	require.NotEqual(t, id1, id2)
+
+	require.Error(t, k.SetActive("missing"))
+	require.NoError(t, k.SetActive(id2))
+
+	activeID, activeKey, err := k.Active()
+	require.NoError(t, err)
+	require.Equal(t, id2, activeID)
+	require.Equal(t, second, activeKey)
+
+	require.Error(t, k.Remove(id2), "active key cannot be removed")
+	require.NoError(t, k.Remove(id1))
+	_, ok := k.Key(id1)
+	require.False(t, ok)
+	require.Equal(t, []string{id2}, k.IDs())
+}
+
+func TestAESGCMStore_LegacyEntriesMigrate(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	var auditEvents []AuditEvent
+	store := NewAESGCMStore(keyProvider, func(e AuditEvent) {
+		auditEvents = append(auditEvents, e)
+	}).(*aesgcmStore)
+
+	key, err := keyProvider.GetKey(ctx)
+	require.NoError(t, err)
+
+	// Write an entry in the pre-envelope format.
+	legacy, err := store.encrypt(key, []byte("legacy-secret"))
+	require.NoError(t, err)
+	putUnsigned(store, "legacy", newSecretEntry(legacy))
+	require.NoError(t, store.Put(ctx, "current", []byte("current-secret")))
+
+	got, err := store.Get(ctx, "legacy")
+	require.NoError(t, err)
+	require.Equal(t, []byte("legacy-secret"), got)
+
+	auditEvents = nil
+	require.NoError(t, store.Migrate(ctx))
+	require.Len(t, auditEvents, 1)
+	require.Equal(t, "migrate", auditEvents[0].Operation)
+	require.Equal(t, "1", auditEvents[0].Metadata["count"], "only the legacy entry needs migration")
+
+	env, err := parseEnvelope(store.secrets["legacy"].current().Data)
+	require.NoError(t, err)
+	require.Equal(t, KeyID(key), env.KeyID)
+
+	got, err = store.Get(ctx, "legacy")
+	require.NoError(t, err)
+	require.Equal(t, []byte("legacy-secret"), got)
+}
+
+func TestAESGCMStore_PerSecretDataKeys(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+	require.NoError(t, store.Put(ctx, "a", []byte("same-value")))
+	require.NoError(t, store.Put(ctx, "b", []byte("same-value")))
+
+	envA, err := parseEnvelope(store.secrets["a"].current().Data)
+	require.NoError(t, err)
+	envB, err := parseEnvelope(store.secrets["b"].current().Data)
+	require.NoError(t, err)
+	require.Equal(t, envelopeVersion3, envA.Version)
+
+	masterKey, err := keyProvider.GetKey(ctx)
+	require.NoError(t, err)
+	dekA, err := store.decryptWithAD(masterKey, envA.WrappedKey, envA.wrapAD("a"))
+	require.NoError(t, err)
+	dekB, err := store.decryptWithAD(masterKey, envB.WrappedKey, envB.wrapAD("b"))
+	require.NoError(t, err)
+	require.NotEqual(t, dekA, dekB, "each secret must get its own data key")
+
+	_, err = store.decryptWithAD(masterKey, envA.Payload, envA.payloadAD("a"))
+	require.Error(t, err, "payload must not be sealed with the master key")
+
+	// Rotation re-wraps the data key but leaves the payload untouched.
+	require.NoError(t, store.Rotate(ctx))
+	rotated, err := parseEnvelope(store.secrets["a"].current().Data)
+	require.NoError(t, err)
+	require.Equal(t, envA.Payload, rotated.Payload)
+	require.NotEqual(t, envA.KeyID, rotated.KeyID)
+
+	got, err := store.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("same-value"), got)
+}
+
+func TestAESGCMStore_DirectEnvelopeMigratesToDataKeys(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+	keyID, err := store.currentKey(ctx)
+	require.NoError(t, err)
+	key, ok := store.keyring.Key(keyID)
+	require.True(t, ok)
+
+	// Write an entry sealed directly with the master key (envelope version 1).
+	payload, err := store.encrypt(key, []byte("direct"))
+	require.NoError(t, err)
+	direct, err := envelope{
+		Version:   envelopeVersion1,
+		Algorithm: algAES256GCM,
+		KeyID:     keyID,
+		Payload:   payload,
+	}.marshal()
+	require.NoError(t, err)
+	putUnsigned(store, "direct", newSecretEntry(direct))
+
+	got, err := store.Get(ctx, "direct")
+	require.NoError(t, err)
+	require.Equal(t, []byte("direct"), got)
+
+	require.NoError(t, store.Migrate(ctx))
+	env, err := parseEnvelope(store.secrets["direct"].current().Data)
+	require.NoError(t, err)
+	require.Equal(t, envelopeVersion3, env.Version)
+
+	got, err = store.Get(ctx, "direct")
+	require.NoError(t, err)
+	require.Equal(t, []byte("direct"), got)
+}
+
+func TestAESGCMStore_CiphertextBoundToKeyName(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+	require.NoError(t, store.Put(ctx, "dropbox-token", []byte("dropbox")))
+	require.NoError(t, store.Put(ctx, "s3-secret", []byte("s3")))
+
+	// An attacker with write access to storage swaps the ciphertexts. The
+	// entry MAC catches that first; without it, the envelope still does.
+	store.secrets["s3-secret"] = store.secrets["dropbox-token"]
+	_, err = store.Get(ctx, "s3-secret")
+	require.ErrorIs(t, err, ErrTampered)
+
+	putUnsigned(store, "s3-secret", store.secrets["dropbox-token"])
+	_, err = store.Get(ctx, "s3-secret")
+	require.ErrorIs(t, err, ErrDecryption)
+
+	// Downgrading the header to a format without additional data is detected.
+	env, err := parseEnvelope(store.secrets["dropbox-token"].current().Data)
+	require.NoError(t, err)
+	env.Version = envelopeVersion2
+	downgraded, err := env.marshal()
+	require.NoError(t, err)
+	putUnsigned(store, "dropbox-token", newSecretEntry(downgraded))
+
+	_, err = store.Get(ctx, "dropbox-token")
+	require.ErrorIs(t, err, ErrDecryption)
+}
+
+func TestAESGCMStore_UnboundEntriesMigrate(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+	keyID, err := store.currentKey(ctx)
+	require.NoError(t, err)
+	key, ok := store.keyring.Key(keyID)
+	require.True(t, ok)
+
+	// Write a version 2 entry, which carries a data key but no additional data.
+	dek, err := newDataKey()
+	require.NoError(t, err)
+	payload, err := store.encrypt(dek, []byte("unbound"))
+	require.NoError(t, err)
+	wrapped, err := store.encrypt(key, dek)
+	require.NoError(t, err)
+	unbound, err := envelope{
+		Version:    envelopeVersion2,
+		Algorithm:  algAES256GCM,
+		KeyID:      keyID,
+		WrappedKey: wrapped,
+		Payload:    payload,
+	}.marshal()
+	require.NoError(t, err)
+	putUnsigned(store, "unbound", newSecretEntry(unbound))
+
+	got, err := store.Get(ctx, "unbound")
+	require.NoError(t, err)
+	require.Equal(t, []byte("unbound"), got)
+
+	require.NoError(t, store.Migrate(ctx))
+	env, err := parseEnvelope(store.secrets["unbound"].current().Data)
+	require.NoError(t, err)
+	require.Equal(t, envelopeVersion3, env.Version)
+
+	got, err = store.Get(ctx, "unbound")
+	require.NoError(t, err)
+	require.Equal(t, []byte("unbound"), got)
+
+	// After migration the entry is signed and bound to its name.
+	store.secrets["moved"] = store.secrets["unbound"]
+	_, err = store.Get(ctx, "moved")
+	require.ErrorIs(t, err, ErrTampered)
+
+	putUnsigned(store, "moved", store.secrets["unbound"])
+	_, err = store.Get(ctx, "moved")
+	require.ErrorIs(t, err, ErrDecryption)
+}
+
+// putUnsigned stores a copy of e under key without its MAC, as loaded from a
+// store file older than version 3.
+func putUnsigned(s *aesgcmStore, key string, e *secretEntry) {
+	c := *e
+	c.MACKey, c.MAC = "", nil
+	s.secrets[key] = &c
+	s.legacyEntries.Store(true)
+}
+
+func TestFileStore_ReadsEntriesSealedBeforeProviderRotation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	keyProvider, err := NewFileKeyProvider(filepath.Join(dir, "master.key"))
+	require.NoError(t, err)
+
+	storePath := filepath.Join(dir, "secrets.json")
+	store, err := NewFileStore(storePath, keyProvider, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "key", []byte("value")))
+
+	// Rotate behind the store's back, then restart with a fresh keyring.
+	_, _, err = keyProvider.RotateKey(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Close())
+	reopened, err := NewFileStore(storePath, keyProvider, nil)
+	require.NoError(t, err)
+
+	got, err := reopened.Get(ctx, "key")
+	require.NoError(t, err, "retired key from the provider should open the entry")
+	require.Equal(t, []byte("value"), got)
+}
+
+func TestAESGCMStore_StrictEnvelopes(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	lenient := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+	keyID, err := lenient.currentKey(ctx)
+	require.NoError(t, err)
+	key, ok := lenient.keyring.Key(keyID)
+	require.True(t, ok)
+
+	// Genuine ciphertexts in older formats, as an attacker might have kept
+	// from a backup taken before migration.
+	legacy, err := lenient.encrypt(key, []byte("legacy"))
+	require.NoError(t, err)
+	payload, err := lenient.encrypt(key, []byte("direct"))
+	require.NoError(t, err)
+	direct, err := envelope{Version: envelopeVersion1, Algorithm: algAES256GCM, KeyID: keyID, Payload: payload}.marshal()
+	require.NoError(t, err)
+
+	store := NewAESGCMStore(keyProvider, nil, WithStrictEnvelopes()).(*aesgcmStore)
+	require.NoError(t, store.Put(ctx, "current", []byte("current")))
+	got, err := store.Get(ctx, "current")
+	require.NoError(t, err)
+	require.Equal(t, []byte("current"), got)
+
+	for name, data := range map[string][]byte{"legacy": legacy, "direct": direct} {
+		// Unsigned entries are refused before their envelope is looked at.
+		store.secrets[name] = newSecretEntry(data)
+		_, err := store.Get(ctx, name)
+		require.ErrorIs(t, err, ErrTampered, name)
+		require.ErrorContains(t, err, "strict mode", name)
+		require.ErrorIs(t, store.Migrate(ctx), ErrTampered, "legacy entries must be migrated before strict mode")
+
+		entry := newSecretEntry(data)
+		require.NoError(t, store.signEntry(name, entry, keyID))
+		store.secrets[name] = entry
+		_, err = store.Get(ctx, name)
+		require.ErrorIs(t, err, ErrDecryption, name)
+		require.ErrorContains(t, err, "strict mode", name)
+		require.ErrorIs(t, store.Migrate(ctx), ErrDecryption, "legacy entries must be migrated before strict mode")
+		delete(store.secrets, name)
+	}
+}
@@ -0,0 +1,187 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ListExpiring returns the secrets whose expiry or rotate-by date is within
+// the given window from now, including those already past it, soonest first.
+func (s *aesgcmStore) ListExpiring(ctx context.Context, within time.Duration) ([]SecretInfo, error) {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "list_expiring",
+		Metadata:  map[string]string{"within": within.String()},
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	if err := s.checkUnsealed(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	}
+
+	if err := s.loadKeys(ctx); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	}
+
+	deadline := start.Add(within)
+	s.mu.RLock()
+	var infos []SecretInfo
+	for key, entry := range s.secrets {
+		if err := s.verifyEntry(key, entry); err != nil {
+			s.mu.RUnlock()
+			event.Success = false
+			event.Error = err.Error()
+			return nil, err
+		}
+		if due := entry.Metadata.nextDeadline(); due.IsZero() || due.After(deadline) {
+			continue
+		}
+		infos = append(infos, SecretInfo{
+			Key:      key,
+			Version:  entry.current().Number,
+			Revision: entry.Revision,
+			Metadata: entry.Metadata.clone(),
+		})
+	}
+	s.mu.RUnlock()
+
+	allowed := s.permits(ctx, "list")
+	visible := infos[:0]
+	for _, info := range infos {
+		if allowed(info.Key) {
+			visible = append(visible, info)
+		}
+	}
+	infos = visible
+
+	sort.Slice(infos, func(i, j int) bool {
+		di, dj := infos[i].Metadata.nextDeadline(), infos[j].Metadata.nextDeadline()
+		if !di.Equal(dj) {
+			return di.Before(dj)
+		}
+		return infos[i].Key < infos[j].Key
+	})
+	event.Success = true
+	event.Metadata["count"] = fmt.Sprintf("%d", len(infos))
+	return infos, nil
+}
+
+// PurgeExpired deletes every expired secret with all of its versions and
+// returns the purged keys in alphabetical order. Each purge is audited as an
+// "expired" event. If any entry fails its MAC check nothing is purged, so a
+// forged expiry cannot be used to delete a secret.
+func (s *aesgcmStore) PurgeExpired(ctx context.Context) ([]string, error) {
+	// Housekeeping does not count as use, so a running sweeper never holds
+	// off auto-seal.
+	if s.Sealed() {
+		return nil, ErrSealed
+	}
+	if err := s.loadKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	purged := make(map[string]*secretEntry)
+	deletes := make(map[string]*secretEntry)
+	for key, entry := range s.secrets {
+		// An expiry nobody can vouch for must not delete a secret.
+		if err := s.verifyEntry(key, entry); err != nil {
+			s.mu.Unlock()
+			s.audit(ctx, AuditEvent{
+				Timestamp: now,
+				Operation: "expired",
+				Key:       key,
+				Success:   false,
+				Error:     err.Error(),
+			})
+			return nil, err
+		}
+		if entry.Metadata.expired(now) {
+			purged[key] = entry
+			deletes[key] = nil
+		}
+	}
+	if len(purged) == 0 {
+		s.mu.Unlock()
+		return nil, nil
+	}
+	if _, err := s.commitAsLocked(deletes, EventExpire); err != nil {
+		s.mu.Unlock()
+		s.audit(ctx, AuditEvent{
+			Timestamp: now,
+			Operation: "expired",
+			Success:   false,
+			Error:     fmt.Sprintf("%v: %v", ErrPersistence, err),
+			Metadata:  map[string]string{"count": fmt.Sprintf("%d", len(purged))},
+		})
+		return nil, fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+	s.mu.Unlock()
+
+	keys := make([]string, 0, len(purged))
+	for key := range purged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s.audit(ctx, AuditEvent{
+			Timestamp: now,
+			Operation: "expired",
+			Key:       key,
+			Success:   true,
+			Metadata: map[string]string{
+				"expires_at": purged[key].Metadata.ExpiresAt.Format(time.RFC3339),
+				"versions":   fmt.Sprintf("%d", len(purged[key].Versions)),
+			},
+		})
+	}
+	return keys, nil
+}
+
+// RunExpirySweeper calls PurgeExpired on store every interval until ctx is
+// done. Passes made while the store is sealed do nothing, and persistence
+// failures are reported through the audit hook, so the sweeper simply tries
+// again on the next tick. Purges are attributed to the scheduler unless ctx
+// names another component. Run it in its own goroutine.
+func RunExpirySweeper(ctx context.Context, store ExpiringStore, interval time.Duration) {
+	if RequestInfoFromContext(ctx).Component == "" {
+		ctx = WithComponent(ctx, ComponentScheduler)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = store.PurgeExpired(ctx)
+		}
+	}
+}
+
+// expired reports whether the secret's value has expired at now.
+func (m SecretMetadata) expired(now time.Time) bool {
+	return !m.ExpiresAt.IsZero() && !now.Before(m.ExpiresAt)
+}
+
+// nextDeadline returns the earlier of the expiry and rotate-by dates, or the
+// zero time if neither is set.
+func (m SecretMetadata) nextDeadline() time.Time {
+	switch {
+	case m.ExpiresAt.IsZero():
+		return m.RotateBy
+	case m.RotateBy.IsZero() || m.ExpiresAt.Before(m.RotateBy):
+		return m.ExpiresAt
+	default:
+		return m.RotateBy
+	}
+}
@@ -0,0 +1,227 @@
+package vault
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAESGCMStore_Expiry(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+
+	past := time.Now().Add(-time.Minute)
+	require.NoError(t, store.PutWithMetadata(ctx, "session", []byte("token"), SecretMetadata{
+		Type:      SecretTypeOAuthToken,
+		ExpiresAt: past,
+	}))
+	require.NoError(t, store.PutWithMetadata(ctx, "fresh", []byte("token"), SecretMetadata{
+		ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	_, err = store.Get(ctx, "session")
+	require.ErrorIs(t, err, ErrExpired)
+	_, err = store.GetVersion(ctx, "session", 1)
+	require.ErrorIs(t, err, ErrExpired)
+	require.ErrorIs(t, store.Rollback(ctx, "session", 1), ErrExpired)
+
+	got, err := store.Get(ctx, "fresh")
+	require.NoError(t, err)
+	require.Equal(t, []byte("token"), got)
+
+	t.Run("plain put clears the expiry", func(t *testing.T) {
+		require.NoError(t, store.Put(ctx, "session", []byte("refreshed")))
+		got, err := store.Get(ctx, "session")
+		require.NoError(t, err)
+		require.Equal(t, []byte("refreshed"), got)
+
+		meta, err := store.GetMetadata(ctx, "session")
+		require.NoError(t, err)
+		require.True(t, meta.ExpiresAt.IsZero())
+		require.Equal(t, SecretTypeOAuthToken, meta.Type, "other metadata is kept")
+	})
+
+	t.Run("rollback clears the expiry like a put", func(t *testing.T) {
+		require.NoError(t, store.PutWithMetadata(ctx, "fresh", []byte("rotated"), SecretMetadata{
+			ExpiresAt: time.Now().Add(time.Hour),
+		}))
+		require.NoError(t, store.Rollback(ctx, "fresh", 1))
+		got, err := store.Get(ctx, "fresh")
+		require.NoError(t, err)
+		require.Equal(t, []byte("token"), got)
+
+		meta, err := store.GetMetadata(ctx, "fresh")
+		require.NoError(t, err)
+		require.True(t, meta.ExpiresAt.IsZero())
+	})
+}
+
+func TestAESGCMStore_ListExpiring(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+
+	now := time.Now()
+	secrets := map[string]SecretMetadata{
+		"expired":     {ExpiresAt: now.Add(-time.Hour)},
+		"soon":        {ExpiresAt: now.Add(time.Hour)},
+		"rotate-soon": {RotateBy: now.Add(30 * time.Minute), ExpiresAt: now.Add(48 * time.Hour)},
+		"later":       {ExpiresAt: now.Add(48 * time.Hour)},
+		"never":       {},
+	}
+	for key, meta := range secrets {
+		require.NoError(t, store.PutWithMetadata(ctx, key, []byte("value"), meta))
+	}
+
+	infos, err := store.ListExpiring(ctx, 2*time.Hour)
+	require.NoError(t, err)
+	keys := make([]string, len(infos))
+	for i, info := range infos {
+		keys[i] = info.Key
+	}
+	require.Equal(t, []string{"expired", "rotate-soon", "soon"}, keys, "soonest deadline first")
+
+	infos, err = store.ListExpiring(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, "expired", infos[0].Key)
+}
+
+func TestAESGCMStore_PurgeExpired(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var expiredEvents []AuditEvent
+	store := NewAESGCMStore(keyProvider, func(e AuditEvent) {
+		if e.Operation == "expired" {
+			mu.Lock()
+			expiredEvents = append(expiredEvents, e)
+			mu.Unlock()
+		}
+	}).(*aesgcmStore)
+
+	require.NoError(t, store.PutWithMetadata(ctx, "b-old", []byte("value"), SecretMetadata{ExpiresAt: time.Now().Add(-time.Second)}))
+	require.NoError(t, store.PutWithMetadata(ctx, "a-old", []byte("value"), SecretMetadata{ExpiresAt: time.Now().Add(-time.Second)}))
+	require.NoError(t, store.PutWithMetadata(ctx, "live", []byte("value"), SecretMetadata{ExpiresAt: time.Now().Add(time.Hour)}))
+
+	purged, err := store.PurgeExpired(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a-old", "b-old"}, purged)
+
+	keys, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"live"}, keys)
+
+	require.Len(t, expiredEvents, 2)
+	require.Equal(t, "a-old", expiredEvents[0].Key)
+	require.True(t, expiredEvents[0].Success)
+	require.Equal(t, "1", expiredEvents[0].Metadata["versions"])
+
+	t.Run("sealed store is left alone", func(t *testing.T) {
+		require.NoError(t, store.PutWithMetadata(ctx, "c-old", []byte("value"), SecretMetadata{ExpiresAt: time.Now().Add(-time.Second)}))
+		require.NoError(t, store.Seal(ctx))
+		_, err := store.PurgeExpired(ctx)
+		require.ErrorIs(t, err, ErrSealed)
+		require.NoError(t, store.Unseal(ctx))
+	})
+
+	t.Run("sweeper purges in the background", func(t *testing.T) {
+		sweepCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			RunExpirySweeper(sweepCtx, store, 10*time.Millisecond)
+			close(done)
+		}()
+
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(expiredEvents) == 3 && expiredEvents[2].Key == "c-old"
+		}, 5*time.Second, 10*time.Millisecond)
+
+		cancel()
+		<-done
+	})
+}
+
+func TestFileStore_DetectsExpiryTampering(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	store, err := internalStore(NewFileStore(path, keyProvider, nil))
+	require.NoError(t, err)
+	require.NoError(t, store.PutWithMetadata(ctx, "expired", []byte("value"), SecretMetadata{ExpiresAt: time.Now().Add(-time.Second)}))
+	require.NoError(t, store.PutWithMetadata(ctx, "live", []byte("value"), SecretMetadata{ExpiresAt: time.Now().Add(time.Hour)}))
+	require.NoError(t, store.Close())
+
+	t.Run("extending an expiry does not revive the value", func(t *testing.T) {
+		tamperStoreFile(t, path, storeFileVersion, func(secrets map[string]*secretEntry) {
+			secrets["expired"].Metadata.ExpiresAt = time.Now().Add(time.Hour)
+		})
+		reopened, err := internalStore(NewFileStore(path, keyProvider, nil))
+		require.NoError(t, err)
+		defer reopened.Close()
+
+		_, err = reopened.Get(ctx, "expired")
+		require.ErrorIs(t, err, ErrTampered)
+		_, err = reopened.ListExpiring(ctx, 2*time.Hour)
+		require.ErrorIs(t, err, ErrTampered)
+	})
+
+	t.Run("stripping the MAC does not revive the value", func(t *testing.T) {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		copied := filepath.Join(t.TempDir(), "secrets.json")
+		require.NoError(t, os.WriteFile(copied, data, 0o600))
+		revive := func(secrets map[string]*secretEntry) {
+			secrets["expired"].Metadata.ExpiresAt = time.Time{}
+			secrets["expired"].MACKey, secrets["expired"].MAC = "", nil
+		}
+
+		tamperStoreFile(t, copied, storeFileVersion2, revive)
+		strict, err := NewFileStore(copied, keyProvider, nil, WithStrictEnvelopes())
+		require.NoError(t, err)
+		_, err = strict.Get(ctx, "expired")
+		require.ErrorIs(t, err, ErrTampered)
+		require.NoError(t, strict.Close())
+
+		tamperStoreFile(t, copied, storeFileVersion, revive)
+		_, err = NewFileStore(copied, keyProvider, nil)
+		require.ErrorIs(t, err, ErrTampered)
+	})
+
+	t.Run("forging an expiry does not purge the secret", func(t *testing.T) {
+		tamperStoreFile(t, path, storeFileVersion, func(secrets map[string]*secretEntry) {
+			secrets["live"].Metadata.ExpiresAt = time.Now().Add(-time.Second)
+		})
+		var failed []AuditEvent
+		reopened, err := internalStore(NewFileStore(path, keyProvider, func(e AuditEvent) {
+			if e.Operation == "expired" && !e.Success {
+				failed = append(failed, e)
+			}
+		}))
+		require.NoError(t, err)
+		defer reopened.Close()
+
+		purged, err := reopened.PurgeExpired(ctx)
+		require.ErrorIs(t, err, ErrTampered)
+		require.Empty(t, purged)
+		require.NotEmpty(t, failed)
+
+		secrets, _, _, err := loadStoreFile(path)
+		require.NoError(t, err)
+		require.Contains(t, secrets, "live")
+	})
+}
@@ -0,0 +1,25 @@
+package vault
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockSuffix names the sidecar file a FileStore locks for as long as it is open.
+const lockSuffix = ".lock"
+
+// lockStoreFile opens and exclusively locks the sidecar lock file for the
+// store at path. It returns ErrStoreLocked if another store, in this process
+// or another, already holds it. The lock is released when the returned file
+// is closed.
+func lockStoreFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path+lockSuffix, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store lock file: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
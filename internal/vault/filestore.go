@@ -0,0 +1,192 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Store file format versions. storeFileVersion is the one written today.
+// Version 1 files held a single envelope per key and are upgraded on load.
+// Version 2 entries carry no MAC; from version 3 on, every entry must.
+const (
+	storeFileVersion1 = 1
+	storeFileVersion2 = 2
+	storeFileVersion  = 3
+)
+
+// storeFile is the on-disk representation of a persisted vault.
+// Secret values are stored as AES-GCM ciphertexts; key names, secret metadata
+// and version metadata are in clear but covered by each entry's MAC.
+type storeFile struct {
+	Version  int                     `json:"version"`
+	Revision int64                   `json:"revision"`
+	Secrets  map[string]*secretEntry `json:"secrets"`
+}
+
+// storeFileV1 is the version 1 layout, which kept only the latest value.
+type storeFileV1 struct {
+	Version int               `json:"version"`
+	Secrets map[string][]byte `json:"secrets"`
+}
+
+// NewFileStore creates a Store that persists encrypted secrets to a single file
+// at path. Existing contents are loaded on startup. Every mutation rewrites the
+// file atomically (temp file, fsync, rename) so a crash mid-write leaves the
+// previous state intact.
+//
+// The store holds an exclusive lock on path+".lock" until Close, so a second
+// store on the same file, in this process or another, fails with
+// ErrStoreLocked instead of overwriting the first one's writes.
+func NewFileStore(path string, keyProvider KeyProvider, auditHook AuditHook, opts ...StoreOption) (Store, error) {
+	if path == "" {
+		return nil, fmt.Errorf("vault: store path cannot be empty")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	lock, err := lockStoreFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Discard writes that never reached their rename. Holding the lock means
+	// no other store can be midway through one.
+	if err := removeStaleTempFiles(path); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	secrets, revision, legacy, err := loadStoreFile(path)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	s := NewAESGCMStore(keyProvider, auditHook, opts...).(*aesgcmStore)
+	s.path = path
+	s.lock = lock
+	s.secrets = secrets
+	s.revision = revision
+	s.legacyEntries.Store(legacy && len(secrets) > 0)
+	return s, nil
+}
+
+// Close releases the store file's lock. Later writes fail; memory-only stores
+// have nothing to release.
+func (s *aesgcmStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lock == nil {
+		return nil
+	}
+	err := s.lock.Close()
+	s.lock = nil
+	s.closed = true
+	if err != nil {
+		return fmt.Errorf("failed to release store lock: %w", err)
+	}
+	return nil
+}
+
+// loadStoreFile reads a persisted vault and its revision, returning an empty
+// map if none exists. legacy reports a file older than version 3, whose
+// entries are not signed.
+func loadStoreFile(path string) (secrets map[string]*secretEntry, revision int64, legacy bool, err error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return make(map[string]*secretEntry), 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to read store file: %w", err)
+	}
+
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, 0, false, fmt.Errorf("failed to parse store file: %w", err)
+	}
+
+	var f storeFile
+	switch header.Version {
+	case storeFileVersion1:
+		var v1 storeFileV1
+		if err := json.Unmarshal(data, &v1); err != nil {
+			return nil, 0, false, fmt.Errorf("failed to parse store file: %w", err)
+		}
+		// Each existing value becomes version 1; its creation time is unknown.
+		f.Secrets = make(map[string]*secretEntry, len(v1.Secrets))
+		for key, encrypted := range v1.Secrets {
+			f.Secrets[key] = newSecretEntry(encrypted)
+		}
+
+	case storeFileVersion2, storeFileVersion:
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, 0, false, fmt.Errorf("failed to parse store file: %w", err)
+		}
+		if f.Secrets == nil {
+			f.Secrets = make(map[string]*secretEntry)
+		}
+		for key, entry := range f.Secrets {
+			if entry == nil || len(entry.Versions) == 0 {
+				return nil, 0, false, fmt.Errorf("failed to parse store file: entry %q has no versions", key)
+			}
+			if header.Version == storeFileVersion && len(entry.MAC) == 0 {
+				return nil, 0, false, fmt.Errorf("%w: entry %q is not signed", ErrTampered, key)
+			}
+		}
+
+	default:
+		return nil, 0, false, fmt.Errorf("unsupported store file version: %d", header.Version)
+	}
+
+	// Entries written before revisions existed start at revision 1, so that
+	// revision 0 keeps meaning "does not exist".
+	for _, entry := range f.Secrets {
+		if entry.Revision == 0 {
+			if f.Revision == 0 {
+				f.Revision = 1
+			}
+			entry.Revision = f.Revision
+		}
+	}
+	return f.Secrets, f.Revision, header.Version < storeFileVersion, nil
+}
+
+// persistLocked writes the current secrets to disk. Every entry must be signed
+// by then, which ends the grace period for legacy entries. Callers must hold
+// s.mu. Stores created without a path are memory-only and skip persistence.
+func (s *aesgcmStore) persistLocked() error {
+	for key, entry := range s.secrets {
+		if len(entry.MAC) == 0 {
+			return fmt.Errorf("vault: entry %q is not signed", key)
+		}
+	}
+	if s.path == "" {
+		s.legacyEntries.Store(false)
+		return nil
+	}
+	if s.closed {
+		return fmt.Errorf("vault: store is closed")
+	}
+
+	data, err := json.Marshal(storeFile{
+		Version:  storeFileVersion,
+		Revision: s.revision,
+		Secrets:  s.secrets,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode store file: %w", err)
+	}
+
+	if err := writeFileAtomic(s.path, data, 0600); err != nil {
+		return err
+	}
+	s.legacyEntries.Store(false)
+	return nil
+}
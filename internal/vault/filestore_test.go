@@ -0,0 +1,132 @@
+package vault
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore_PersistsAcrossRestarts(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "vault", "secrets.json")
+
+	store, err := NewFileStore(path, keyProvider, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "s3-secret", []byte("hunter2")))
+	require.NoError(t, store.Put(ctx, "dropbox-token", []byte("token")))
+	require.NoError(t, store.Delete(ctx, "dropbox-token"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "hunter2", "values must be encrypted at rest")
+
+	require.NoError(t, store.Close())
+	reopened, err := NewFileStore(path, keyProvider, nil)
+	require.NoError(t, err)
+
+	keys, err := reopened.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"s3-secret"}, keys)
+
+	value, err := reopened.Get(ctx, "s3-secret")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hunter2"), value)
+}
+
+func TestFileStore_RecoversFromInterruptedWrite(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "secrets.json")
+
+	store, err := NewFileStore(path, keyProvider, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "key", []byte("committed")))
+
+	// Simulate a crash after the temp file was written but before rename.
+	stale := path + tempSuffix + "12345"
+	require.NoError(t, os.WriteFile(stale, []byte("{truncated"), 0600))
+
+	require.NoError(t, store.Close())
+	reopened, err := NewFileStore(path, keyProvider, nil)
+	require.NoError(t, err)
+
+	value, err := reopened.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("committed"), value)
+
+	_, err = os.Stat(stale)
+	require.True(t, os.IsNotExist(err), "stale temp file should be removed")
+}
+
+func TestFileStore_PersistFailureRollsBack(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	store, err := NewFileStore(path, keyProvider, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "key", []byte("v1")))
+
+	// Point the store at a directory that no longer exists so writes fail.
+	store.(*aesgcmStore).path = filepath.Join(t.TempDir(), "missing", "secrets.json")
+
+	err = store.Put(ctx, "key", []byte("v2"))
+	require.ErrorIs(t, err, ErrPersistence)
+
+	value, err := store.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("v1"), value, "failed write must not change in-memory state")
+}
+
+func TestFileStore_InvalidFile(t *testing.T) {
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":99}`), 0600))
+
+	_, err = NewFileStore(path, keyProvider, nil)
+	require.ErrorContains(t, err, "unsupported store file version")
+
+	_, err = NewFileStore("", keyProvider, nil)
+	require.Error(t, err)
+}
+
+func TestFileStore_Lock(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	store, err := NewFileStore(path, keyProvider, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "key", []byte("v1")))
+
+	// A write in flight in the first store survives a second open attempt.
+	inFlight := path + tempSuffix + "67890"
+	require.NoError(t, os.WriteFile(inFlight, []byte("{partial"), 0600))
+	_, err = NewFileStore(path, keyProvider, nil)
+	require.ErrorIs(t, err, ErrStoreLocked)
+	_, err = os.Stat(inFlight)
+	require.NoError(t, err, "temp files belong to the lock holder")
+
+	require.NoError(t, store.Close())
+	require.NoError(t, store.Close())
+	require.Error(t, store.Put(ctx, "key", []byte("v2")), "a closed store no longer owns the file")
+
+	reopened, err := NewFileStore(path, keyProvider, nil)
+	require.NoError(t, err)
+	defer reopened.Close()
+	value, err := reopened.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("v1"), value)
+}
@@ -0,0 +1,66 @@
+package vault
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// defaultRemoteTimeout bounds each request made by remote key providers.
+const defaultRemoteTimeout = 30 * time.Second
+
+// ClientTLSConfig configures TLS for key providers that talk to a remote
+// key management service.
+type ClientTLSConfig struct {
+	// CACertFile is a PEM bundle used instead of the system roots to verify the server.
+	CACertFile string
+
+	// ClientCertFile and ClientKeyFile enable mutual TLS when both are set.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// ServerName overrides the host name checked against the server certificate.
+	ServerName string
+
+	// InsecureSkipVerify disables server certificate verification. Testing only.
+	InsecureSkipVerify bool
+}
+
+// newHTTPClient builds an HTTP client for a remote key provider.
+func newHTTPClient(cfg ClientTLSConfig, timeout time.Duration) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CACertFile != "" {
+		pem, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCertFile != "" || cfg.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if timeout <= 0 {
+		timeout = defaultRemoteTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
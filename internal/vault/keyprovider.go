@@ -6,6 +6,10 @@ import (
 
 // KeyProvider abstracts master key retrieval to support multiple backends
 // (filesystem, OS keychain, external secret stores).
+//
+// Keys returned by a provider belong to the caller, which wipes them once it
+// has copied them into locked memory, so a provider must not return a slice it
+// keeps using itself.
 type KeyProvider interface {
 	// GetKey retrieves the current master key for encryption/decryption.
 	// Must return a 32-byte AES-256 key.
@@ -18,3 +22,17 @@ type KeyProvider interface {
 	// HealthCheck verifies the key provider is accessible.
 	HealthCheck(ctx context.Context) error
 }
+
+// KeyRestorer is implemented by providers that can reinstate a previous master key.
+// Stores use it to roll back a rotation when re-encryption cannot be committed.
+type KeyRestorer interface {
+	// RestoreKey makes key the current master key again.
+	RestoreKey(ctx context.Context, key []byte) error
+}
+
+// RetiredKeyProvider is implemented by providers that retain master keys replaced
+// by rotation. Stores add them to their keyring so older entries stay readable.
+type RetiredKeyProvider interface {
+	// RetiredKeys returns previously active master keys, newest first.
+	RetiredKeys(ctx context.Context) ([][]byte, error)
+}
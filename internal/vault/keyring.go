@@ -0,0 +1,144 @@
+package vault
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Keyring holds several master keys indexed by key ID, one of which is active.
+// New entries are sealed with the active key; entries sealed under any other key
+// in the ring remain readable until they are migrated. Keys are kept in locked,
+// guarded memory and wiped when removed.
+type Keyring struct {
+	mu     sync.RWMutex
+	keys   map[string]*lockedBuffer
+	active string
+}
+
+// NewKeyring creates an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*lockedBuffer)}
+}
+
+// KeyID returns the stable identifier for a master key: the first 8 bytes of
+// its SHA-256 digest, hex encoded. The ID reveals nothing useful about the key.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Add stores a copy of key in the ring and returns its ID.
+// Adding a key that is already present is a no-op.
+func (k *Keyring) Add(key []byte) (string, error) {
+	if len(key) != 32 {
+		return "", fmt.Errorf("key must be 32 bytes for AES-256, got %d", len(key))
+	}
+	id := KeyID(key)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, exists := k.keys[id]; !exists {
+		buf, err := newLockedBufferFrom(key)
+		if err != nil {
+			return "", err
+		}
+		k.keys[id] = buf
+	}
+	return id, nil
+}
+
+// SetActive selects the key used for new encryptions.
+func (k *Keyring) SetActive(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, exists := k.keys[id]; !exists {
+		return fmt.Errorf("unknown key ID %q", id)
+	}
+	k.active = id
+	return nil
+}
+
+// Active returns the ID of the key used for new encryptions and a copy of the
+// key, which the caller should wipe when done with it.
+func (k *Keyring) Active() (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.active == "" {
+		return "", nil, fmt.Errorf("keyring has no active key")
+	}
+	return k.active, k.keys[k.active].copy(), nil
+}
+
+// ActiveID returns the ID of the key used for new encryptions.
+func (k *Keyring) ActiveID() (string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.active == "" {
+		return "", fmt.Errorf("keyring has no active key")
+	}
+	return k.active, nil
+}
+
+// Key returns a copy of the key with the given ID, which the caller should
+// wipe when done with it.
+func (k *Keyring) Key(id string) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, false
+	}
+	return key.copy(), true
+}
+
+// use calls fn with the key with the given ID without copying it out of
+// locked memory. The key cannot be wiped while fn runs; fn must not retain it.
+func (k *Keyring) use(id string, fn func(key []byte) error) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return fmt.Errorf("unknown key ID %q", id)
+	}
+	return fn(key.bytes())
+}
+
+// IDs returns the IDs of all keys in the ring in sorted order.
+func (k *Keyring) IDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Remove drops a retired key from the ring. The active key cannot be removed.
+func (k *Keyring) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.active {
+		return fmt.Errorf("cannot remove active key %q", id)
+	}
+	if key, exists := k.keys[id]; exists {
+		key.destroy()
+		delete(k.keys, id)
+	}
+	return nil
+}
+
+// Wipe zeroes and removes every key, leaving the ring empty with no active key.
+func (k *Keyring) Wipe() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for id, key := range k.keys {
+		key.destroy()
+		delete(k.keys, id)
+	}
+	k.active = ""
+}
@@ -0,0 +1,301 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SecretType says what kind of credential a secret holds.
+type SecretType string
+
+// Supported secret types. An empty type marks an untyped secret.
+const (
+	SecretTypePassword      SecretType = "password"
+	SecretTypeOAuthToken    SecretType = "oauth-token"
+	SecretTypeAccessKeyPair SecretType = "access-key-pair"
+	SecretTypeSSHKey        SecretType = "ssh-key"
+	SecretTypeCertificate   SecretType = "certificate"
+)
+
+// SecretMetadata describes a secret so callers can show what it is for without
+// decrypting it. Metadata is stored in clear next to the ciphertext and must
+// never contain secret material; the entry MAC makes edits to it on disk
+// detectable.
+type SecretMetadata struct {
+	Type      SecretType        `json:"type,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Owner     string            `json:"owner,omitempty"`
+	Connector string            `json:"connector,omitempty"` // Provider ID, e.g. "s3"
+	Mount     string            `json:"mount,omitempty"`     // Mount the secret belongs to
+
+	// ExpiresAt is when the current value stops being valid; Get returns
+	// ErrExpired from then on. It belongs to the value, so a plain Put or a
+	// Rollback clears it. Moving it on disk is caught by the entry MAC, so an expired value
+	// cannot be revived that way. RotateBy is a reminder date only. Zero
+	// times mean none.
+	ExpiresAt time.Time `json:"expires_at"`
+	RotateBy  time.Time `json:"rotate_by"`
+
+	// Maintained by the store; ignored on input.
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SecretFilter selects secrets by metadata. Empty fields match every secret;
+// all non-empty fields, and every label, must match.
+type SecretFilter struct {
+	Type      SecretType
+	Labels    map[string]string
+	Owner     string
+	Connector string
+	Mount     string
+}
+
+// SecretInfo is a secret's key and metadata as returned by ListWithFilter.
+type SecretInfo struct {
+	Key      string         `json:"key"`
+	Version  int            `json:"version"` // Current version number
+	Revision int64          `json:"revision"`
+	Metadata SecretMetadata `json:"metadata"`
+}
+
+// validate rejects unknown secret types and empty label names.
+func (m SecretMetadata) validate() error {
+	switch m.Type {
+	case "", SecretTypePassword, SecretTypeOAuthToken, SecretTypeAccessKeyPair,
+		SecretTypeSSHKey, SecretTypeCertificate:
+	default:
+		return fmt.Errorf("%w: unknown secret type %q", ErrInvalidMetadata, m.Type)
+	}
+	for name := range m.Labels {
+		if name == "" {
+			return fmt.Errorf("%w: label name cannot be empty", ErrInvalidMetadata)
+		}
+	}
+	return nil
+}
+
+// clone returns a copy of m that shares no maps with it.
+func (m SecretMetadata) clone() SecretMetadata {
+	if m.Labels != nil {
+		labels := make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			labels[k] = v
+		}
+		m.Labels = labels
+	}
+	return m
+}
+
+// replacedBy returns in with the store-maintained fields of m.
+func (m SecretMetadata) replacedBy(in SecretMetadata) SecretMetadata {
+	out := in.clone()
+	out.CreatedAt = m.CreatedAt
+	out.UpdatedAt = m.UpdatedAt
+	return out
+}
+
+// matches reports whether m is selected by f.
+func (f SecretFilter) matches(m SecretMetadata) bool {
+	if f.Type != "" && f.Type != m.Type {
+		return false
+	}
+	if f.Owner != "" && f.Owner != m.Owner {
+		return false
+	}
+	if f.Connector != "" && f.Connector != m.Connector {
+		return false
+	}
+	if f.Mount != "" && f.Mount != m.Mount {
+		return false
+	}
+	for k, v := range f.Labels {
+		if got, ok := m.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// PutWithMetadata stores value as a new version of key and replaces the
+// secret's metadata with meta.
+func (s *aesgcmStore) PutWithMetadata(ctx context.Context, key string, value []byte, meta SecretMetadata) error {
+	_, err := s.put(ctx, key, value, &meta, anyRevision)
+	return err
+}
+
+// GetMetadata returns a secret's metadata without decrypting it.
+func (s *aesgcmStore) GetMetadata(ctx context.Context, key string) (SecretMetadata, error) {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "get_metadata",
+		Key:       key,
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	if err := s.checkUnsealed(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return SecretMetadata{}, err
+	}
+	if key == "" {
+		event.Success = false
+		event.Error = ErrKeyEmpty.Error()
+		return SecretMetadata{}, ErrKeyEmpty
+	}
+	if err := s.authorize(ctx, event.Operation, key); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return SecretMetadata{}, err
+	}
+
+	s.mu.RLock()
+	entry, exists := s.secrets[key]
+	s.mu.RUnlock()
+
+	if !exists {
+		event.Success = false
+		event.Error = ErrNotFound.Error()
+		return SecretMetadata{}, ErrNotFound
+	}
+	if err := s.loadKeys(ctx); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return SecretMetadata{}, err
+	}
+	if err := s.verifyEntry(key, entry); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return SecretMetadata{}, err
+	}
+
+	event.Success = true
+	return entry.Metadata.clone(), nil
+}
+
+// SetMetadata replaces a secret's metadata without writing a new version.
+func (s *aesgcmStore) SetMetadata(ctx context.Context, key string, meta SecretMetadata) error {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "set_metadata",
+		Key:       key,
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	if err := s.checkUnsealed(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
+	if key == "" {
+		event.Success = false
+		event.Error = ErrKeyEmpty.Error()
+		return ErrKeyEmpty
+	}
+	if err := s.authorize(ctx, event.Operation, key); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
+	if err := meta.validate(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
+	// The new metadata is signed under the active key.
+	if err := s.loadKeys(ctx); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, exists := s.secrets[key]
+	if !exists {
+		event.Success = false
+		event.Error = ErrNotFound.Error()
+		return ErrNotFound
+	}
+	if err := s.verifyEntry(key, previous); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
+
+	updated := previous.Metadata.replacedBy(meta)
+	updated.UpdatedAt = time.Now()
+	if _, err := s.commitLocked(map[string]*secretEntry{
+		key: {Metadata: updated, Versions: previous.Versions},
+	}); err != nil {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", ErrPersistence, err)
+		return fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	event.Success = true
+	return nil
+}
+
+// ListWithFilter returns the secrets whose metadata matches filter, sorted by
+// key. Nothing is decrypted.
+func (s *aesgcmStore) ListWithFilter(ctx context.Context, filter SecretFilter) ([]SecretInfo, error) {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "list",
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	if err := s.checkUnsealed(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	}
+
+	if err := s.loadKeys(ctx); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	}
+
+	s.mu.RLock()
+	infos := make([]SecretInfo, 0, len(s.secrets))
+	for key, entry := range s.secrets {
+		if err := s.verifyEntry(key, entry); err != nil {
+			s.mu.RUnlock()
+			event.Success = false
+			event.Error = err.Error()
+			return nil, err
+		}
+		if !filter.matches(entry.Metadata) {
+			continue
+		}
+		infos = append(infos, SecretInfo{
+			Key:      key,
+			Version:  entry.current().Number,
+			Revision: entry.Revision,
+			Metadata: entry.Metadata.clone(),
+		})
+	}
+	s.mu.RUnlock()
+
+	allowed := s.permits(ctx, "list")
+	visible := infos[:0]
+	for _, info := range infos {
+		if allowed(info.Key) {
+			visible = append(visible, info)
+		}
+	}
+	infos = visible
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	event.Success = true
+	event.Metadata = map[string]string{"count": fmt.Sprintf("%d", len(infos))}
+	return infos, nil
+}
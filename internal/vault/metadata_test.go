@@ -0,0 +1,300 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAESGCMStore_Metadata(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+
+	labels := map[string]string{"env": "prod"}
+	require.NoError(t, store.PutWithMetadata(ctx, "s3/prod", []byte("AKIA:secret"), SecretMetadata{
+		Type:      SecretTypeAccessKeyPair,
+		Labels:    labels,
+		Owner:     "alice",
+		Connector: "s3",
+		Mount:     "backups",
+	}))
+	labels["env"] = "mutated"
+
+	meta, err := store.GetMetadata(ctx, "s3/prod")
+	require.NoError(t, err)
+	require.Equal(t, SecretTypeAccessKeyPair, meta.Type)
+	require.Equal(t, map[string]string{"env": "prod"}, meta.Labels, "caller's map is copied")
+	require.Equal(t, "alice", meta.Owner)
+	require.False(t, meta.CreatedAt.IsZero())
+	require.Equal(t, meta.CreatedAt, meta.UpdatedAt)
+
+	t.Run("plain put keeps metadata", func(t *testing.T) {
+		require.NoError(t, store.Put(ctx, "s3/prod", []byte("AKIA:rotated")))
+		updated, err := store.GetMetadata(ctx, "s3/prod")
+		require.NoError(t, err)
+		require.Equal(t, SecretTypeAccessKeyPair, updated.Type)
+		require.Equal(t, meta.CreatedAt, updated.CreatedAt)
+		require.False(t, updated.UpdatedAt.Before(meta.UpdatedAt))
+	})
+
+	t.Run("set metadata does not add a version", func(t *testing.T) {
+		require.NoError(t, store.SetMetadata(ctx, "s3/prod", SecretMetadata{
+			Type:   SecretTypeAccessKeyPair,
+			Labels: map[string]string{"env": "staging"},
+		}))
+		updated, err := store.GetMetadata(ctx, "s3/prod")
+		require.NoError(t, err)
+		require.Equal(t, "staging", updated.Labels["env"])
+		require.Empty(t, updated.Owner)
+		require.Equal(t, meta.CreatedAt, updated.CreatedAt)
+
+		versions, err := store.ListVersions(ctx, "s3/prod")
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		err := store.PutWithMetadata(ctx, "bad", []byte("x"), SecretMetadata{Type: "api-key"})
+		require.ErrorIs(t, err, ErrInvalidMetadata)
+		_, err = store.Get(ctx, "bad")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		err = store.SetMetadata(ctx, "s3/prod", SecretMetadata{Labels: map[string]string{"": "x"}})
+		require.ErrorIs(t, err, ErrInvalidMetadata)
+		require.ErrorIs(t, store.SetMetadata(ctx, "missing", SecretMetadata{}), ErrNotFound)
+		_, err = store.GetMetadata(ctx, "missing")
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestAESGCMStore_ListWithFilter(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+
+	secrets := map[string]SecretMetadata{
+		"dropbox":  {Type: SecretTypeOAuthToken, Connector: "dropbox", Mount: "photos", Owner: "alice", Labels: map[string]string{"env": "prod"}},
+		"s3-prod":  {Type: SecretTypeAccessKeyPair, Connector: "s3", Mount: "backups", Owner: "bob", Labels: map[string]string{"env": "prod", "team": "infra"}},
+		"s3-dev":   {Type: SecretTypeAccessKeyPair, Connector: "s3", Mount: "scratch", Owner: "bob", Labels: map[string]string{"env": "dev"}},
+		"sftp-key": {Type: SecretTypeSSHKey, Connector: "sftp", Owner: "alice"},
+	}
+	for key, meta := range secrets {
+		require.NoError(t, store.PutWithMetadata(ctx, key, []byte("value"), meta))
+	}
+	require.NoError(t, store.Put(ctx, "untyped", []byte("value")))
+
+	keys := func(infos []SecretInfo) []string {
+		out := make([]string, len(infos))
+		for i, info := range infos {
+			out[i] = info.Key
+		}
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		filter SecretFilter
+		want   []string
+	}{
+		{name: "empty filter", filter: SecretFilter{}, want: []string{"dropbox", "s3-dev", "s3-prod", "sftp-key", "untyped"}},
+		{name: "type", filter: SecretFilter{Type: SecretTypeAccessKeyPair}, want: []string{"s3-dev", "s3-prod"}},
+		{name: "owner", filter: SecretFilter{Owner: "alice"}, want: []string{"dropbox", "sftp-key"}},
+		{name: "connector and mount", filter: SecretFilter{Connector: "s3", Mount: "backups"}, want: []string{"s3-prod"}},
+		{name: "label", filter: SecretFilter{Labels: map[string]string{"env": "prod"}}, want: []string{"dropbox", "s3-prod"}},
+		{name: "all labels must match", filter: SecretFilter{Labels: map[string]string{"env": "prod", "team": "infra"}}, want: []string{"s3-prod"}},
+		{name: "no match", filter: SecretFilter{Type: SecretTypeCertificate}, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			infos, err := store.ListWithFilter(ctx, tt.filter)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, keys(infos))
+		})
+	}
+
+	infos, err := store.ListWithFilter(ctx, SecretFilter{Connector: "sftp"})
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, 1, infos[0].Version)
+	require.Equal(t, SecretTypeSSHKey, infos[0].Metadata.Type)
+}
+
+func TestFileStore_PersistsMetadata(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	store, err := internalStore(NewFileStore(path, keyProvider, nil))
+	require.NoError(t, err)
+	require.NoError(t, store.PutWithMetadata(ctx, "token", []byte("value"), SecretMetadata{
+		Type:   SecretTypePassword,
+		Labels: map[string]string{"env": "prod"},
+	}))
+
+	require.NoError(t, store.Close())
+	reopened, err := internalStore(NewFileStore(path, keyProvider, nil))
+	require.NoError(t, err)
+	infos, err := reopened.ListWithFilter(ctx, SecretFilter{Type: SecretTypePassword})
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, "prod", infos[0].Metadata.Labels["env"])
+}
+
+func TestFileStore_DetectsMetadataTampering(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	store, err := internalStore(NewFileStore(path, keyProvider, nil))
+	require.NoError(t, err)
+	require.NoError(t, store.PutWithMetadata(ctx, "token", []byte("value"), SecretMetadata{
+		Labels: map[string]string{"env": "prod"},
+	}))
+	require.NoError(t, store.Put(ctx, "other", []byte("other")))
+	require.NoError(t, store.Close())
+
+	// Someone with write access to the file relabels the secret.
+	tamperStoreFile(t, path, storeFileVersion, func(secrets map[string]*secretEntry) {
+		secrets["token"].Metadata.Labels["env"] = "dev"
+	})
+	reopened, err := internalStore(NewFileStore(path, keyProvider, nil))
+	require.NoError(t, err)
+
+	_, err = reopened.Get(ctx, "token")
+	require.ErrorIs(t, err, ErrTampered)
+	_, err = reopened.GetMetadata(ctx, "token")
+	require.ErrorIs(t, err, ErrTampered)
+	_, err = reopened.ListWithFilter(ctx, SecretFilter{Labels: map[string]string{"env": "dev"}})
+	require.ErrorIs(t, err, ErrTampered)
+	require.ErrorIs(t, reopened.SetMetadata(ctx, "token", SecretMetadata{}), ErrTampered)
+	require.ErrorIs(t, reopened.Put(ctx, "token", []byte("new")), ErrTampered, "a new version must not inherit forged metadata")
+
+	got, err := reopened.Get(ctx, "other")
+	require.NoError(t, err)
+	require.Equal(t, []byte("other"), got)
+	require.NoError(t, reopened.Close())
+
+	// Moving an entry to another name breaks its MAC too.
+	tamperStoreFile(t, path, storeFileVersion, func(secrets map[string]*secretEntry) {
+		secrets["moved"] = secrets["other"]
+	})
+	reopened, err = internalStore(NewFileStore(path, keyProvider, nil))
+	require.NoError(t, err)
+	_, err = reopened.Get(ctx, "moved")
+	require.ErrorIs(t, err, ErrTampered)
+	require.NoError(t, reopened.Close())
+}
+
+func TestAESGCMStore_EntryMACMigration(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+	require.NoError(t, store.PutWithMetadata(ctx, "token", []byte("value"), SecretMetadata{
+		Labels: map[string]string{"env": "prod"},
+	}))
+	activeID, err := store.keyring.ActiveID()
+	require.NoError(t, err)
+	require.Equal(t, activeID, store.secrets["token"].MACKey)
+	require.NotEmpty(t, store.secrets["token"].MAC)
+
+	// Entries written before MACs existed stay readable until migrated.
+	putUnsigned(store, "token", store.secrets["token"])
+	got, err := store.Get(ctx, "token")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), got)
+
+	require.NoError(t, store.Migrate(ctx))
+	require.Equal(t, activeID, store.secrets["token"].MACKey)
+	require.NoError(t, store.verifyEntry("token", store.secrets["token"]))
+
+	require.NoError(t, store.Rotate(ctx))
+	rotatedID, err := store.keyring.ActiveID()
+	require.NoError(t, err)
+	require.NotEqual(t, activeID, rotatedID)
+	require.Equal(t, rotatedID, store.secrets["token"].MACKey)
+	meta, err := store.GetMetadata(ctx, "token")
+	require.NoError(t, err)
+	require.Equal(t, "prod", meta.Labels["env"])
+}
+
+func TestFileStore_UnsignedEntries(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	newStore := func(t *testing.T) string {
+		path := filepath.Join(t.TempDir(), "secrets.json")
+		store, err := internalStore(NewFileStore(path, keyProvider, nil))
+		require.NoError(t, err)
+		require.NoError(t, store.PutWithMetadata(ctx, "token", []byte("value"), SecretMetadata{Owner: "alice"}))
+		require.NoError(t, store.Close())
+		return path
+	}
+	stripAndReassign := func(secrets map[string]*secretEntry) {
+		secrets["token"].MACKey, secrets["token"].MAC = "", nil
+		secrets["token"].Metadata.Owner = "mallory"
+	}
+
+	t.Run("stripping the MAC does not help", func(t *testing.T) {
+		path := newStore(t)
+		tamperStoreFile(t, path, storeFileVersion, stripAndReassign)
+		_, err := NewFileStore(path, keyProvider, nil)
+		require.ErrorIs(t, err, ErrTampered)
+	})
+
+	t.Run("strict mode refuses a file downgraded to version 2", func(t *testing.T) {
+		path := newStore(t)
+		tamperStoreFile(t, path, storeFileVersion2, stripAndReassign)
+		store, err := internalStore(NewFileStore(path, keyProvider, nil, WithStrictEnvelopes()))
+		require.NoError(t, err)
+		defer store.Close()
+		_, err = store.GetMetadata(ctx, "token")
+		require.ErrorIs(t, err, ErrTampered)
+	})
+
+	t.Run("version 2 entries are signed by the first write", func(t *testing.T) {
+		path := newStore(t)
+		tamperStoreFile(t, path, storeFileVersion2, func(secrets map[string]*secretEntry) {
+			secrets["token"].MACKey, secrets["token"].MAC = "", nil
+		})
+		store, err := internalStore(NewFileStore(path, keyProvider, nil))
+		require.NoError(t, err)
+		meta, err := store.GetMetadata(ctx, "token")
+		require.NoError(t, err)
+		require.Equal(t, "alice", meta.Owner)
+
+		require.NoError(t, store.Put(ctx, "other", []byte("other")))
+		require.False(t, store.legacyEntries.Load())
+		require.NoError(t, store.Close())
+
+		// From here on the file is version 3, so stripping the MAC again is
+		// caught.
+		tamperStoreFile(t, path, storeFileVersion, stripAndReassign)
+		_, err = NewFileStore(path, keyProvider, nil)
+		require.ErrorIs(t, err, ErrTampered)
+	})
+}
+
+// tamperStoreFile rewrites the store file at path in format version after
+// edit has changed its entries, as someone with write access to storage could.
+func tamperStoreFile(t *testing.T, path string, version int, edit func(map[string]*secretEntry)) {
+	t.Helper()
+	secrets, revision, _, err := loadStoreFile(path)
+	require.NoError(t, err)
+	edit(secrets)
+	data, err := json.Marshal(storeFile{Version: version, Revision: revision, Secrets: secrets})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
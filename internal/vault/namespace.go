@@ -0,0 +1,244 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Keys are slash-separated paths such as "mounts/<id>/token" or
+// "oauth/<provider>/refresh". Everything before the last slash is the key's
+// namespace; namespaces nest, and are the unit for listing, deletion, access
+// policies and export.
+
+// NamespaceOf returns the namespace a key lives in: "mounts/abc" for
+// "mounts/abc/token", and "" for a top-level key.
+func NamespaceOf(key string) string {
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// InNamespace reports whether key lives in namespace or any namespace nested
+// below it. Every key is in the root namespace "".
+func InNamespace(key, namespace string) bool {
+	namespace = strings.TrimSuffix(namespace, "/")
+	return namespace == "" || strings.HasPrefix(key, namespace+"/")
+}
+
+// validateKey rejects keys that are not well-formed paths: empty segments,
+// leading or trailing slashes, and "." or ".." segments. Secrets stored under
+// such keys before they were rejected can still be read, updated and deleted;
+// only creating one, or exporting one in a bundle, fails.
+func validateKey(key string) error {
+	if key == "" {
+		return ErrKeyEmpty
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
+
+// NamespacePolicy decides whether the actor in ctx may perform operation, an
+// audit operation name such as "get" or "put", on keys in namespace. It
+// returns nil to allow the operation and an error explaining the refusal
+// otherwise. Listings and watches silently omit keys the policy refuses. The
+// policy may run while the store holds its locks, so it must not call back
+// into the store.
+type NamespacePolicy func(ctx context.Context, operation, namespace string) error
+
+// WithNamespacePolicy makes the store consult policy before every operation on
+// a secret. Store-wide operations such as Rotate are not subject to it.
+func WithNamespacePolicy(policy NamespacePolicy) StoreOption {
+	return func(s *aesgcmStore) {
+		s.policy = policy
+	}
+}
+
+// authorize checks the namespace policy for operation on key.
+func (s *aesgcmStore) authorize(ctx context.Context, operation, key string) error {
+	if s.policy == nil {
+		return nil
+	}
+	if err := s.policy(ctx, operation, NamespaceOf(key)); err != nil {
+		return fmt.Errorf("%w: %v", ErrAccessDenied, err)
+	}
+	return nil
+}
+
+// permits returns a predicate reporting whether the policy lets the actor in
+// ctx perform operation on a key. Decisions are cached per namespace, so the
+// predicate should only be used for one listing or one subscription.
+func (s *aesgcmStore) permits(ctx context.Context, operation string) func(key string) bool {
+	if s.policy == nil {
+		return func(string) bool { return true }
+	}
+	decisions := make(map[string]bool)
+	return func(key string) bool {
+		namespace := NamespaceOf(key)
+		allowed, ok := decisions[namespace]
+		if !ok {
+			allowed = s.policy(ctx, operation, namespace) == nil
+			decisions[namespace] = allowed
+		}
+		return allowed
+	}
+}
+
+// ListOptions selects a page of keys for ListPrefix.
+type ListOptions struct {
+	// Prefix is matched literally; end it with "/" to list a namespace.
+	Prefix string
+
+	// Cursor resumes a listing after the previous page; empty starts at the
+	// beginning.
+	Cursor string
+
+	// Limit caps the number of keys returned; zero or less returns them all.
+	Limit int
+}
+
+// KeyPage is one page of a ListPrefix listing.
+type KeyPage struct {
+	Keys []string `json:"keys"`
+
+	// NextCursor is passed back in ListOptions to fetch the next page. It is
+	// empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ListPrefix returns the keys starting with opts.Prefix in alphabetical order,
+// one page at a time. Cursors name the last key returned, so a listing stays
+// consistent while keys are added or removed between pages.
+func (s *aesgcmStore) ListPrefix(ctx context.Context, opts ListOptions) (KeyPage, error) {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "list",
+		Key:       opts.Prefix,
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	if err := s.checkUnsealed(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return KeyPage{}, err
+	}
+
+	var after string
+	if opts.Cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil || !strings.HasPrefix(string(decoded), opts.Prefix) {
+			event.Success = false
+			event.Error = ErrInvalidCursor.Error()
+			return KeyPage{}, ErrInvalidCursor
+		}
+		after = string(decoded)
+	}
+
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.secrets))
+	for k := range s.secrets {
+		if strings.HasPrefix(k, opts.Prefix) && k > after {
+			keys = append(keys, k)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Strings(keys)
+
+	allowed := s.permits(ctx, "list")
+	page := KeyPage{Keys: make([]string, 0)}
+	for _, k := range keys {
+		if !allowed(k) {
+			continue
+		}
+		if opts.Limit > 0 && len(page.Keys) == opts.Limit {
+			page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(page.Keys[len(page.Keys)-1]))
+			break
+		}
+		page.Keys = append(page.Keys, k)
+	}
+
+	event.Success = true
+	event.Metadata = map[string]string{"count": fmt.Sprintf("%d", len(page.Keys))}
+	return page, nil
+}
+
+// DeleteNamespace removes every secret in namespace and the namespaces nested
+// below it as one commit, and returns the number of secrets removed. Returns
+// ErrNotFound if the namespace is empty.
+func (s *aesgcmStore) DeleteNamespace(ctx context.Context, namespace string) (int, error) {
+	start := time.Now()
+	namespace = strings.TrimSuffix(namespace, "/")
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "delete_namespace",
+		Key:       namespace,
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	if err := s.checkUnsealed(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return 0, err
+	}
+	if namespace == "" {
+		// Deleting the root namespace would wipe the whole vault.
+		event.Success = false
+		event.Error = ErrKeyEmpty.Error()
+		return 0, ErrKeyEmpty
+	}
+	if err := validateKey(namespace); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return 0, err
+	}
+	// Writing the file signs any legacy entries still unsigned.
+	if err := s.loadKeys(ctx); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changes := make(map[string]*secretEntry)
+	for key := range s.secrets {
+		if !InNamespace(key, namespace) {
+			continue
+		}
+		if err := s.authorize(ctx, "delete", key); err != nil {
+			event.Success = false
+			event.Error = err.Error()
+			return 0, err
+		}
+		changes[key] = nil
+	}
+	if len(changes) == 0 {
+		event.Success = false
+		event.Error = ErrNotFound.Error()
+		return 0, ErrNotFound
+	}
+
+	revision, err := s.commitLocked(changes)
+	if err != nil {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", ErrPersistence, err)
+		return 0, fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	event.Success = true
+	event.Metadata = map[string]string{
+		"count":    fmt.Sprintf("%d", len(changes)),
+		"revision": fmt.Sprintf("%d", revision),
+	}
+	return len(changes), nil
+}
@@ -0,0 +1,206 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaces(t *testing.T) {
+	tests := []struct {
+		key       string
+		namespace string
+	}{
+		{key: "token", namespace: ""},
+		{key: "mounts/abc/token", namespace: "mounts/abc"},
+		{key: "oauth/dropbox/refresh", namespace: "oauth/dropbox"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.namespace, NamespaceOf(tt.key), tt.key)
+	}
+
+	require.True(t, InNamespace("mounts/abc/token", "mounts"))
+	require.True(t, InNamespace("mounts/abc/token", "mounts/abc/"))
+	require.True(t, InNamespace("token", ""))
+	require.False(t, InNamespace("mounts/abcd/token", "mounts/abc"), "namespaces match whole segments")
+	require.False(t, InNamespace("mounts", "mounts"))
+}
+
+func TestAESGCMStore_KeyValidation(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil)
+
+	for _, key := range []string{"/leading", "trailing/", "double//slash", "a/./b", "a/../b"} {
+		require.ErrorIs(t, store.Put(ctx, key, []byte("v")), ErrInvalidKey, key)
+	}
+	require.ErrorIs(t, store.Put(ctx, "", []byte("v")), ErrKeyEmpty)
+	require.NoError(t, store.Put(ctx, "mounts/abc/token", []byte("v")))
+
+	t.Run("keys stored before validation stay writable", func(t *testing.T) {
+		internal := store.(*aesgcmStore)
+		putUnsigned(internal, "double//slash", internal.secrets["mounts/abc/token"])
+
+		require.NoError(t, store.Put(ctx, "double//slash", []byte("v2")))
+		_, err := internal.ApplyBatch(ctx, []BatchOp{PutOp("double//slash", []byte("v3"))})
+		require.NoError(t, err)
+		_, err = internal.ApplyBatch(ctx, []BatchOp{PutOp("a/../b", []byte("v"))})
+		require.ErrorIs(t, err, ErrInvalidKey, "new keys are still checked")
+
+		_, err = internal.Export(ctx, io.Discard, ExportOptions{Passphrase: "pw"})
+		require.ErrorIs(t, err, ErrInvalidKey, "Import would refuse the bundle")
+
+		require.NoError(t, store.Delete(ctx, "double//slash"))
+		require.ErrorIs(t, store.Put(ctx, "double//slash", []byte("v")), ErrInvalidKey)
+	})
+}
+
+func TestAESGCMStore_ListPrefix(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+
+	for i := 0; i < 7; i++ {
+		require.NoError(t, store.Put(ctx, fmt.Sprintf("mounts/abc/k%d", i), []byte("v")))
+	}
+	require.NoError(t, store.Put(ctx, "mounts/abcd/other", []byte("v")))
+	require.NoError(t, store.Put(ctx, "oauth/dropbox/refresh", []byte("v")))
+
+	page, err := store.ListPrefix(ctx, ListOptions{Prefix: "mounts/abc/"})
+	require.NoError(t, err)
+	require.Len(t, page.Keys, 7)
+	require.Empty(t, page.NextCursor)
+
+	t.Run("pages follow the cursor", func(t *testing.T) {
+		var all []string
+		opts := ListOptions{Prefix: "mounts/abc/", Limit: 3}
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 5)
+			page, err := store.ListPrefix(ctx, opts)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page.Keys), 3)
+			all = append(all, page.Keys...)
+			if page.NextCursor == "" {
+				break
+			}
+			opts.Cursor = page.NextCursor
+
+			// Keys added before the cursor don't shift later pages.
+			if pages == 0 {
+				require.NoError(t, store.Put(ctx, "mounts/abc/k0a", []byte("v")))
+			}
+		}
+		require.Equal(t, []string{
+			"mounts/abc/k0", "mounts/abc/k1", "mounts/abc/k2",
+			"mounts/abc/k3", "mounts/abc/k4", "mounts/abc/k5", "mounts/abc/k6",
+		}, all)
+	})
+
+	t.Run("exact page size has no next cursor", func(t *testing.T) {
+		page, err := store.ListPrefix(ctx, ListOptions{Prefix: "oauth/", Limit: 1})
+		require.NoError(t, err)
+		require.Equal(t, []string{"oauth/dropbox/refresh"}, page.Keys)
+		require.Empty(t, page.NextCursor)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, err := store.ListPrefix(ctx, ListOptions{Prefix: "mounts/", Cursor: "!!"})
+		require.ErrorIs(t, err, ErrInvalidCursor)
+
+		page, err := store.ListPrefix(ctx, ListOptions{Prefix: "mounts/abc/", Limit: 1})
+		require.NoError(t, err)
+		_, err = store.ListPrefix(ctx, ListOptions{Prefix: "oauth/", Cursor: page.NextCursor})
+		require.ErrorIs(t, err, ErrInvalidCursor, "cursor belongs to another prefix")
+	})
+}
+
+func TestAESGCMStore_DeleteNamespace(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	var auditEvents []AuditEvent
+	store := NewAESGCMStore(keyProvider, func(e AuditEvent) {
+		auditEvents = append(auditEvents, e)
+	}).(*aesgcmStore)
+	for _, key := range []string{"mounts/abc/token", "mounts/abc/nested/key", "mounts/abcd/token", "oauth/dropbox/refresh"} {
+		require.NoError(t, store.Put(ctx, key, []byte("v")))
+	}
+
+	n, err := store.DeleteNamespace(ctx, "mounts/abc/")
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	last := auditEvents[len(auditEvents)-1]
+	require.Equal(t, "delete_namespace", last.Operation)
+	require.Equal(t, "mounts/abc", last.Key)
+	require.Equal(t, "2", last.Metadata["count"])
+
+	keys, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"mounts/abcd/token", "oauth/dropbox/refresh"}, keys)
+
+	_, err = store.DeleteNamespace(ctx, "mounts/abc")
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = store.DeleteNamespace(ctx, "")
+	require.ErrorIs(t, err, ErrKeyEmpty, "the root namespace cannot be deleted")
+}
+
+func TestAESGCMStore_NamespacePolicy(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	errForbidden := errors.New("forbidden")
+	// The scheduler may only touch its own mount's namespace.
+	policy := func(ctx context.Context, operation, namespace string) error {
+		if ActorFromContext(ctx) != "scheduler" {
+			return nil
+		}
+		if namespace == "mounts/abc" || strings.HasPrefix(namespace, "mounts/abc/") {
+			return nil
+		}
+		return errForbidden
+	}
+	store := NewAESGCMStore(keyProvider, nil, WithNamespacePolicy(policy)).(*aesgcmStore)
+
+	for _, key := range []string{"mounts/abc/token", "mounts/xyz/token", "oauth/dropbox/refresh"} {
+		require.NoError(t, store.Put(ctx, key, []byte("v")))
+	}
+
+	scheduler := WithActor(ctx, "scheduler")
+	_, err = store.Get(scheduler, "mounts/abc/token")
+	require.NoError(t, err)
+	_, err = store.Get(scheduler, "mounts/xyz/token")
+	require.ErrorIs(t, err, ErrAccessDenied)
+	require.ErrorContains(t, err, "forbidden")
+	require.ErrorIs(t, store.Put(scheduler, "oauth/dropbox/refresh", []byte("x")), ErrAccessDenied)
+	_, err = store.GetMetadata(scheduler, "oauth/dropbox/refresh")
+	require.ErrorIs(t, err, ErrAccessDenied)
+
+	_, err = store.ApplyBatch(scheduler, []BatchOp{PutOp("mounts/abc/token", []byte("x")), DeleteOp("mounts/xyz/token")})
+	require.ErrorIs(t, err, ErrAccessDenied)
+	_, err = store.DeleteNamespace(scheduler, "mounts")
+	require.ErrorIs(t, err, ErrAccessDenied)
+
+	keys, err := store.List(scheduler)
+	require.NoError(t, err)
+	require.Equal(t, []string{"mounts/abc/token"}, keys, "listings omit refused namespaces")
+	page, err := store.ListPrefix(scheduler, ListOptions{Prefix: "mounts/"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"mounts/abc/token"}, page.Keys)
+	infos, err := store.ListWithFilter(scheduler, SecretFilter{})
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+
+	keys, err = store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 3, "other actors are unaffected")
+}
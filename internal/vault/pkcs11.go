@@ -0,0 +1,39 @@
+package vault
+
+// pkcs11FileVersion is the current format of the PKCS#11 key file.
+const pkcs11FileVersion = 1
+
+// PKCS11Config configures a PKCS11KeyProvider.
+type PKCS11Config struct {
+	// ModulePath is the PKCS#11 shared library, e.g. /usr/lib/softhsm/libsofthsm2.so.
+	ModulePath string
+
+	// TokenLabel selects the token by label. When empty, Slot is used.
+	TokenLabel string
+
+	// Slot is the slot ID holding the token.
+	Slot uint
+
+	// PIN is the user PIN used to log in to the token.
+	PIN string
+
+	// KeyLabel is the CKA_LABEL of the AES wrapping key inside the HSM.
+	KeyLabel string
+
+	// GenerateKey creates the wrapping key (AES-256, sensitive, non-extractable)
+	// on the token when no key with KeyLabel exists.
+	GenerateKey bool
+}
+
+// pkcs11File is the on-disk representation of an HSM-protected key. Each
+// wrapped key is IV || AES-GCM ciphertext produced inside the HSM.
+type pkcs11File struct {
+	Version  int    `json:"version"`
+	KeyLabel string `json:"key_label"`
+
+	// WrappedKey is the master key encrypted by the HSM wrapping key.
+	WrappedKey []byte `json:"wrapped_key"`
+
+	// Retired holds master keys replaced by rotation, newest first, wrapped the same way.
+	Retired [][]byte `json:"retired,omitempty"`
+}
@@ -0,0 +1,401 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+)
+
+// ageFileVersion is the current format of the age key file.
+const ageFileVersion = 1
+
+// ageFile is the on-disk representation of an age-protected key. The recipient
+// list is kept in clear so it can be edited; the keys are only in Encrypted.
+type ageFile struct {
+	Version    int      `json:"version"`
+	Recipients []string `json:"recipients"`
+
+	// Encrypted is an age ciphertext of agePayload, encrypted to every recipient.
+	Encrypted []byte `json:"encrypted"`
+}
+
+// agePayload is the plaintext protected by age.
+type agePayload struct {
+	Key []byte `json:"key"`
+
+	// Retired holds master keys replaced by rotation, newest first.
+	Retired [][]byte `json:"retired,omitempty"`
+}
+
+// AgeKeyProvider stores the master key encrypted to one or more age recipients
+// (X25519 "age1..." keys or SSH public keys) and decrypts it with an identity
+// file, so headless hosts can unlock the vault without a passphrase or KMS.
+//
+// Adding or removing a recipient re-encrypts only the key file; the master key
+// and therefore every stored secret stay the same.
+type AgeKeyProvider struct {
+	keyPath    string
+	identities []age.Identity
+
+	mu sync.Mutex // Serializes key file updates
+}
+
+// NewAgeKeyProvider opens or creates an age-protected key file, decrypting it
+// with the identities in identityFile (an age identity file or an unencrypted
+// OpenSSH private key). recipients is only used when the key file is created;
+// if empty, the key is encrypted to the identity's own recipient.
+func NewAgeKeyProvider(keyPath, identityFile string, recipients []string) (*AgeKeyProvider, error) {
+	identities, err := loadAgeIdentities(identityFile)
+	if err != nil {
+		return nil, err
+	}
+	p := &AgeKeyProvider{keyPath: keyPath, identities: identities}
+
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := removeStaleTempFiles(keyPath); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
+		if len(recipients) == 0 {
+			recipients, err = identityRecipients(identities)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := p.generateKey(recipients); err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		return p, nil
+	}
+
+	// Fail at startup, not on first use, if the identity cannot decrypt the key.
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.decrypt(f); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *AgeKeyProvider) GetKey(ctx context.Context) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payload, _, err := p.open()
+	if err != nil {
+		return nil, err
+	}
+	return payload.Key, nil
+}
+
+func (p *AgeKeyProvider) RotateKey(ctx context.Context) (oldKey, newKey []byte, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payload, f, err := p.open()
+	if err != nil {
+		return nil, nil, err
+	}
+	oldKey = payload.Key
+
+	newKey = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, newKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate new key: %w", err)
+	}
+
+	payload.Retired = append([][]byte{oldKey}, payload.Retired...)
+	payload.Key = newKey
+	if err := p.seal(f.Recipients, payload); err != nil {
+		return nil, nil, fmt.Errorf("failed to write new key: %w", err)
+	}
+
+	return oldKey, newKey, nil
+}
+
+// RestoreKey reinstates a previous master key after a failed rotation.
+func (p *AgeKeyProvider) RestoreKey(ctx context.Context, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(key))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payload, f, err := p.open()
+	if err != nil {
+		return err
+	}
+	payload.Key = append([]byte(nil), key...)
+	if err := p.seal(f.Recipients, payload); err != nil {
+		return fmt.Errorf("failed to restore key: %w", err)
+	}
+	return nil
+}
+
+// RetiredKeys returns master keys replaced by RotateKey, newest first.
+func (p *AgeKeyProvider) RetiredKeys(ctx context.Context) ([][]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payload, _, err := p.open()
+	if err != nil {
+		return nil, err
+	}
+	return payload.Retired, nil
+}
+
+// Recipients returns the recipients the key file is currently encrypted to.
+func (p *AgeKeyProvider) Recipients() ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	return f.Recipients, nil
+}
+
+// AddRecipient re-encrypts the key file so recipient can also decrypt it.
+func (p *AgeKeyProvider) AddRecipient(ctx context.Context, recipient string) error {
+	normalized, _, err := parseAgeRecipient(recipient)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payload, f, err := p.open()
+	if err != nil {
+		return err
+	}
+	for _, r := range f.Recipients {
+		if r == normalized {
+			return nil
+		}
+	}
+	if err := p.seal(append(f.Recipients, normalized), payload); err != nil {
+		return fmt.Errorf("failed to add recipient: %w", err)
+	}
+	return nil
+}
+
+// RemoveRecipient re-encrypts the key file without recipient. The last
+// recipient cannot be removed.
+func (p *AgeKeyProvider) RemoveRecipient(ctx context.Context, recipient string) error {
+	normalized, _, err := parseAgeRecipient(recipient)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payload, f, err := p.open()
+	if err != nil {
+		return err
+	}
+	remaining := make([]string, 0, len(f.Recipients))
+	for _, r := range f.Recipients {
+		if r != normalized {
+			remaining = append(remaining, r)
+		}
+	}
+	if len(remaining) == len(f.Recipients) {
+		return fmt.Errorf("recipient not found: %s", normalized)
+	}
+	if len(remaining) == 0 {
+		return fmt.Errorf("cannot remove the last recipient")
+	}
+	if err := p.seal(remaining, payload); err != nil {
+		return fmt.Errorf("failed to remove recipient: %w", err)
+	}
+	return nil
+}
+
+func (p *AgeKeyProvider) HealthCheck(ctx context.Context) error {
+	key, err := p.GetKey(ctx)
+	if err != nil {
+		return err
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("invalid key size: %d", len(key))
+	}
+	return nil
+}
+
+// generateKey creates a new key file holding a random master key.
+func (p *AgeKeyProvider) generateKey(recipients []string) error {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	defer wipe(key)
+	return p.seal(recipients, agePayload{Key: key})
+}
+
+// open reads and decrypts the key file. Callers must hold p.mu.
+func (p *AgeKeyProvider) open() (agePayload, ageFile, error) {
+	f, err := p.load()
+	if err != nil {
+		return agePayload{}, ageFile{}, err
+	}
+	payload, err := p.decrypt(f)
+	if err != nil {
+		return agePayload{}, ageFile{}, err
+	}
+	return payload, f, nil
+}
+
+func (p *AgeKeyProvider) decrypt(f ageFile) (agePayload, error) {
+	r, err := age.Decrypt(bytes.NewReader(f.Encrypted), p.identities...)
+	if err != nil {
+		return agePayload{}, fmt.Errorf("failed to decrypt key file: %w", err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return agePayload{}, fmt.Errorf("failed to decrypt key file: %w", err)
+	}
+	defer wipe(data)
+
+	var payload agePayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return agePayload{}, fmt.Errorf("failed to parse key file payload: %w", err)
+	}
+	if len(payload.Key) != 32 {
+		return agePayload{}, fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(payload.Key))
+	}
+	return payload, nil
+}
+
+// seal encrypts payload to recipients and writes the key file atomically.
+func (p *AgeKeyProvider) seal(recipients []string, payload agePayload) error {
+	if len(recipients) == 0 {
+		return fmt.Errorf("at least one recipient is required")
+	}
+	normalized := make([]string, 0, len(recipients))
+	parsed := make([]age.Recipient, 0, len(recipients))
+	for _, s := range recipients {
+		n, r, err := parseAgeRecipient(s)
+		if err != nil {
+			return err
+		}
+		normalized = append(normalized, n)
+		parsed = append(parsed, r)
+	}
+
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	defer wipe(plaintext)
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, parsed...)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(ageFile{Version: ageFileVersion, Recipients: normalized, Encrypted: buf.Bytes()})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.keyPath, data, 0600)
+}
+
+// load reads the key file. Callers must hold p.mu except during construction.
+func (p *AgeKeyProvider) load() (ageFile, error) {
+	data, err := os.ReadFile(p.keyPath)
+	if err != nil {
+		return ageFile{}, fmt.Errorf("failed to read key file: %w", err)
+	}
+	var f ageFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return ageFile{}, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	if f.Version != ageFileVersion {
+		return ageFile{}, fmt.Errorf("unsupported key file version: %d", f.Version)
+	}
+	return f, nil
+}
+
+// parseAgeRecipient accepts an age X25519 recipient ("age1...") or an SSH
+// public key line, returning it normalized (SSH comments dropped).
+func parseAgeRecipient(s string) (string, age.Recipient, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "age1") {
+		r, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid recipient: %w", err)
+		}
+		return r.String(), r, nil
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return "", nil, fmt.Errorf("invalid recipient: %q", s)
+	}
+	normalized := fields[0] + " " + fields[1]
+	r, err := agessh.ParseRecipient(normalized)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid recipient: %w", err)
+	}
+	return normalized, r, nil
+}
+
+// loadAgeIdentities reads an age identity file or an unencrypted OpenSSH private key.
+func loadAgeIdentities(path string) ([]age.Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity file: %w", err)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		id, err := agessh.ParseIdentity(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH identity: %w", err)
+		}
+		return []age.Identity{id}, nil
+	}
+	ids, err := age.ParseIdentities(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity file: %w", err)
+	}
+	return ids, nil
+}
+
+// identityRecipients returns the recipient strings matching identities, for
+// creating a key file that the local host can decrypt.
+func identityRecipients(identities []age.Identity) ([]string, error) {
+	var recipients []string
+	for _, id := range identities {
+		switch id := id.(type) {
+		case *age.X25519Identity:
+			recipients = append(recipients, id.Recipient().String())
+		default:
+			return nil, fmt.Errorf("recipients are required for %T identities", id)
+		}
+	}
+	return recipients, nil
+}
@@ -0,0 +1,136 @@
+package vault
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// writeAgeIdentity writes a new X25519 identity file and returns its path and recipient.
+func writeAgeIdentity(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte("# test identity\n"+id.String()+"\n"), 0600))
+	return path, id.Recipient().String()
+}
+
+// writeSSHIdentity writes a new OpenSSH ed25519 private key and returns its
+// path and authorized_keys line.
+func writeSSHIdentity(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return path, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " ops@example"
+}
+
+func TestAgeKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "master.key")
+	hostID, hostRecipient := writeAgeIdentity(t, dir, "host.txt")
+
+	p, err := NewAgeKeyProvider(keyPath, hostID, nil)
+	require.NoError(t, err)
+	require.NoError(t, p.HealthCheck(ctx))
+
+	recipients, err := p.Recipients()
+	require.NoError(t, err)
+	require.Equal(t, []string{hostRecipient}, recipients, "defaults to the identity's own recipient")
+
+	key, err := p.GetKey(ctx)
+	require.NoError(t, err)
+	require.Len(t, key, 32)
+
+	reopened, err := NewAgeKeyProvider(keyPath, hostID, nil)
+	require.NoError(t, err)
+	again, err := reopened.GetKey(ctx)
+	require.NoError(t, err)
+	require.Equal(t, key, again)
+
+	t.Run("wrong identity fails at startup", func(t *testing.T) {
+		otherID, _ := writeAgeIdentity(t, t.TempDir(), "other.txt")
+		_, err := NewAgeKeyProvider(keyPath, otherID, nil)
+		require.ErrorContains(t, err, "failed to decrypt key file")
+	})
+
+	t.Run("rotation keeps retired keys", func(t *testing.T) {
+		oldKey, newKey, err := p.RotateKey(ctx)
+		require.NoError(t, err)
+		require.Equal(t, key, oldKey)
+		require.NotEqual(t, oldKey, newKey)
+
+		retired, err := p.RetiredKeys(ctx)
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{oldKey}, retired)
+
+		require.NoError(t, p.RestoreKey(ctx, oldKey))
+		restored, err := p.GetKey(ctx)
+		require.NoError(t, err)
+		require.Equal(t, oldKey, restored)
+	})
+}
+
+func TestAgeKeyProvider_Recipients(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "master.key")
+	storePath := filepath.Join(dir, "secrets.json")
+	hostID, hostRecipient := writeAgeIdentity(t, dir, "host.txt")
+	sshID, sshRecipient := writeSSHIdentity(t, dir, "id_ed25519")
+
+	p, err := NewAgeKeyProvider(keyPath, hostID, []string{hostRecipient})
+	require.NoError(t, err)
+	store, err := NewFileStore(storePath, p, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "token", []byte("value")))
+	before, err := os.ReadFile(storePath)
+	require.NoError(t, err)
+
+	_, err = NewAgeKeyProvider(keyPath, sshID, nil)
+	require.Error(t, err, "SSH identity is not a recipient yet")
+
+	require.NoError(t, p.AddRecipient(ctx, sshRecipient))
+	require.NoError(t, p.AddRecipient(ctx, sshRecipient), "adding twice is a no-op")
+	recipients, err := p.Recipients()
+	require.NoError(t, err)
+	require.Equal(t, []string{hostRecipient, strings.TrimSuffix(sshRecipient, " ops@example")}, recipients)
+
+	require.NoError(t, p.RemoveRecipient(ctx, hostRecipient))
+	_, err = NewAgeKeyProvider(keyPath, hostID, nil)
+	require.Error(t, err, "removed recipient can no longer decrypt")
+
+	sshProvider, err := NewAgeKeyProvider(keyPath, sshID, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	reopened, err := NewFileStore(storePath, sshProvider, nil)
+	require.NoError(t, err)
+	got, err := reopened.Get(ctx, "token")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), got)
+
+	after, err := os.ReadFile(storePath)
+	require.NoError(t, err)
+	require.Equal(t, before, after, "recipient changes must not re-encrypt secrets")
+
+	require.ErrorContains(t, sshProvider.RemoveRecipient(ctx, sshRecipient), "last recipient")
+	require.ErrorContains(t, sshProvider.RemoveRecipient(ctx, hostRecipient), "not found")
+	require.ErrorContains(t, sshProvider.AddRecipient(ctx, "age1notakey"), "invalid recipient")
+}
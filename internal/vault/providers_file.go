@@ -3,78 +3,255 @@ package vault
 import (
 	"context"
 	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
+const (
+	// keyJournalVersion is the current format of the rotation journal.
+	keyJournalVersion = 1
+
+	// DefaultRetainedKeys is how many rotated-out keys FileKeyProvider keeps
+	// unless WithKeyRetention says otherwise.
+	DefaultRetainedKeys = 5
+)
+
+// Journal states.
+const (
+	journalIdle    = "idle"
+	journalPending = "pending"
+)
+
+// keyJournal records which key files are active, pending or retired so an
+// interrupted rotation can be finished or rolled back on the next start.
+type keyJournal struct {
+	Version int    `json:"version"`
+	State   string `json:"state"`
+
+	// Active is the ID of the key in the key file once the journal is idle.
+	Active string `json:"active"`
+
+	// Pending is the ID of the key being installed while State is pending.
+	Pending string `json:"pending,omitempty"`
+
+	// Retired lists rotated-out keys, newest first.
+	Retired []retiredKey `json:"retired,omitempty"`
+}
+
+type retiredKey struct {
+	ID        string    `json:"id"`
+	RetiredAt time.Time `json:"retired_at"`
+}
+
+// FileKeyOption configures a FileKeyProvider.
+type FileKeyOption func(*FileKeyProvider)
+
+// WithKeyRetention keeps at most count rotated-out keys, dropping those
+// retired longer than maxAge ago. Zero disables the respective limit. Secrets
+// still encrypted under a dropped key become unreadable, so keep enough keys
+// to cover any entries not yet re-encrypted by Rotate or Migrate.
+func WithKeyRetention(count int, maxAge time.Duration) FileKeyOption {
+	return func(p *FileKeyProvider) {
+		p.retainCount = count
+		p.retainAge = maxAge
+	}
+}
+
 // FileKeyProvider stores the master key on disk (for MVP).
 // Production deployments should use OS keychain or external secret stores.
+//
+// Rotation is crash-safe: the new key is written to a pending file and fsynced,
+// the old key is copied to a retired file, a journal marks the rotation as
+// pending, and only then is the pending file renamed over the key file.
+// NewFileKeyProvider finishes or rolls back a rotation that was interrupted.
 type FileKeyProvider struct {
-	keyPath string
+	keyPath     string
+	retainCount int
+	retainAge   time.Duration
+
+	mu sync.Mutex // Serializes rotation and journal updates
 }
 
+// rotationStep, when set by tests, is called before each step of RotateKey;
+// an error aborts the rotation there to simulate a crash.
+var rotationStep func(p *FileKeyProvider, step string) error
+
 // NewFileKeyProvider creates a key provider that reads from the specified path.
 // If the file doesn't exist, it generates a new random key.
-func NewFileKeyProvider(keyPath string) (*FileKeyProvider, error) {
-	p := &FileKeyProvider{keyPath: keyPath}
+func NewFileKeyProvider(keyPath string, opts ...FileKeyOption) (*FileKeyProvider, error) {
+	p := &FileKeyProvider{keyPath: keyPath, retainCount: DefaultRetainedKeys}
+	for _, opt := range opts {
+		opt(p)
+	}
 
 	// Ensure directory exists
 	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
 		return nil, fmt.Errorf("failed to create key directory: %w", err)
 	}
+	if err := removeStaleTempFiles(keyPath); err != nil {
+		return nil, err
+	}
 
-	// Generate key if it doesn't exist
+	// Generate key if it doesn't exist and no rotation was in flight
 	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
-		if err := p.generateKey(); err != nil {
-			return nil, fmt.Errorf("failed to generate key: %w", err)
+		if _, jerr := os.Stat(p.journalPath()); os.IsNotExist(jerr) {
+			if err := p.generateKey(); err != nil {
+				return nil, fmt.Errorf("failed to generate key: %w", err)
+			}
 		}
 	}
 
+	if err := p.recover(); err != nil {
+		return nil, fmt.Errorf("failed to recover key rotation: %w", err)
+	}
+
 	return p, nil
 }
 
+// GetKey reads the key file. The provider keeps no copy in memory between
+// calls; the one returned is the caller's to wipe.
 func (p *FileKeyProvider) GetKey(ctx context.Context) ([]byte, error) {
-	key, err := os.ReadFile(p.keyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read key file: %w", err)
-	}
-	if len(key) != 32 {
-		return nil, fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(key))
-	}
-	return key, nil
+	return readKeyFile(p.keyPath)
 }
 
 func (p *FileKeyProvider) RotateKey(ctx context.Context) (oldKey, newKey []byte, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	j, err := p.loadJournal()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Read old key
 	oldKey, err = p.GetKey(ctx)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to read old key: %w", err)
 	}
+	oldID := KeyID(oldKey)
 
 	// Generate new key
 	newKey = make([]byte, 32)
 	if _, err := io.ReadFull(rand.Reader, newKey); err != nil {
 		return nil, nil, fmt.Errorf("failed to generate new key: %w", err)
 	}
+	newID := KeyID(newKey)
 
-	// Backup old key
-	backupPath := p.keyPath + ".bak"
-	if err := os.WriteFile(backupPath, oldKey, 0600); err != nil {
-		return nil, nil, fmt.Errorf("failed to backup old key: %w", err)
+	// Keep the old key under its own name before anything else changes
+	if err := p.step("retire"); err != nil {
+		return nil, nil, err
+	}
+	if err := writeFileAtomic(p.retiredPath(oldID), oldKey, 0600); err != nil {
+		return nil, nil, fmt.Errorf("failed to retire old key: %w", err)
+	}
+
+	// Stage the new key next to the key file
+	if err := p.step("stage"); err != nil {
+		return nil, nil, err
+	}
+	if err := writeFileAtomic(p.pendingPath(), newKey, 0600); err != nil {
+		return nil, nil, fmt.Errorf("failed to stage new key: %w", err)
 	}
 
-	// Write new key
-	if err := os.WriteFile(p.keyPath, newKey, 0600); err != nil {
-		return nil, nil, fmt.Errorf("failed to write new key: %w", err)
+	// Record the rotation so a crash from here on can be resolved
+	if err := p.step("journal"); err != nil {
+		return nil, nil, err
+	}
+	j.State = journalPending
+	j.Active = oldID
+	j.Pending = newID
+	if err := p.saveJournal(j); err != nil {
+		return nil, nil, fmt.Errorf("failed to write rotation journal: %w", err)
+	}
+
+	// Install the new key with a single atomic rename
+	if err := p.step("install"); err != nil {
+		return nil, nil, err
+	}
+	if err := os.Rename(p.pendingPath(), p.keyPath); err != nil {
+		return nil, nil, fmt.Errorf("failed to install new key: %w", err)
+	}
+	if err := syncDir(filepath.Dir(p.keyPath)); err != nil {
+		return nil, nil, err
+	}
+
+	if err := p.step("commit"); err != nil {
+		return nil, nil, err
+	}
+	if err := p.commitRotation(j); err != nil {
+		return nil, nil, err
 	}
 
 	return oldKey, newKey, nil
 }
 
+// RestoreKey reinstates a previous master key after a failed rotation. The
+// key is taken off the retired list; the key it replaces is discarded.
+func (p *FileKeyProvider) RestoreKey(ctx context.Context, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(key))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	j, err := p.loadJournal()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(p.keyPath, key, 0600); err != nil {
+		return fmt.Errorf("failed to restore key: %w", err)
+	}
+
+	id := KeyID(key)
+	j.State = journalIdle
+	j.Active = id
+	j.Pending = ""
+	for i, r := range j.Retired {
+		if r.ID == id {
+			j.Retired = append(j.Retired[:i:i], j.Retired[i+1:]...)
+			break
+		}
+	}
+	if err := p.saveJournal(j); err != nil {
+		return fmt.Errorf("failed to restore key: %w", err)
+	}
+	if err := os.Remove(p.retiredPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove retired key file: %w", err)
+	}
+	return nil
+}
+
+// RetiredKeys returns the rotated-out keys still within the retention policy,
+// newest first.
+func (p *FileKeyProvider) RetiredKeys(ctx context.Context) ([][]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	j, err := p.loadJournal()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, 0, len(j.Retired))
+	for _, r := range j.Retired {
+		key, err := readKeyFile(p.retiredPath(r.ID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read retired key %s: %w", r.ID, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
 func (p *FileKeyProvider) HealthCheck(ctx context.Context) error {
 	key, err := p.GetKey(ctx)
+	defer wipe(key)
 	if err != nil {
 		return err
 	}
@@ -86,8 +263,212 @@ func (p *FileKeyProvider) HealthCheck(ctx context.Context) error {
 
 func (p *FileKeyProvider) generateKey() error {
 	key := make([]byte, 32)
+	defer wipe(key)
 	if _, err := io.ReadFull(rand.Reader, key); err != nil {
 		return err
 	}
-	return os.WriteFile(p.keyPath, key, 0600)
+	return writeFileAtomic(p.keyPath, key, 0600)
+}
+
+// recover brings the key file and journal back to a consistent idle state:
+// a pending rotation is finished if the new key was installed and rolled back
+// otherwise. Key files from before the journal existed, including a legacy
+// ".bak" backup, are adopted.
+func (p *FileKeyProvider) recover() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	j, err := p.loadJournal()
+	if errors.Is(err, os.ErrNotExist) {
+		return p.adoptLegacy()
+	}
+	if err != nil {
+		return err
+	}
+
+	if j.State == journalPending {
+		current, err := readKeyID(p.keyPath)
+		switch {
+		case err == nil && current == j.Pending:
+			// The new key was installed; only the bookkeeping is missing.
+			return p.commitRotation(j)
+		case err == nil && current == j.Active:
+			// The rename never happened; the old key is still in place.
+			return p.rollbackRotation(j)
+		case err != nil:
+			// The key file is gone or damaged; reinstate the old key from its retired copy.
+			old, rerr := readKeyFile(p.retiredPath(j.Active))
+			if rerr != nil {
+				return fmt.Errorf("key file unreadable (%v) and no retired copy of %s: %w", err, j.Active, rerr)
+			}
+			defer wipe(old)
+			if err := writeFileAtomic(p.keyPath, old, 0600); err != nil {
+				return err
+			}
+			return p.rollbackRotation(j)
+		default:
+			return fmt.Errorf("key file holds %s, expected %s or %s", current, j.Active, j.Pending)
+		}
+	}
+
+	// A pending or retired copy of the active key without a pending journal
+	// means the rotation never got as far as recording itself.
+	if err := os.Remove(p.pendingPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(p.retiredPath(j.Active)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, err = readKeyID(p.keyPath)
+	return err
+}
+
+// commitRotation moves the old key to the retired list, applies the
+// retention policy and marks the journal idle.
+func (p *FileKeyProvider) commitRotation(j keyJournal) error {
+	j.Retired = append([]retiredKey{{ID: j.Active, RetiredAt: time.Now().UTC()}}, j.Retired...)
+	j.State = journalIdle
+	j.Active = j.Pending
+	j.Pending = ""
+
+	j, dropped := p.applyRetention(j)
+	if err := p.saveJournal(j); err != nil {
+		return fmt.Errorf("failed to write rotation journal: %w", err)
+	}
+	for _, r := range dropped {
+		if err := os.Remove(p.retiredPath(r.ID)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove expired key %s: %w", r.ID, err)
+		}
+	}
+	if err := os.Remove(p.pendingPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// rollbackRotation abandons a pending rotation whose new key was never installed.
+func (p *FileKeyProvider) rollbackRotation(j keyJournal) error {
+	j.State = journalIdle
+	j.Pending = ""
+	if err := p.saveJournal(j); err != nil {
+		return fmt.Errorf("failed to write rotation journal: %w", err)
+	}
+	if err := os.Remove(p.pendingPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// The old key stays active, so its retired copy is not needed.
+	if err := os.Remove(p.retiredPath(j.Active)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// applyRetention splits the retired list into kept and dropped keys.
+func (p *FileKeyProvider) applyRetention(j keyJournal) (keyJournal, []retiredKey) {
+	var kept, dropped []retiredKey
+	for i, r := range j.Retired {
+		expired := p.retainAge > 0 && time.Since(r.RetiredAt) > p.retainAge
+		if (p.retainCount > 0 && i >= p.retainCount) || expired {
+			dropped = append(dropped, r)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	j.Retired = kept
+	return j, dropped
+}
+
+// adoptLegacy creates the journal for a key file written before journaling,
+// importing the single ".bak" key left by the old rotation scheme.
+func (p *FileKeyProvider) adoptLegacy() error {
+	current, err := readKeyID(p.keyPath)
+	if err != nil {
+		return err
+	}
+	j := keyJournal{Version: keyJournalVersion, State: journalIdle, Active: current}
+
+	backupPath := p.keyPath + ".bak"
+	backup, err := readKeyFile(backupPath)
+	if err == nil {
+		defer wipe(backup)
+		id := KeyID(backup)
+		if err := writeFileAtomic(p.retiredPath(id), backup, 0600); err != nil {
+			return err
+		}
+		var retiredAt time.Time
+		if info, err := os.Stat(backupPath); err == nil {
+			retiredAt = info.ModTime().UTC()
+		}
+		j.Retired = []retiredKey{{ID: id, RetiredAt: retiredAt}}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := p.saveJournal(j); err != nil {
+		return err
+	}
+	if err := os.Remove(backupPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (p *FileKeyProvider) loadJournal() (keyJournal, error) {
+	data, err := os.ReadFile(p.journalPath())
+	if err != nil {
+		return keyJournal{}, err
+	}
+	var j keyJournal
+	if err := json.Unmarshal(data, &j); err != nil {
+		return keyJournal{}, fmt.Errorf("failed to parse rotation journal: %w", err)
+	}
+	if j.Version != keyJournalVersion {
+		return keyJournal{}, fmt.Errorf("unsupported rotation journal version: %d", j.Version)
+	}
+	return j, nil
+}
+
+func (p *FileKeyProvider) saveJournal(j keyJournal) error {
+	j.Version = keyJournalVersion
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.journalPath(), data, 0600)
+}
+
+func (p *FileKeyProvider) step(name string) error {
+	if rotationStep == nil {
+		return nil
+	}
+	return rotationStep(p, name)
+}
+
+func (p *FileKeyProvider) journalPath() string { return p.keyPath + ".journal" }
+
+func (p *FileKeyProvider) pendingPath() string { return p.keyPath + ".pending" }
+
+func (p *FileKeyProvider) retiredPath(id string) string { return p.keyPath + ".retired-" + id }
+
+// readKeyFile reads a raw 32-byte key.
+func readKeyFile(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if len(key) != 32 {
+		wipe(key)
+		return nil, fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// readKeyID reads a raw key and returns its ID, wiping the key.
+func readKeyID(path string) (string, error) {
+	key, err := readKeyFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer wipe(key)
+	return KeyID(key), nil
 }
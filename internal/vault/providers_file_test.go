@@ -0,0 +1,203 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errSimulatedCrash = errors.New("simulated crash")
+
+// crashRotation installs hook as the rotation step seam until the test ends.
+func crashRotation(t *testing.T, hook func(p *FileKeyProvider, step string) error) {
+	t.Helper()
+	rotationStep = hook
+	t.Cleanup(func() { rotationStep = nil })
+}
+
+func TestFileKeyProvider_RotationRecovery(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		step       string
+		wantNewKey bool // Whether restart should finish the rotation
+	}{
+		{step: "retire"},
+		{step: "stage"},
+		{step: "journal"},
+		{step: "install"},
+		{step: "commit", wantNewKey: true},
+	}
+
+	for _, tt := range tests {
+		t.Run("crash before "+tt.step, func(t *testing.T) {
+			keyPath := filepath.Join(t.TempDir(), "master.key")
+			p, err := NewFileKeyProvider(keyPath)
+			require.NoError(t, err)
+			oldKey, err := p.GetKey(ctx)
+			require.NoError(t, err)
+
+			var newKey []byte
+			crashRotation(t, func(rotating *FileKeyProvider, step string) error {
+				if rotating == p && step == tt.step {
+					if step == "commit" {
+						newKey, _ = os.ReadFile(keyPath)
+					}
+					return errSimulatedCrash
+				}
+				return nil
+			})
+			_, _, err = p.RotateKey(ctx)
+			require.ErrorIs(t, err, errSimulatedCrash)
+
+			// Restart.
+			restarted, err := NewFileKeyProvider(keyPath)
+			require.NoError(t, err)
+			key, err := restarted.GetKey(ctx)
+			require.NoError(t, err)
+			retired, err := restarted.RetiredKeys(ctx)
+			require.NoError(t, err)
+
+			if tt.wantNewKey {
+				require.Equal(t, newKey, key, "installed rotation is finished")
+				require.Equal(t, [][]byte{oldKey}, retired)
+			} else {
+				require.Equal(t, oldKey, key, "uninstalled rotation is rolled back")
+				require.Empty(t, retired)
+			}
+
+			j, err := restarted.loadJournal()
+			require.NoError(t, err)
+			require.Equal(t, journalIdle, j.State)
+			require.NoFileExists(t, keyPath+".pending")
+
+			// The provider is usable after recovery.
+			_, _, err = restarted.RotateKey(ctx)
+			require.NoError(t, err)
+		})
+	}
+
+	t.Run("missing key file is restored from the retired copy", func(t *testing.T) {
+		keyPath := filepath.Join(t.TempDir(), "master.key")
+		p, err := NewFileKeyProvider(keyPath)
+		require.NoError(t, err)
+		oldKey, err := p.GetKey(ctx)
+		require.NoError(t, err)
+
+		crashRotation(t, func(rotating *FileKeyProvider, step string) error {
+			if rotating == p && step == "install" {
+				return errSimulatedCrash
+			}
+			return nil
+		})
+		_, _, err = p.RotateKey(ctx)
+		require.ErrorIs(t, err, errSimulatedCrash)
+		require.NoError(t, os.WriteFile(keyPath, []byte("trunc"), 0600))
+
+		restarted, err := NewFileKeyProvider(keyPath)
+		require.NoError(t, err)
+		key, err := restarted.GetKey(ctx)
+		require.NoError(t, err)
+		require.Equal(t, oldKey, key)
+	})
+}
+
+func TestFileKeyProvider_Retention(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("count", func(t *testing.T) {
+		keyPath := filepath.Join(t.TempDir(), "master.key")
+		p, err := NewFileKeyProvider(keyPath, WithKeyRetention(2, 0))
+		require.NoError(t, err)
+
+		var olds [][]byte
+		for i := 0; i < 4; i++ {
+			oldKey, _, err := p.RotateKey(ctx)
+			require.NoError(t, err)
+			olds = append([][]byte{oldKey}, olds...)
+		}
+
+		retired, err := p.RetiredKeys(ctx)
+		require.NoError(t, err)
+		require.Equal(t, olds[:2], retired, "newest first, oldest dropped")
+
+		files, err := filepath.Glob(keyPath + ".retired-*")
+		require.NoError(t, err)
+		require.Len(t, files, 2, "dropped keys are deleted")
+	})
+
+	t.Run("age", func(t *testing.T) {
+		keyPath := filepath.Join(t.TempDir(), "master.key")
+		p, err := NewFileKeyProvider(keyPath, WithKeyRetention(0, time.Hour))
+		require.NoError(t, err)
+
+		_, _, err = p.RotateKey(ctx)
+		require.NoError(t, err)
+
+		// Age the retired key past the limit.
+		j, err := p.loadJournal()
+		require.NoError(t, err)
+		j.Retired[0].RetiredAt = time.Now().Add(-2 * time.Hour)
+		require.NoError(t, p.saveJournal(j))
+
+		recent, _, err := p.RotateKey(ctx)
+		require.NoError(t, err)
+
+		retired, err := p.RetiredKeys(ctx)
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{recent}, retired)
+	})
+}
+
+func TestFileKeyProvider_AdoptsLegacyBackup(t *testing.T) {
+	ctx := context.Background()
+	keyPath := filepath.Join(t.TempDir(), "master.key")
+
+	current, backup := make([]byte, 32), make([]byte, 32)
+	current[0], backup[0] = 1, 2
+	require.NoError(t, os.WriteFile(keyPath, current, 0600))
+	require.NoError(t, os.WriteFile(keyPath+".bak", backup, 0600))
+
+	p, err := NewFileKeyProvider(keyPath)
+	require.NoError(t, err)
+
+	key, err := p.GetKey(ctx)
+	require.NoError(t, err)
+	require.Equal(t, current, key)
+
+	retired, err := p.RetiredKeys(ctx)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{backup}, retired)
+	require.NoFileExists(t, keyPath+".bak")
+
+	// A later rotation keeps the adopted key instead of overwriting it.
+	_, _, err = p.RotateKey(ctx)
+	require.NoError(t, err)
+	retired, err = p.RetiredKeys(ctx)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{current, backup}, retired)
+}
+
+func TestFileKeyProvider_RestoreKey(t *testing.T) {
+	ctx := context.Background()
+	keyPath := filepath.Join(t.TempDir(), "master.key")
+	p, err := NewFileKeyProvider(keyPath)
+	require.NoError(t, err)
+
+	oldKey, _, err := p.RotateKey(ctx)
+	require.NoError(t, err)
+	require.NoError(t, p.RestoreKey(ctx, oldKey))
+
+	key, err := p.GetKey(ctx)
+	require.NoError(t, err)
+	require.Equal(t, oldKey, key)
+
+	retired, err := p.RetiredKeys(ctx)
+	require.NoError(t, err)
+	require.Empty(t, retired, "restored key is active again, not retired")
+}
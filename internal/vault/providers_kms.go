@@ -0,0 +1,414 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kmsFileVersion is the current format of the KMS key file.
+const kmsFileVersion = 1
+
+// KMSConfig configures a KMSKeyProvider.
+type KMSConfig struct {
+	// Region is the AWS region of the KMS key, e.g. "eu-west-1".
+	Region string
+
+	// KeyID identifies the KMS key by ID, ARN or alias ("alias/cloudmoor").
+	KeyID string
+
+	// Endpoint overrides the KMS endpoint, e.g. for local-kms or a VPC endpoint.
+	// Defaults to https://kms.<region>.amazonaws.com.
+	Endpoint string
+
+	// EncryptionContext is bound to every wrapped key and must match on decrypt.
+	// Defaults to {"cloudmoor:purpose": "master-key"}.
+	EncryptionContext map[string]string
+
+	// Credentials are static access keys. When nil, AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY are used, then the EC2 instance role.
+	Credentials *AWSCredentials
+
+	// MetadataEndpoint overrides the EC2 instance metadata service address.
+	MetadataEndpoint string
+
+	// RotateOnDemand makes RotateKey also rotate the KMS key material
+	// (RotateKeyOnDemand) before issuing the new master key.
+	RotateOnDemand bool
+
+	// TLS configures server verification and optional client certificates.
+	TLS ClientTLSConfig
+
+	// Timeout bounds each request. Defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// kmsFile is the on-disk representation of a KMS-protected key. It only holds
+// KMS ciphertext blobs; the master key never touches disk.
+type kmsFile struct {
+	Version int    `json:"version"`
+	KeyID   string `json:"key_id"`
+
+	// WrappedKey is the master key as a KMS ciphertext blob.
+	WrappedKey []byte `json:"wrapped_key"`
+
+	// Retired holds master keys replaced by rotation, newest first, wrapped the same way.
+	Retired [][]byte `json:"retired,omitempty"`
+}
+
+// KMSKeyProvider keeps the master key wrapped by an AWS KMS key (or any
+// endpoint speaking the KMS JSON API). The master key comes from
+// GenerateDataKey and every GetKey calls Decrypt, so revoking the key policy
+// or disabling the KMS key cuts off access to the secrets.
+//
+// RotateKey issues a new master key with GenerateDataKey and re-encrypts the
+// retired keys with ReEncrypt so every blob uses the KMS key's current
+// material; with RotateOnDemand it first rotates that material.
+type KMSKeyProvider struct {
+	keyPath string
+	cfg     KMSConfig
+	client  *http.Client
+	creds   *awsCredentialSource
+
+	mu sync.Mutex // Serializes key file updates
+}
+
+// NewKMSKeyProvider opens or creates a KMS-wrapped key file. When the file
+// does not exist a new master key is generated through KMS.
+func NewKMSKeyProvider(ctx context.Context, keyPath string, cfg KMSConfig) (*KMSKeyProvider, error) {
+	if cfg.KeyID == "" {
+		return nil, fmt.Errorf("kms: key ID is required")
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("kms: region is required")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://kms." + cfg.Region + ".amazonaws.com"
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	if cfg.EncryptionContext == nil {
+		cfg.EncryptionContext = map[string]string{"cloudmoor:purpose": "master-key"}
+	}
+
+	client, err := newHTTPClient(cfg.TLS, cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("kms: %w", err)
+	}
+	p := &KMSKeyProvider{
+		keyPath: keyPath,
+		cfg:     cfg,
+		client:  client,
+		creds:   &awsCredentialSource{static: cfg.Credentials, imdsEndpoint: cfg.MetadataEndpoint, client: client},
+	}
+
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := removeStaleTempFiles(keyPath); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
+		if err := p.generateKey(ctx); err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		return p, nil
+	}
+
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	if f.KeyID != cfg.KeyID {
+		return nil, fmt.Errorf("kms: key file is wrapped by %q, not %q", f.KeyID, cfg.KeyID)
+	}
+	return p, nil
+}
+
+func (p *KMSKeyProvider) GetKey(ctx context.Context) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	return p.decrypt(ctx, f.WrappedKey)
+}
+
+func (p *KMSKeyProvider) RotateKey(ctx context.Context) (oldKey, newKey []byte, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	oldKey, err = p.decrypt(ctx, f.WrappedKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read old key: %w", err)
+	}
+
+	if p.cfg.RotateOnDemand {
+		if err := p.call(ctx, "RotateKeyOnDemand", map[string]interface{}{"KeyId": p.cfg.KeyID}, nil); err != nil {
+			return nil, nil, fmt.Errorf("failed to rotate kms key: %w", err)
+		}
+	}
+
+	retired := append([][]byte{f.WrappedKey}, f.Retired...)
+	for i, blob := range retired {
+		if retired[i], err = p.reEncrypt(ctx, blob); err != nil {
+			return nil, nil, fmt.Errorf("failed to re-encrypt retired key: %w", err)
+		}
+	}
+
+	newKey, wrapped, err := p.dataKey(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate new key: %w", err)
+	}
+
+	f.WrappedKey = wrapped
+	f.Retired = retired
+	if err := p.save(f); err != nil {
+		return nil, nil, fmt.Errorf("failed to write new key: %w", err)
+	}
+
+	return oldKey, newKey, nil
+}
+
+// RestoreKey reinstates a previous master key after a failed rotation.
+func (p *KMSKeyProvider) RestoreKey(ctx context.Context, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(key))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		CiphertextBlob []byte `json:"CiphertextBlob"`
+	}
+	req := map[string]interface{}{
+		"KeyId":             p.cfg.KeyID,
+		"Plaintext":         key,
+		"EncryptionContext": p.cfg.EncryptionContext,
+	}
+	if err := p.call(ctx, "Encrypt", req, &resp); err != nil {
+		return fmt.Errorf("failed to wrap key: %w", err)
+	}
+	f.WrappedKey = resp.CiphertextBlob
+	if err := p.save(f); err != nil {
+		return fmt.Errorf("failed to restore key: %w", err)
+	}
+	return nil
+}
+
+// RetiredKeys returns master keys replaced by RotateKey, newest first.
+func (p *KMSKeyProvider) RetiredKeys(ctx context.Context) ([][]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, 0, len(f.Retired))
+	for _, blob := range f.Retired {
+		key, err := p.decrypt(ctx, blob)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap retired key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// HealthCheck verifies that the KMS key is enabled and still decrypts the
+// stored master key.
+func (p *KMSKeyProvider) HealthCheck(ctx context.Context) error {
+	var resp struct {
+		KeyMetadata struct {
+			Enabled  bool   `json:"Enabled"`
+			KeyState string `json:"KeyState"`
+		} `json:"KeyMetadata"`
+	}
+	if err := p.call(ctx, "DescribeKey", map[string]interface{}{"KeyId": p.cfg.KeyID}, &resp); err != nil {
+		return err
+	}
+	if !resp.KeyMetadata.Enabled {
+		return fmt.Errorf("kms: key %s is not enabled (state %s)", p.cfg.KeyID, resp.KeyMetadata.KeyState)
+	}
+
+	key, err := p.GetKey(ctx)
+	if err != nil {
+		return err
+	}
+	defer wipe(key)
+	if len(key) != 32 {
+		return fmt.Errorf("invalid key size: %d", len(key))
+	}
+	return nil
+}
+
+// generateKey creates a new key file from a KMS-generated data key.
+func (p *KMSKeyProvider) generateKey(ctx context.Context) error {
+	key, wrapped, err := p.dataKey(ctx)
+	if err != nil {
+		return err
+	}
+	wipe(key)
+	return p.save(kmsFile{
+		Version:    kmsFileVersion,
+		KeyID:      p.cfg.KeyID,
+		WrappedKey: wrapped,
+	})
+}
+
+// load reads the key file. Callers must hold p.mu except during construction.
+func (p *KMSKeyProvider) load() (kmsFile, error) {
+	data, err := os.ReadFile(p.keyPath)
+	if err != nil {
+		return kmsFile{}, fmt.Errorf("failed to read key file: %w", err)
+	}
+	var f kmsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return kmsFile{}, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	if f.Version != kmsFileVersion {
+		return kmsFile{}, fmt.Errorf("unsupported key file version: %d", f.Version)
+	}
+	return f, nil
+}
+
+// save writes the key file atomically.
+func (p *KMSKeyProvider) save(f kmsFile) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.keyPath, data, 0600)
+}
+
+// dataKey asks KMS for a new AES-256 key, returning it in plaintext and wrapped.
+func (p *KMSKeyProvider) dataKey(ctx context.Context) (key, wrapped []byte, err error) {
+	var resp struct {
+		Plaintext      []byte `json:"Plaintext"`
+		CiphertextBlob []byte `json:"CiphertextBlob"`
+	}
+	req := map[string]interface{}{
+		"KeyId":             p.cfg.KeyID,
+		"KeySpec":           "AES_256",
+		"EncryptionContext": p.cfg.EncryptionContext,
+	}
+	if err := p.call(ctx, "GenerateDataKey", req, &resp); err != nil {
+		return nil, nil, err
+	}
+	if len(resp.Plaintext) != 32 {
+		return nil, nil, fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(resp.Plaintext))
+	}
+	return resp.Plaintext, resp.CiphertextBlob, nil
+}
+
+func (p *KMSKeyProvider) decrypt(ctx context.Context, blob []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	req := map[string]interface{}{
+		"KeyId":             p.cfg.KeyID,
+		"CiphertextBlob":    blob,
+		"EncryptionContext": p.cfg.EncryptionContext,
+	}
+	if err := p.call(ctx, "Decrypt", req, &resp); err != nil {
+		return nil, fmt.Errorf("failed to unwrap key: %w", err)
+	}
+	if len(resp.Plaintext) != 32 {
+		return nil, fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(resp.Plaintext))
+	}
+	return resp.Plaintext, nil
+}
+
+func (p *KMSKeyProvider) reEncrypt(ctx context.Context, blob []byte) ([]byte, error) {
+	var resp struct {
+		CiphertextBlob []byte `json:"CiphertextBlob"`
+	}
+	req := map[string]interface{}{
+		"CiphertextBlob":               blob,
+		"SourceKeyId":                  p.cfg.KeyID,
+		"DestinationKeyId":             p.cfg.KeyID,
+		"SourceEncryptionContext":      p.cfg.EncryptionContext,
+		"DestinationEncryptionContext": p.cfg.EncryptionContext,
+	}
+	if err := p.call(ctx, "ReEncrypt", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.CiphertextBlob, nil
+}
+
+// kmsError is the error body returned by the KMS JSON API.
+type kmsError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// call invokes a KMS action over the JSON 1.1 protocol with a SigV4-signed
+// request and decodes the response into out.
+func (p *KMSKeyProvider) call(ctx context.Context, action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	creds, err := p.creds.get(ctx)
+	if err != nil {
+		return fmt.Errorf("kms: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.Endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("kms: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	signSigV4(req, body, creds, p.cfg.Region, "kms", time.Now())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kms: %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("kms: %s: %w", action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var kerr kmsError
+		_ = json.Unmarshal(data, &kerr)
+		// __type may be namespaced, e.g. "com.amazonaws.kms#NotFoundException".
+		if i := strings.LastIndex(kerr.Type, "#"); i >= 0 {
+			kerr.Type = kerr.Type[i+1:]
+		}
+		if kerr.Type == "" {
+			kerr.Type = http.StatusText(resp.StatusCode)
+		}
+		return fmt.Errorf("kms: %s: %s: %s (status %d)", action, kerr.Type, kerr.Message, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("kms: invalid %s response: %w", action, err)
+	}
+	return nil
+}
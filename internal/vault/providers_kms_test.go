@@ -0,0 +1,475 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// kmsStub is a minimal stand-in for a KMS-compatible endpoint such as
+// local-kms. It verifies SigV4 signatures independently of the client and
+// produces ciphertext blobs that name their key and material version.
+type kmsStub struct {
+	mu          sync.Mutex
+	accessKeyID string
+	secretKey   string
+	keys        map[string]*kmsStubKey
+	aliases     map[string]string
+	calls       map[string]int
+}
+
+type kmsStubKey struct {
+	versions [][]byte
+	enabled  bool
+}
+
+func newKMSStub(t *testing.T) (*kmsStub, *httptest.Server) {
+	t.Helper()
+	stub := &kmsStub{
+		accessKeyID: "AKIDEXAMPLE",
+		secretKey:   "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		keys:        map[string]*kmsStubKey{"1234abcd-12ab-34cd-56ef-1234567890ab": {versions: [][]byte{randomKey()}, enabled: true}},
+		aliases:     map[string]string{"alias/cloudmoor": "1234abcd-12ab-34cd-56ef-1234567890ab"},
+		calls:       map[string]int{},
+	}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	return stub, srv
+}
+
+func (s *kmsStub) callCount(action string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[action]
+}
+
+func (s *kmsStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	if err := s.verifySignature(r, body); err != nil {
+		writeKMSError(w, http.StatusBadRequest, "InvalidSignatureException", err.Error())
+		return
+	}
+
+	action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "TrentService.")
+	s.calls[action]++
+
+	var in struct {
+		KeyID                        string            `json:"KeyId"`
+		DestinationKeyID             string            `json:"DestinationKeyId"`
+		KeySpec                      string            `json:"KeySpec"`
+		Plaintext                    []byte            `json:"Plaintext"`
+		CiphertextBlob               []byte            `json:"CiphertextBlob"`
+		EncryptionContext            map[string]string `json:"EncryptionContext"`
+		SourceEncryptionContext      map[string]string `json:"SourceEncryptionContext"`
+		DestinationEncryptionContext map[string]string `json:"DestinationEncryptionContext"`
+	}
+	if err := json.Unmarshal(body, &in); err != nil {
+		writeKMSError(w, http.StatusBadRequest, "SerializationException", err.Error())
+		return
+	}
+
+	resolve := func(keyID string) (string, *kmsStubKey, bool) {
+		if id, ok := s.aliases[keyID]; ok {
+			keyID = id
+		}
+		key, ok := s.keys[keyID]
+		if !ok {
+			writeKMSError(w, http.StatusBadRequest, "NotFoundException", "key not found: "+keyID)
+			return "", nil, false
+		}
+		if !key.enabled && action != "DescribeKey" {
+			writeKMSError(w, http.StatusBadRequest, "DisabledException", "key is disabled")
+			return "", nil, false
+		}
+		return keyID, key, true
+	}
+
+	switch action {
+	case "GenerateDataKey", "Encrypt":
+		id, key, ok := resolve(in.KeyID)
+		if !ok {
+			return
+		}
+		plaintext := in.Plaintext
+		if action == "GenerateDataKey" {
+			if in.KeySpec != "AES_256" {
+				writeKMSError(w, http.StatusBadRequest, "ValidationException", "unsupported key spec")
+				return
+			}
+			plaintext = randomKey()
+		}
+		writeKMS(w, map[string]interface{}{
+			"KeyId":          id,
+			"Plaintext":      plaintext,
+			"CiphertextBlob": kmsStubSeal(id, key, plaintext, in.EncryptionContext),
+		})
+	case "Decrypt", "ReEncrypt":
+		ctx := in.EncryptionContext
+		if action == "ReEncrypt" {
+			ctx = in.SourceEncryptionContext
+		}
+		id, plaintext, err := s.open(in.CiphertextBlob, ctx)
+		if err != nil {
+			writeKMSError(w, http.StatusBadRequest, "InvalidCiphertextException", err.Error())
+			return
+		}
+		if action == "Decrypt" {
+			writeKMS(w, map[string]interface{}{"KeyId": id, "Plaintext": plaintext})
+			return
+		}
+		destID, dest, ok := resolve(in.DestinationKeyID)
+		if !ok {
+			return
+		}
+		writeKMS(w, map[string]interface{}{
+			"KeyId":          destID,
+			"CiphertextBlob": kmsStubSeal(destID, dest, plaintext, in.DestinationEncryptionContext),
+		})
+	case "RotateKeyOnDemand":
+		id, key, ok := resolve(in.KeyID)
+		if !ok {
+			return
+		}
+		key.versions = append(key.versions, randomKey())
+		writeKMS(w, map[string]interface{}{"KeyId": id})
+	case "DescribeKey":
+		id, key, ok := resolve(in.KeyID)
+		if !ok {
+			return
+		}
+		state := "Enabled"
+		if !key.enabled {
+			state = "Disabled"
+		}
+		writeKMS(w, map[string]interface{}{"KeyMetadata": map[string]interface{}{
+			"KeyId": id, "Enabled": key.enabled, "KeyState": state,
+		}})
+	default:
+		writeKMSError(w, http.StatusBadRequest, "UnknownOperationException", action)
+	}
+}
+
+// verifySignature recomputes the SigV4 signature from the headers the client
+// declared as signed.
+func (s *kmsStub) verifySignature(r *http.Request, body []byte) error {
+	auth := r.Header.Get("Authorization")
+	fields := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(auth, sigV4Algorithm+" "), ", ") {
+		if k, v, ok := strings.Cut(part, "="); ok {
+			fields[k] = v
+		}
+	}
+	scope := strings.SplitN(fields["Credential"], "/", 2)
+	if len(scope) != 2 || scope[0] != s.accessKeyID {
+		return fmt.Errorf("unknown access key")
+	}
+
+	var canonical strings.Builder
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonical.WriteString(name + ":" + value + "\n")
+	}
+	payloadHash := sha256.Sum256(body)
+	request := strings.Join([]string{r.Method, r.URL.Path, r.URL.RawQuery, canonical.String(), fields["SignedHeaders"], hex.EncodeToString(payloadHash[:])}, "\n")
+	requestHash := sha256.Sum256([]byte(request))
+	stringToSign := strings.Join([]string{sigV4Algorithm, r.Header.Get("X-Amz-Date"), scope[1], hex.EncodeToString(requestHash[:])}, "\n")
+
+	parts := strings.Split(scope[1], "/")
+	key := sigV4SigningKey(s.secretKey, parts[0], parts[1], parts[2])
+	want := hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+	if !hmac.Equal([]byte(want), []byte(fields["Signature"])) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// kmsStubSeal produces keyID || 0 || version || nonce || ciphertext, with the
+// encryption context as GCM additional data.
+func kmsStubSeal(id string, key *kmsStubKey, plaintext []byte, encCtx map[string]string) []byte {
+	version := len(key.versions)
+	gcm := kmsStubGCM(key.versions[version-1])
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic(err)
+	}
+	blob := append([]byte(id), 0, byte(version))
+	blob = append(blob, nonce...)
+	return gcm.Seal(blob, nonce, plaintext, kmsStubContext(encCtx))
+}
+
+func (s *kmsStub) open(blob []byte, encCtx map[string]string) (string, []byte, error) {
+	sep := bytes.IndexByte(blob, 0)
+	if sep < 0 || len(blob) < sep+2 {
+		return "", nil, fmt.Errorf("malformed ciphertext")
+	}
+	id, version := string(blob[:sep]), int(blob[sep+1])
+	key, ok := s.keys[id]
+	if !ok || version < 1 || version > len(key.versions) {
+		return "", nil, fmt.Errorf("unknown key material")
+	}
+	if !key.enabled {
+		return "", nil, fmt.Errorf("key is disabled")
+	}
+	gcm := kmsStubGCM(key.versions[version-1])
+	rest := blob[sep+2:]
+	if len(rest) < gcm.NonceSize() {
+		return "", nil, fmt.Errorf("malformed ciphertext")
+	}
+	plaintext, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], kmsStubContext(encCtx))
+	if err != nil {
+		return "", nil, fmt.Errorf("encryption context mismatch")
+	}
+	return id, plaintext, nil
+}
+
+func kmsStubGCM(key []byte) cipher.AEAD {
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	return gcm
+}
+
+func kmsStubContext(encCtx map[string]string) []byte {
+	keys := make([]string, 0, len(encCtx))
+	for k := range encCtx {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k + "=" + encCtx[k] + ";")
+	}
+	return []byte(b.String())
+}
+
+func writeKMS(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeKMSError(w http.ResponseWriter, status int, errType, msg string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(kmsError{Type: errType, Message: msg})
+}
+
+func testKMSConfig(stub *kmsStub, srv *httptest.Server) KMSConfig {
+	return KMSConfig{
+		Region:      "us-east-1",
+		KeyID:       "alias/cloudmoor",
+		Endpoint:    srv.URL,
+		Credentials: &AWSCredentials{AccessKeyID: stub.accessKeyID, SecretAccessKey: stub.secretKey},
+	}
+}
+
+func TestSigV4SigningKey(t *testing.T) {
+	// Example from the AWS Signature Version 4 documentation.
+	key := sigV4SigningKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	require.Equal(t, "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d", hex.EncodeToString(key))
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	stub, srv := newKMSStub(t)
+	cfg := testKMSConfig(stub, srv)
+	keyPath := filepath.Join(t.TempDir(), "master.key")
+
+	p, err := NewKMSKeyProvider(ctx, keyPath, cfg)
+	require.NoError(t, err)
+	require.Equal(t, 1, stub.callCount("GenerateDataKey"))
+	require.NoError(t, p.HealthCheck(ctx))
+
+	key, err := p.GetKey(ctx)
+	require.NoError(t, err)
+	require.Len(t, key, 32)
+
+	data, err := os.ReadFile(keyPath)
+	require.NoError(t, err)
+	var f kmsFile
+	require.NoError(t, json.Unmarshal(data, &f))
+	require.NotEmpty(t, f.WrappedKey)
+	require.False(t, bytes.Contains(data, key), "master key must not be on disk")
+
+	reopened, err := NewKMSKeyProvider(ctx, keyPath, cfg)
+	require.NoError(t, err)
+	again, err := reopened.GetKey(ctx)
+	require.NoError(t, err)
+	require.Equal(t, key, again)
+	require.Equal(t, 1, stub.callCount("GenerateDataKey"), "an existing key file is reused")
+
+	t.Run("encryption context must match", func(t *testing.T) {
+		other := cfg
+		other.EncryptionContext = map[string]string{"cloudmoor:purpose": "something-else"}
+		p, err := NewKMSKeyProvider(ctx, keyPath, other)
+		require.NoError(t, err)
+		_, err = p.GetKey(ctx)
+		require.ErrorContains(t, err, "InvalidCiphertextException")
+	})
+
+	t.Run("disabled key fails health check", func(t *testing.T) {
+		stub.mu.Lock()
+		stub.keys[stub.aliases["alias/cloudmoor"]].enabled = false
+		stub.mu.Unlock()
+		defer func() {
+			stub.mu.Lock()
+			stub.keys[stub.aliases["alias/cloudmoor"]].enabled = true
+			stub.mu.Unlock()
+		}()
+
+		err := p.HealthCheck(ctx)
+		require.ErrorContains(t, err, "not enabled")
+	})
+}
+
+func TestKMSKeyProvider_Errors(t *testing.T) {
+	ctx := context.Background()
+	stub, srv := newKMSStub(t)
+
+	t.Run("bad credentials", func(t *testing.T) {
+		cfg := testKMSConfig(stub, srv)
+		cfg.Credentials = &AWSCredentials{AccessKeyID: stub.accessKeyID, SecretAccessKey: "wrong"}
+		_, err := NewKMSKeyProvider(ctx, filepath.Join(t.TempDir(), "master.key"), cfg)
+		require.ErrorContains(t, err, "InvalidSignatureException")
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		cfg := testKMSConfig(stub, srv)
+		cfg.KeyID = "alias/missing"
+		_, err := NewKMSKeyProvider(ctx, filepath.Join(t.TempDir(), "master.key"), cfg)
+		require.ErrorContains(t, err, "NotFoundException")
+	})
+
+	t.Run("key file bound to another key", func(t *testing.T) {
+		keyPath := filepath.Join(t.TempDir(), "master.key")
+		cfg := testKMSConfig(stub, srv)
+		_, err := NewKMSKeyProvider(ctx, keyPath, cfg)
+		require.NoError(t, err)
+
+		cfg.KeyID = "alias/other"
+		_, err = NewKMSKeyProvider(ctx, keyPath, cfg)
+		require.ErrorContains(t, err, `wrapped by "alias/cloudmoor"`)
+	})
+
+	t.Run("missing configuration", func(t *testing.T) {
+		_, err := NewKMSKeyProvider(ctx, "unused", KMSConfig{Region: "us-east-1"})
+		require.Error(t, err)
+		_, err = NewKMSKeyProvider(ctx, "unused", KMSConfig{KeyID: "alias/cloudmoor"})
+		require.Error(t, err)
+	})
+}
+
+func TestKMSKeyProvider_Rotation(t *testing.T) {
+	ctx := context.Background()
+	stub, srv := newKMSStub(t)
+	cfg := testKMSConfig(stub, srv)
+	cfg.RotateOnDemand = true
+	dir := t.TempDir()
+
+	p, err := NewKMSKeyProvider(ctx, filepath.Join(dir, "master.key"), cfg)
+	require.NoError(t, err)
+	store, err := internalStore(NewFileStore(filepath.Join(dir, "secrets.json"), p, nil))
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "token", []byte("value")))
+	oldKey, err := p.GetKey(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Rotate(ctx))
+	require.Equal(t, 1, stub.callCount("RotateKeyOnDemand"))
+	require.Equal(t, 1, stub.callCount("ReEncrypt"))
+
+	newKey, err := p.GetKey(ctx)
+	require.NoError(t, err)
+	require.NotEqual(t, oldKey, newKey)
+
+	retired, err := p.RetiredKeys(ctx)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{oldKey}, retired)
+
+	// Drop the original key material: everything must have moved to version 2.
+	stub.mu.Lock()
+	stub.keys[stub.aliases["alias/cloudmoor"]].versions[0] = randomKey()
+	stub.mu.Unlock()
+
+	require.NoError(t, store.Close())
+	reopened, err := NewFileStore(filepath.Join(dir, "secrets.json"), p, nil)
+	require.NoError(t, err)
+	got, err := reopened.Get(ctx, "token")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), got)
+}
+
+func TestAWSCredentialSource(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv("AWS_ACCESS_KEY_ID", "env-id")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+		t.Setenv("AWS_SESSION_TOKEN", "env-token")
+
+		creds, err := (&awsCredentialSource{client: http.DefaultClient}).get(ctx)
+		require.NoError(t, err)
+		require.Equal(t, AWSCredentials{AccessKeyID: "env-id", SecretAccessKey: "env-secret", SessionToken: "env-token"}, creds)
+	})
+
+	t.Run("instance metadata", func(t *testing.T) {
+		t.Setenv("AWS_ACCESS_KEY_ID", "")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+		var fetches int
+		expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+				_, _ = w.Write([]byte("imds-token"))
+			case r.Header.Get("X-aws-ec2-metadata-token") != "imds-token":
+				w.WriteHeader(http.StatusUnauthorized)
+			case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+				_, _ = w.Write([]byte("cloudmoor-role\n"))
+			case r.URL.Path == "/latest/meta-data/iam/security-credentials/cloudmoor-role":
+				fetches++
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"AccessKeyId": "role-id", "SecretAccessKey": "role-secret", "Token": "role-token", "Expiration": expiration,
+				})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer imds.Close()
+
+		source := &awsCredentialSource{imdsEndpoint: imds.URL, client: imds.Client()}
+		creds, err := source.get(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "role-id", creds.AccessKeyID)
+		require.Equal(t, "role-token", creds.SessionToken)
+		require.True(t, expiration.Equal(creds.Expiration))
+
+		_, err = source.get(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, fetches, "temporary credentials are cached until near expiry")
+	})
+}
@@ -5,47 +5,113 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"sync"
 )
 
-// InMemoryKeyProvider stores the master key in memory (for testing only).
+// InMemoryKeyProvider stores the master key in memory (for testing only). Keys
+// are held in locked memory, and every key it returns is a copy the caller
+// owns and should wipe.
 type InMemoryKeyProvider struct {
-	key []byte
+	mu      sync.Mutex
+	key     *lockedBuffer
+	retired []*lockedBuffer
 }
 
 // NewInMemoryKeyProvider creates a key provider with a random 32-byte key.
 func NewInMemoryKeyProvider() (*InMemoryKeyProvider, error) {
-	key := make([]byte, 32) // AES-256
-	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+	key, err := newLockedBuffer(32) // AES-256
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, key.bytes()); err != nil {
+		key.destroy()
 		return nil, fmt.Errorf("failed to generate key: %w", err)
 	}
 	return &InMemoryKeyProvider{key: key}, nil
 }
 
-// NewInMemoryKeyProviderWithKey creates a key provider with a specific key.
+// NewInMemoryKeyProviderWithKey creates a key provider with a copy of a
+// specific key.
 func NewInMemoryKeyProviderWithKey(key []byte) (*InMemoryKeyProvider, error) {
 	if len(key) != 32 {
 		return nil, fmt.Errorf("key must be 32 bytes for AES-256, got %d", len(key))
 	}
-	return &InMemoryKeyProvider{key: key}, nil
+	buf, err := newLockedBufferFrom(key)
+	if err != nil {
+		return nil, err
+	}
+	return &InMemoryKeyProvider{key: buf}, nil
 }
 
 func (p *InMemoryKeyProvider) GetKey(ctx context.Context) ([]byte, error) {
-	return p.key, nil
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.key.copy(), nil
 }
 
 func (p *InMemoryKeyProvider) RotateKey(ctx context.Context) (oldKey, newKey []byte, err error) {
-	oldKey = p.key
-	newKey = make([]byte, 32)
-	if _, err := io.ReadFull(rand.Reader, newKey); err != nil {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	next, err := newLockedBuffer(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, next.bytes()); err != nil {
+		next.destroy()
 		return nil, nil, fmt.Errorf("failed to generate new key: %w", err)
 	}
-	p.key = newKey
-	return oldKey, newKey, nil
+	previous := p.key
+	p.key = next
+	p.retired = append([]*lockedBuffer{previous}, p.retired...)
+	return previous.copy(), next.copy(), nil
+}
+
+// RestoreKey reinstates a previous master key after a failed rotation. The key
+// it replaces is wiped.
+func (p *InMemoryKeyProvider) RestoreKey(ctx context.Context, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("key must be 32 bytes for AES-256, got %d", len(key))
+	}
+	buf, err := newLockedBufferFrom(key)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key.destroy()
+	p.key = buf
+	return nil
+}
+
+// RetiredKeys returns copies of the keys replaced by RotateKey, newest first.
+func (p *InMemoryKeyProvider) RetiredKeys(ctx context.Context) ([][]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make([][]byte, 0, len(p.retired))
+	for _, k := range p.retired {
+		keys = append(keys, k.copy())
+	}
+	return keys, nil
 }
 
 func (p *InMemoryKeyProvider) HealthCheck(ctx context.Context) error {
-	if len(p.key) != 32 {
-		return fmt.Errorf("invalid key size: %d", len(p.key))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := len(p.key.bytes()); n != 32 {
+		return fmt.Errorf("invalid key size: %d", n)
 	}
 	return nil
 }
+
+// Destroy wipes every key the provider holds. It cannot be used afterwards.
+func (p *InMemoryKeyProvider) Destroy() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key.destroy()
+	for _, k := range p.retired {
+		k.destroy()
+	}
+	p.retired = nil
+}
@@ -0,0 +1,436 @@
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// passphraseFileVersion is the current format of the passphrase key file.
+const passphraseFileVersion = 1
+
+// KDFParams configures the Argon2id key derivation used by PassphraseKeyProvider.
+type KDFParams struct {
+	// Time is the number of passes over memory.
+	Time uint32 `json:"time"`
+
+	// Memory is the amount of memory used, in KiB.
+	Memory uint32 `json:"memory"`
+
+	// Threads is the degree of parallelism.
+	Threads uint8 `json:"threads"`
+}
+
+// DefaultKDFParams returns the RFC 9106 recommended Argon2id parameters for
+// memory-constrained environments (3 passes, 64 MiB, 4 lanes).
+func DefaultKDFParams() KDFParams {
+	return KDFParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+}
+
+// Upper bounds on KDFParams. A key file is read before the passphrase can be
+// checked, so a tampered or mistyped header must not be able to make unlocking
+// allocate unbounded memory or run for hours. Threads is bounded by its type.
+const (
+	maxKDFTime   = 16
+	maxKDFMemory = 4 * 1024 * 1024 // 4 GiB, in KiB
+)
+
+func (p KDFParams) validate() error {
+	if p.Time == 0 || p.Memory < 8*uint32(p.Threads) || p.Threads == 0 {
+		return fmt.Errorf("invalid KDF parameters: time=%d memory=%d threads=%d", p.Time, p.Memory, p.Threads)
+	}
+	if p.Time > maxKDFTime || p.Memory > maxKDFMemory {
+		return fmt.Errorf("KDF parameters too large: time=%d (max %d) memory=%d KiB (max %d)", p.Time, maxKDFTime, p.Memory, maxKDFMemory)
+	}
+	return nil
+}
+
+// passphraseFile is the on-disk representation of a passphrase-protected key.
+// It never contains the passphrase, the derived key or the master key in clear.
+type passphraseFile struct {
+	Version int       `json:"version"`
+	KDF     string    `json:"kdf"`
+	Params  KDFParams `json:"params"`
+	Salt    []byte    `json:"salt"`
+
+	// Check is SHA-256 of the derived check key; it lets a wrong passphrase be
+	// reported as such instead of as a generic decryption failure.
+	Check []byte `json:"check"`
+
+	// WrappedKey is the master key sealed with AES-256-GCM under the derived key.
+	WrappedKey []byte `json:"wrapped_key"`
+
+	// Retired holds master keys replaced by rotation, newest first, wrapped the same way.
+	Retired [][]byte `json:"retired,omitempty"`
+}
+
+// PassphraseKeyProvider protects a random master key with a key derived from a
+// user passphrase via Argon2id. Only the salt, KDF parameters, a passphrase
+// check value and the wrapped master key are stored, so changing the passphrase
+// re-wraps the master key without touching any secrets.
+//
+// The passphrase is kept only until the wrapping key has been derived from it,
+// and the wrapping key is held in locked memory.
+type PassphraseKeyProvider struct {
+	keyPath string
+	params  KDFParams
+
+	mu      sync.Mutex
+	kek     *lockedBuffer // Derived wrapping key for kekSalt
+	kekSalt []byte
+}
+
+// NewPassphraseKeyProvider opens or creates a passphrase-protected key file.
+// A new file is created with a random master key and the given KDF parameters;
+// an existing file uses its stored parameters and must match the passphrase,
+// otherwise ErrInvalidPassphrase is returned. The caller's passphrase is not
+// modified; the provider's own copy is wiped before it returns.
+func NewPassphraseKeyProvider(keyPath string, passphrase []byte, params KDFParams) (*PassphraseKeyProvider, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("%w: passphrase cannot be empty", ErrInvalidPassphrase)
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	locked, err := newLockedBufferFrom(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer locked.destroy()
+
+	p := &PassphraseKeyProvider{keyPath: keyPath, params: params}
+
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := removeStaleTempFiles(keyPath); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
+		if err := p.generateKey(locked.bytes()); err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		return p, nil
+	}
+
+	f, err := p.read()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.unlock(locked.bytes(), f); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *PassphraseKeyProvider) GetKey(ctx context.Context) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	return p.unwrapKey(f.WrappedKey)
+}
+
+func (p *PassphraseKeyProvider) RotateKey(ctx context.Context) (oldKey, newKey []byte, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	oldKey, err = p.unwrapKey(f.WrappedKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read old key: %w", err)
+	}
+
+	newKey = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, newKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate new key: %w", err)
+	}
+	wrapped, err := sealKey(p.kek.bytes(), newKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to wrap new key: %w", err)
+	}
+
+	f.Retired = append([][]byte{f.WrappedKey}, f.Retired...)
+	f.WrappedKey = wrapped
+	if err := p.save(f); err != nil {
+		return nil, nil, fmt.Errorf("failed to write new key: %w", err)
+	}
+
+	return oldKey, newKey, nil
+}
+
+// RestoreKey reinstates a previous master key after a failed rotation.
+func (p *PassphraseKeyProvider) RestoreKey(ctx context.Context, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(key))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return err
+	}
+	wrapped, err := sealKey(p.kek.bytes(), key)
+	if err != nil {
+		return fmt.Errorf("failed to wrap key: %w", err)
+	}
+	f.WrappedKey = wrapped
+	if err := p.save(f); err != nil {
+		return fmt.Errorf("failed to restore key: %w", err)
+	}
+	return nil
+}
+
+// RetiredKeys returns master keys replaced by RotateKey, newest first.
+func (p *PassphraseKeyProvider) RetiredKeys(ctx context.Context) ([][]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, 0, len(f.Retired))
+	for _, wrapped := range f.Retired {
+		key, err := p.unwrapKey(wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap retired key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ChangePassphrase re-wraps the master key (and any retired keys) under a key
+// derived from newPassphrase with a fresh salt. Secrets are not re-encrypted
+// because the master key itself does not change.
+func (p *PassphraseKeyProvider) ChangePassphrase(ctx context.Context, newPassphrase []byte) error {
+	if len(newPassphrase) == 0 {
+		return fmt.Errorf("%w: passphrase cannot be empty", ErrInvalidPassphrase)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return err
+	}
+
+	master, err := p.unwrapKey(f.WrappedKey)
+	if err != nil {
+		return err
+	}
+	defer wipe(master)
+	retired := make([][]byte, 0, len(f.Retired))
+	defer func() {
+		for _, k := range retired {
+			wipe(k)
+		}
+	}()
+	for _, wrapped := range f.Retired {
+		key, err := p.unwrapKey(wrapped)
+		if err != nil {
+			return fmt.Errorf("failed to unwrap retired key: %w", err)
+		}
+		retired = append(retired, key)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	kek, check, err := deriveKeys(newPassphrase, salt, f.Params)
+	if err != nil {
+		return err
+	}
+
+	next := passphraseFile{
+		Version: passphraseFileVersion,
+		KDF:     "argon2id",
+		Params:  f.Params,
+		Salt:    salt,
+		Check:   check,
+	}
+	if next.WrappedKey, err = sealKey(kek.bytes(), master); err != nil {
+		kek.destroy()
+		return fmt.Errorf("failed to wrap key: %w", err)
+	}
+	for _, key := range retired {
+		wrapped, err := sealKey(kek.bytes(), key)
+		if err != nil {
+			kek.destroy()
+			return fmt.Errorf("failed to wrap retired key: %w", err)
+		}
+		next.Retired = append(next.Retired, wrapped)
+	}
+
+	if err := p.save(next); err != nil {
+		kek.destroy()
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	p.kek.destroy()
+	p.kek = kek
+	p.kekSalt = salt
+	return nil
+}
+
+func (p *PassphraseKeyProvider) HealthCheck(ctx context.Context) error {
+	key, err := p.GetKey(ctx)
+	defer wipe(key)
+	if err != nil {
+		return err
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("invalid key size: %d", len(key))
+	}
+	return nil
+}
+
+// generateKey creates a new key file holding a random master key wrapped
+// under a key derived from passphrase.
+func (p *PassphraseKeyProvider) generateKey(passphrase []byte) error {
+	master := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, master); err != nil {
+		return err
+	}
+	defer wipe(master)
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	kek, check, err := deriveKeys(passphrase, salt, p.params)
+	if err != nil {
+		return err
+	}
+
+	wrapped, err := sealKey(kek.bytes(), master)
+	if err != nil {
+		kek.destroy()
+		return err
+	}
+
+	f := passphraseFile{
+		Version:    passphraseFileVersion,
+		KDF:        "argon2id",
+		Params:     p.params,
+		Salt:       salt,
+		Check:      check,
+		WrappedKey: wrapped,
+	}
+	if err := p.save(f); err != nil {
+		kek.destroy()
+		return err
+	}
+	p.kek = kek
+	p.kekSalt = salt
+	return nil
+}
+
+// unlock derives the wrapping key for f from passphrase, verifying the
+// passphrase against the file's check value. It is only called during
+// construction, the one time the passphrase is held.
+func (p *PassphraseKeyProvider) unlock(passphrase []byte, f passphraseFile) error {
+	kek, check, err := deriveKeys(passphrase, f.Salt, f.Params)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(check, f.Check) != 1 {
+		kek.destroy()
+		return ErrInvalidPassphrase
+	}
+	p.kek = kek
+	p.kekSalt = append([]byte(nil), f.Salt...)
+	return nil
+}
+
+// read parses and validates the key file.
+func (p *PassphraseKeyProvider) read() (passphraseFile, error) {
+	data, err := os.ReadFile(p.keyPath)
+	if err != nil {
+		return passphraseFile{}, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var f passphraseFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return passphraseFile{}, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	if f.Version != passphraseFileVersion || f.KDF != "argon2id" {
+		return passphraseFile{}, fmt.Errorf("unsupported key file: version %d, kdf %q", f.Version, f.KDF)
+	}
+	if err := f.Params.validate(); err != nil {
+		return passphraseFile{}, err
+	}
+	return f, nil
+}
+
+// load reads the key file and makes sure it is still wrapped under p.kek. The
+// passphrase is no longer held, so a file re-wrapped by another process under
+// a new salt cannot be opened until the provider is recreated. Callers must
+// hold p.mu.
+func (p *PassphraseKeyProvider) load() (passphraseFile, error) {
+	f, err := p.read()
+	if err != nil {
+		return passphraseFile{}, err
+	}
+	if subtle.ConstantTimeCompare(p.kekSalt, f.Salt) != 1 {
+		return passphraseFile{}, fmt.Errorf("%w: key file was re-wrapped since it was opened", ErrInvalidPassphrase)
+	}
+	return f, nil
+}
+
+// save writes the key file atomically.
+func (p *PassphraseKeyProvider) save(f passphraseFile) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.keyPath, data, 0600)
+}
+
+// unwrapKey opens a master key sealed under the current derived key.
+func (p *PassphraseKeyProvider) unwrapKey(wrapped []byte) ([]byte, error) {
+	key, err := openKey(p.kek.bytes(), wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key: %w", err)
+	}
+	if len(key) != 32 {
+		wipe(key)
+		return nil, fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// deriveKeys stretches a passphrase with Argon2id into a 32-byte
+// key-encryption key, returned in locked memory, and the SHA-256 of a separate
+// 32-byte check key.
+func deriveKeys(passphrase, salt []byte, params KDFParams) (*lockedBuffer, []byte, error) {
+	out := argon2.IDKey(passphrase, salt, params.Time, params.Memory, params.Threads, 64)
+	defer wipe(out)
+	check := sha256.Sum256(out[32:])
+	kek, err := newLockedBufferFrom(out[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+	return kek, check[:], nil
+}
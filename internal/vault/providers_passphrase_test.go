@@ -0,0 +1,152 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testKDFParams keeps Argon2id cheap so tests stay fast.
+var testKDFParams = KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+
+func TestPassphraseKeyProvider(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("reopens with the same passphrase", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "master.key")
+
+		p1, err := NewPassphraseKeyProvider(path, []byte("correct horse"), testKDFParams)
+		require.NoError(t, err)
+		key1, err := p1.GetKey(ctx)
+		require.NoError(t, err)
+		require.Len(t, key1, 32)
+
+		p2, err := NewPassphraseKeyProvider(path, []byte("correct horse"), testKDFParams)
+		require.NoError(t, err)
+		key2, err := p2.GetKey(ctx)
+		require.NoError(t, err)
+		require.Equal(t, key1, key2)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NotContains(t, string(data), "correct horse")
+
+		require.NoError(t, p2.HealthCheck(ctx))
+	})
+
+	t.Run("rejects wrong passphrase", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "master.key")
+
+		_, err := NewPassphraseKeyProvider(path, []byte("right"), testKDFParams)
+		require.NoError(t, err)
+
+		_, err = NewPassphraseKeyProvider(path, []byte("wrong"), testKDFParams)
+		require.ErrorIs(t, err, ErrInvalidPassphrase)
+
+		_, err = NewPassphraseKeyProvider(path, nil, testKDFParams)
+		require.ErrorIs(t, err, ErrInvalidPassphrase)
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "master.key")
+		_, err := NewPassphraseKeyProvider(path, []byte("pw"), KDFParams{})
+		require.ErrorContains(t, err, "invalid KDF parameters")
+		_, err = NewPassphraseKeyProvider(path, []byte("pw"), KDFParams{Time: 17, Memory: 8 * 1024, Threads: 1})
+		require.ErrorContains(t, err, "too large")
+	})
+
+	t.Run("tampered parameters are refused before deriving", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "master.key")
+		_, err := NewPassphraseKeyProvider(path, []byte("pw"), testKDFParams)
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var f map[string]any
+		require.NoError(t, json.Unmarshal(data, &f))
+		f["params"] = map[string]any{"time": 1, "memory": 1 << 30, "threads": 1}
+		data, err = json.Marshal(f)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, data, 0600))
+
+		_, err = NewPassphraseKeyProvider(path, []byte("pw"), testKDFParams)
+		require.ErrorContains(t, err, "too large")
+	})
+
+	t.Run("passphrase change keeps secrets readable", func(t *testing.T) {
+		dir := t.TempDir()
+		keyPath := filepath.Join(dir, "master.key")
+		storePath := filepath.Join(dir, "secrets.json")
+
+		p, err := NewPassphraseKeyProvider(keyPath, []byte("old"), testKDFParams)
+		require.NoError(t, err)
+		store, err := NewFileStore(storePath, p, nil)
+		require.NoError(t, err)
+		require.NoError(t, store.Put(ctx, "token", []byte("value")))
+
+		before, err := os.ReadFile(storePath)
+		require.NoError(t, err)
+
+		require.NoError(t, p.ChangePassphrase(ctx, []byte("new")))
+
+		after, err := os.ReadFile(storePath)
+		require.NoError(t, err)
+		require.Equal(t, before, after, "changing the passphrase must not rewrite secrets")
+
+		_, err = NewPassphraseKeyProvider(keyPath, []byte("old"), testKDFParams)
+		require.ErrorIs(t, err, ErrInvalidPassphrase)
+
+		reopenedProvider, err := NewPassphraseKeyProvider(keyPath, []byte("new"), testKDFParams)
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+		reopened, err := NewFileStore(storePath, reopenedProvider, nil)
+		require.NoError(t, err)
+
+		got, err := reopened.Get(ctx, "token")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), got)
+	})
+
+	t.Run("rotation keeps retired keys", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "master.key")
+		p, err := NewPassphraseKeyProvider(path, []byte("pw"), testKDFParams)
+		require.NoError(t, err)
+
+		oldKey, newKey, err := p.RotateKey(ctx)
+		require.NoError(t, err)
+		require.NotEqual(t, oldKey, newKey)
+
+		current, err := p.GetKey(ctx)
+		require.NoError(t, err)
+		require.Equal(t, newKey, current)
+
+		retired, err := p.RetiredKeys(ctx)
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{oldKey}, retired)
+
+		require.NoError(t, p.RestoreKey(ctx, oldKey))
+		current, err = p.GetKey(ctx)
+		require.NoError(t, err)
+		require.Equal(t, oldKey, current)
+	})
+
+	t.Run("passphrase is not kept after unlocking", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "master.key")
+		passphrase := []byte("pw")
+		p1, err := NewPassphraseKeyProvider(path, passphrase, testKDFParams)
+		require.NoError(t, err)
+		require.Equal(t, []byte("pw"), passphrase, "the caller's copy is left alone")
+		p2, err := NewPassphraseKeyProvider(path, passphrase, testKDFParams)
+		require.NoError(t, err)
+
+		require.NoError(t, p1.ChangePassphrase(ctx, []byte("new")))
+		_, err = p2.GetKey(ctx)
+		require.ErrorIs(t, err, ErrInvalidPassphrase, "p2 cannot derive the new wrapping key")
+		_, err = p1.GetKey(ctx)
+		require.NoError(t, err)
+	})
+}
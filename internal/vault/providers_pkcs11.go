@@ -0,0 +1,451 @@
+//go:build pkcs11
+
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// pkcs11WrapAD is authenticated with every wrapped master key.
+var pkcs11WrapAD = []byte("cloudmoor-master-key")
+
+// PKCS11KeyProvider keeps the master key wrapped by an AES key that never
+// leaves an HSM. Wrapping and unwrapping run inside the token with AES-GCM
+// through the PKCS#11 module; only the wrapped blobs are stored on disk.
+//
+// HealthCheck opens a fresh session and performs a login probe, so an expired
+// PIN, a removed token or a dead HSM connection is reported as unhealthy.
+type PKCS11KeyProvider struct {
+	keyPath string
+	cfg     PKCS11Config
+
+	mu   sync.Mutex // Serializes HSM calls and key file updates
+	ctx  *pkcs11.Ctx
+	slot uint
+}
+
+// NewPKCS11KeyProvider loads the PKCS#11 module, locates the token and the
+// wrapping key, and opens or creates the key file. Call Close to unload the module.
+func NewPKCS11KeyProvider(keyPath string, cfg PKCS11Config) (*PKCS11KeyProvider, error) {
+	if cfg.ModulePath == "" {
+		return nil, fmt.Errorf("pkcs11: module path is required")
+	}
+	if cfg.KeyLabel == "" {
+		return nil, fmt.Errorf("pkcs11: key label is required")
+	}
+
+	ctx := pkcs11.New(cfg.ModulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("pkcs11: failed to load module %s", cfg.ModulePath)
+	}
+	if err := ctx.Initialize(); err != nil && !isPKCS11Error(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("pkcs11: failed to initialize module: %w", err)
+	}
+
+	p := &PKCS11KeyProvider{keyPath: keyPath, cfg: cfg, ctx: ctx}
+	if err := p.init(); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *PKCS11KeyProvider) init() error {
+	slot, err := p.findSlot()
+	if err != nil {
+		return err
+	}
+	p.slot = slot
+
+	err = p.withSession(func(session pkcs11.SessionHandle) error {
+		_, err := p.findKey(session)
+		if errors.Is(err, errPKCS11KeyNotFound) && p.cfg.GenerateKey {
+			_, err = p.generateWrappingKey(session)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p.keyPath), 0700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := removeStaleTempFiles(p.keyPath); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(p.keyPath); os.IsNotExist(err) {
+		if err := p.generateKey(); err != nil {
+			return fmt.Errorf("failed to generate key: %w", err)
+		}
+		return nil
+	}
+
+	f, err := p.load()
+	if err != nil {
+		return err
+	}
+	if f.KeyLabel != p.cfg.KeyLabel {
+		return fmt.Errorf("pkcs11: key file is wrapped by %q, not %q", f.KeyLabel, p.cfg.KeyLabel)
+	}
+	return nil
+}
+
+// Close releases the PKCS#11 module.
+func (p *PKCS11KeyProvider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ctx == nil {
+		return nil
+	}
+	err := p.ctx.Finalize()
+	p.ctx.Destroy()
+	p.ctx = nil
+	return err
+}
+
+func (p *PKCS11KeyProvider) GetKey(ctx context.Context) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	return p.unwrapKey(f.WrappedKey)
+}
+
+func (p *PKCS11KeyProvider) RotateKey(ctx context.Context) (oldKey, newKey []byte, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	oldKey, err = p.unwrapKey(f.WrappedKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read old key: %w", err)
+	}
+
+	newKey = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, newKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate new key: %w", err)
+	}
+	wrapped, err := p.wrapKey(newKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to wrap new key: %w", err)
+	}
+
+	f.Retired = append([][]byte{f.WrappedKey}, f.Retired...)
+	f.WrappedKey = wrapped
+	if err := p.save(f); err != nil {
+		return nil, nil, fmt.Errorf("failed to write new key: %w", err)
+	}
+
+	return oldKey, newKey, nil
+}
+
+// RestoreKey reinstates a previous master key after a failed rotation.
+func (p *PKCS11KeyProvider) RestoreKey(ctx context.Context, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(key))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return err
+	}
+	wrapped, err := p.wrapKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to wrap key: %w", err)
+	}
+	f.WrappedKey = wrapped
+	if err := p.save(f); err != nil {
+		return fmt.Errorf("failed to restore key: %w", err)
+	}
+	return nil
+}
+
+// RetiredKeys returns master keys replaced by RotateKey, newest first.
+func (p *PKCS11KeyProvider) RetiredKeys(ctx context.Context) ([][]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, 0, len(f.Retired))
+	for _, wrapped := range f.Retired {
+		key, err := p.unwrapKey(wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap retired key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// HealthCheck probes the token with a fresh session and login, checks that
+// the wrapping key is present, and verifies it still unwraps the master key.
+func (p *PKCS11KeyProvider) HealthCheck(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ctx == nil {
+		return fmt.Errorf("pkcs11: provider is closed")
+	}
+	info, err := p.ctx.GetTokenInfo(p.slot)
+	if err != nil {
+		return fmt.Errorf("pkcs11: token unavailable in slot %d: %w", p.slot, err)
+	}
+	if info.Flags&pkcs11.CKF_USER_PIN_LOCKED != 0 {
+		return fmt.Errorf("pkcs11: user PIN is locked on token %q", strings.TrimSpace(info.Label))
+	}
+
+	if err := p.withSession(func(session pkcs11.SessionHandle) error {
+		_, err := p.findKey(session)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	f, err := p.load()
+	if err != nil {
+		return err
+	}
+	key, err := p.unwrapKey(f.WrappedKey)
+	if err != nil {
+		return err
+	}
+	wipe(key)
+	return nil
+}
+
+// generateKey creates a new key file holding a random master key.
+func (p *PKCS11KeyProvider) generateKey() error {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	defer wipe(key)
+
+	wrapped, err := p.wrapKey(key)
+	if err != nil {
+		return err
+	}
+	return p.save(pkcs11File{
+		Version:    pkcs11FileVersion,
+		KeyLabel:   p.cfg.KeyLabel,
+		WrappedKey: wrapped,
+	})
+}
+
+// load reads the key file. Callers must hold p.mu except during construction.
+func (p *PKCS11KeyProvider) load() (pkcs11File, error) {
+	data, err := os.ReadFile(p.keyPath)
+	if err != nil {
+		return pkcs11File{}, fmt.Errorf("failed to read key file: %w", err)
+	}
+	var f pkcs11File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return pkcs11File{}, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	if f.Version != pkcs11FileVersion {
+		return pkcs11File{}, fmt.Errorf("unsupported key file version: %d", f.Version)
+	}
+	return f, nil
+}
+
+// save writes the key file atomically.
+func (p *PKCS11KeyProvider) save(f pkcs11File) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.keyPath, data, 0600)
+}
+
+// wrapKey encrypts key inside the HSM. Returns: IV || ciphertext || tag
+func (p *PKCS11KeyProvider) wrapKey(key []byte) ([]byte, error) {
+	iv := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+
+	var wrapped []byte
+	err := p.withSession(func(session pkcs11.SessionHandle) error {
+		handle, err := p.findKey(session)
+		if err != nil {
+			return err
+		}
+		params := pkcs11.NewGCMParams(iv, pkcs11WrapAD, 128)
+		defer params.Free()
+		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+		if err := p.ctx.EncryptInit(session, mech, handle); err != nil {
+			return fmt.Errorf("pkcs11: encrypt init: %w", err)
+		}
+		ciphertext, err := p.ctx.Encrypt(session, key)
+		if err != nil {
+			return fmt.Errorf("pkcs11: encrypt: %w", err)
+		}
+		// Some modules substitute their own IV; store the one actually used.
+		wrapped = append(params.IV(), ciphertext...)
+		return nil
+	})
+	return wrapped, err
+}
+
+// unwrapKey decrypts a key wrapped by wrapKey inside the HSM.
+func (p *PKCS11KeyProvider) unwrapKey(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 12 {
+		return nil, fmt.Errorf("failed to unwrap key: wrapped key too short")
+	}
+	iv, ciphertext := wrapped[:12], wrapped[12:]
+
+	var key []byte
+	err := p.withSession(func(session pkcs11.SessionHandle) error {
+		handle, err := p.findKey(session)
+		if err != nil {
+			return err
+		}
+		params := pkcs11.NewGCMParams(iv, pkcs11WrapAD, 128)
+		defer params.Free()
+		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+		if err := p.ctx.DecryptInit(session, mech, handle); err != nil {
+			return fmt.Errorf("pkcs11: decrypt init: %w", err)
+		}
+		key, err = p.ctx.Decrypt(session, ciphertext)
+		if err != nil {
+			return fmt.Errorf("pkcs11: decrypt: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// withSession runs fn in a new logged-in session. Callers must hold p.mu
+// except during construction.
+func (p *PKCS11KeyProvider) withSession(fn func(pkcs11.SessionHandle) error) error {
+	if p.ctx == nil {
+		return fmt.Errorf("pkcs11: provider is closed")
+	}
+	session, err := p.ctx.OpenSession(p.slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return fmt.Errorf("pkcs11: failed to open session on slot %d: %w", p.slot, err)
+	}
+	defer p.ctx.CloseSession(session)
+
+	// Login state is shared by all sessions of the application.
+	if err := p.ctx.Login(session, pkcs11.CKU_USER, p.cfg.PIN); err != nil && !isPKCS11Error(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		return fmt.Errorf("pkcs11: login failed: %w", err)
+	}
+	return fn(session)
+}
+
+// errPKCS11KeyNotFound reports that no wrapping key carries the configured label.
+var errPKCS11KeyNotFound = errors.New("pkcs11: wrapping key not found")
+
+func (p *PKCS11KeyProvider) findKey(session pkcs11.SessionHandle) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, p.cfg.KeyLabel),
+	}
+	if err := p.ctx.FindObjectsInit(session, template); err != nil {
+		return 0, fmt.Errorf("pkcs11: find key: %w", err)
+	}
+	handles, _, err := p.ctx.FindObjects(session, 2)
+	if finalErr := p.ctx.FindObjectsFinal(session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("pkcs11: find key: %w", err)
+	}
+	switch len(handles) {
+	case 0:
+		return 0, fmt.Errorf("%w: label %q", errPKCS11KeyNotFound, p.cfg.KeyLabel)
+	case 1:
+		return handles[0], nil
+	default:
+		return 0, fmt.Errorf("pkcs11: multiple AES keys labelled %q", p.cfg.KeyLabel)
+	}
+}
+
+// generateWrappingKey creates a persistent, non-extractable AES-256 key on the token.
+func (p *PKCS11KeyProvider) generateWrappingKey(session pkcs11.SessionHandle) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, p.cfg.KeyLabel),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+	}
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}
+	handle, err := p.ctx.GenerateKey(session, mech, template)
+	if err != nil {
+		return 0, fmt.Errorf("pkcs11: failed to generate wrapping key: %w", err)
+	}
+	return handle, nil
+}
+
+// findSlot resolves the configured token label or slot ID.
+func (p *PKCS11KeyProvider) findSlot() (uint, error) {
+	slots, err := p.ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("pkcs11: failed to list slots: %w", err)
+	}
+	for _, slot := range slots {
+		if p.cfg.TokenLabel == "" {
+			if slot == p.cfg.Slot {
+				return slot, nil
+			}
+			continue
+		}
+		info, err := p.ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(info.Label) == p.cfg.TokenLabel {
+			return slot, nil
+		}
+	}
+	if p.cfg.TokenLabel != "" {
+		return 0, fmt.Errorf("pkcs11: no token labelled %q", p.cfg.TokenLabel)
+	}
+	return 0, fmt.Errorf("pkcs11: no token in slot %d", p.cfg.Slot)
+}
+
+func isPKCS11Error(err error, code uint) bool {
+	var perr pkcs11.Error
+	return errors.As(err, &perr) && uint(perr) == code
+}
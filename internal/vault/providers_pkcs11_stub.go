@@ -0,0 +1,37 @@
+//go:build !pkcs11
+
+package vault
+
+import (
+	"context"
+	"errors"
+)
+
+// errPKCS11Unsupported is returned when the binary was built without the
+// pkcs11 build tag (which requires cgo).
+var errPKCS11Unsupported = errors.New("pkcs11: support not compiled in (build with -tags pkcs11)")
+
+// PKCS11KeyProvider is unavailable in builds without the pkcs11 tag.
+type PKCS11KeyProvider struct{}
+
+// NewPKCS11KeyProvider always fails in builds without the pkcs11 tag.
+func NewPKCS11KeyProvider(keyPath string, cfg PKCS11Config) (*PKCS11KeyProvider, error) {
+	return nil, errPKCS11Unsupported
+}
+
+func (p *PKCS11KeyProvider) GetKey(ctx context.Context) ([]byte, error) {
+	return nil, errPKCS11Unsupported
+}
+
+func (p *PKCS11KeyProvider) RotateKey(ctx context.Context) (oldKey, newKey []byte, err error) {
+	return nil, nil, errPKCS11Unsupported
+}
+
+func (p *PKCS11KeyProvider) HealthCheck(ctx context.Context) error {
+	return errPKCS11Unsupported
+}
+
+// Close is a no-op in builds without the pkcs11 tag.
+func (p *PKCS11KeyProvider) Close() error {
+	return nil
+}
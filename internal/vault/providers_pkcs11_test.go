@@ -0,0 +1,105 @@
+//go:build pkcs11
+
+package vault
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newSoftHSMToken initializes a fresh SoftHSMv2 token in a temporary
+// directory. Set SOFTHSM2_MODULE to the libsofthsm2.so path to run these tests.
+func newSoftHSMToken(t *testing.T) PKCS11Config {
+	t.Helper()
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		t.Skip("SOFTHSM2_MODULE not set")
+	}
+	if _, err := exec.LookPath("softhsm2-util"); err != nil {
+		t.Skip("softhsm2-util not installed")
+	}
+
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens")
+	require.NoError(t, os.Mkdir(tokens, 0700))
+	conf := filepath.Join(dir, "softhsm2.conf")
+	require.NoError(t, os.WriteFile(conf, []byte(fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\n", tokens)), 0600))
+	t.Setenv("SOFTHSM2_CONF", conf)
+
+	out, err := exec.Command("softhsm2-util", "--init-token", "--free", "--label", "cloudmoor", "--pin", "1234", "--so-pin", "0000").CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	return PKCS11Config{
+		ModulePath:  module,
+		TokenLabel:  "cloudmoor",
+		PIN:         "1234",
+		KeyLabel:    "cloudmoor-wrap",
+		GenerateKey: true,
+	}
+}
+
+func TestPKCS11KeyProvider(t *testing.T) {
+	ctx := context.Background()
+	cfg := newSoftHSMToken(t)
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "master.key")
+
+	p, err := NewPKCS11KeyProvider(keyPath, cfg)
+	require.NoError(t, err)
+	require.NoError(t, p.HealthCheck(ctx))
+
+	key, err := p.GetKey(ctx)
+	require.NoError(t, err)
+	require.Len(t, key, 32)
+
+	store, err := NewFileStore(filepath.Join(dir, "secrets.json"), p, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "token", []byte("value")))
+	require.NoError(t, store.Rotate(ctx))
+
+	retired, err := p.RetiredKeys(ctx)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{key}, retired)
+	require.NoError(t, p.Close())
+	require.NoError(t, store.Close())
+
+	t.Run("reopen reads existing key", func(t *testing.T) {
+		reopened, err := NewPKCS11KeyProvider(keyPath, cfg)
+		require.NoError(t, err)
+		defer reopened.Close()
+
+		store, err := NewFileStore(filepath.Join(dir, "secrets.json"), reopened, nil)
+		require.NoError(t, err)
+		got, err := store.Get(ctx, "token")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), got)
+	})
+
+	t.Run("wrong PIN", func(t *testing.T) {
+		bad := cfg
+		bad.PIN = "9999"
+		_, err := NewPKCS11KeyProvider(keyPath, bad)
+		require.ErrorContains(t, err, "login failed")
+	})
+
+	t.Run("missing wrapping key", func(t *testing.T) {
+		missing := cfg
+		missing.KeyLabel = "absent"
+		missing.GenerateKey = false
+		_, err := NewPKCS11KeyProvider(filepath.Join(t.TempDir(), "master.key"), missing)
+		require.ErrorIs(t, err, errPKCS11KeyNotFound)
+	})
+
+	t.Run("health check fails once closed", func(t *testing.T) {
+		closed, err := NewPKCS11KeyProvider(keyPath, cfg)
+		require.NoError(t, err)
+		require.NoError(t, closed.Close())
+		require.Error(t, closed.HealthCheck(ctx))
+	})
+}
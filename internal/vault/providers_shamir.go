@@ -0,0 +1,556 @@
+package vault
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// shamirFileVersion is the current format of the Shamir key file.
+const shamirFileVersion = 1
+
+// shamirCheckLabel is MACed with the unseal key to verify reconstructed keys.
+var shamirCheckLabel = []byte("cloudmoor-shamir-check")
+
+// shamirFile is the on-disk representation of a Shamir-protected master key.
+// It holds no shares; only the master key wrapped by the unseal key they form.
+type shamirFile struct {
+	Version   int `json:"version"`
+	Shares    int `json:"shares"`
+	Threshold int `json:"threshold"`
+
+	// Check is HMAC-SHA256(unseal key, shamirCheckLabel), used to reject
+	// share combinations that do not reconstruct the unseal key.
+	Check []byte `json:"check"`
+
+	// WrappedKey is the master key sealed with AES-256-GCM under the unseal key.
+	WrappedKey []byte `json:"wrapped_key"`
+
+	// Retired holds master keys replaced by rotation, newest first, wrapped the same way.
+	Retired [][]byte `json:"retired,omitempty"`
+}
+
+// ShamirProgress reports how far an unseal or re-key ceremony has got.
+type ShamirProgress struct {
+	Submitted int `json:"submitted"`
+	Threshold int `json:"threshold"`
+}
+
+// Complete reports whether enough shares have been submitted.
+func (p ShamirProgress) Complete() bool {
+	return p.Submitted >= p.Threshold
+}
+
+// ShamirKeyProvider protects the master key with an unseal key that is split
+// into N Shamir shares, any M of which are needed to reconstruct it. No single
+// operator holds enough to recover the master key alone.
+//
+// The provider starts sealed: GetKey fails until SubmitShare has received the
+// threshold number of shares. Submitted shares are held only in this process's
+// memory, never written out, so the provider must outlive the ceremony. A
+// store attached with Attach is unsealed when the ceremony completes and
+// sealed with the provider. A re-key ceremony (BeginRekey, SubmitRekeyShare)
+// issues a fresh set of shares for a new unseal key without changing the master
+// key, so no secrets need to be re-encrypted.
+type ShamirKeyProvider struct {
+	keyPath string
+
+	mu        sync.Mutex
+	unsealKey *lockedBuffer // Reconstructed unseal key; nil while sealed
+	pending   [][]byte      // Shares submitted toward unsealing
+	store     Sealer        // Store driven by unseal and seal, if attached
+
+	rekeyShares    int
+	rekeyThreshold int
+	rekeyPending   [][]byte // Current shares submitted to authorize a re-key
+}
+
+// InitShamirKeyProvider creates a new key file holding a random master key and
+// returns the provider (already unsealed) together with the generated shares.
+// The shares are never written to disk; distribute them to operators.
+func InitShamirKeyProvider(keyPath string, shares, threshold int) (*ShamirKeyProvider, [][]byte, error) {
+	if _, err := os.Stat(keyPath); err == nil {
+		return nil, nil, fmt.Errorf("key file already exists: %s", keyPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+
+	master := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, master); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	defer wipe(master)
+
+	unsealKey, parts, err := newUnsealKey(shares, threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := sealKey(unsealKey.bytes(), master)
+	if err != nil {
+		unsealKey.destroy()
+		return nil, nil, fmt.Errorf("failed to wrap key: %w", err)
+	}
+
+	p := &ShamirKeyProvider{keyPath: keyPath, unsealKey: unsealKey}
+	f := shamirFile{
+		Version:    shamirFileVersion,
+		Shares:     shares,
+		Threshold:  threshold,
+		Check:      shamirCheck(unsealKey.bytes()),
+		WrappedKey: wrapped,
+	}
+	if err := p.save(f); err != nil {
+		unsealKey.destroy()
+		return nil, nil, fmt.Errorf("failed to write key file: %w", err)
+	}
+	return p, parts, nil
+}
+
+// NewShamirKeyProvider opens an existing key file in the sealed state.
+func NewShamirKeyProvider(keyPath string) (*ShamirKeyProvider, error) {
+	p := &ShamirKeyProvider{keyPath: keyPath}
+	if err := removeStaleTempFiles(keyPath); err != nil {
+		return nil, err
+	}
+	if _, err := p.load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Sealer is the part of a Store that a ShamirKeyProvider seals and unseals.
+type Sealer interface {
+	Seal(ctx context.Context) error
+	Unseal(ctx context.Context) error
+}
+
+// Attach makes the provider drive store: completing an unseal ceremony unseals
+// it, and Seal seals it before the unseal key is forgotten.
+func (p *ShamirKeyProvider) Attach(store Sealer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.store = store
+}
+
+// SubmitShare adds one operator's share toward unsealing. Once the threshold
+// is reached the shares are combined and verified, and the attached store, if
+// any, is unsealed. On failure all submitted shares are discarded and the
+// ceremony must start over.
+func (p *ShamirKeyProvider) SubmitShare(ctx context.Context, share []byte) (ShamirProgress, error) {
+	progress, unsealed, err := p.submitShare(share)
+	if err != nil || !unsealed {
+		return progress, err
+	}
+
+	p.mu.Lock()
+	store := p.store
+	p.mu.Unlock()
+	if store != nil {
+		if err := store.Unseal(ctx); err != nil {
+			return progress, fmt.Errorf("shares accepted but the store failed to unseal: %w", err)
+		}
+	}
+	return progress, nil
+}
+
+// submitShare records share and reports whether it completed the ceremony.
+func (p *ShamirKeyProvider) submitShare(share []byte) (ShamirProgress, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return ShamirProgress{}, false, err
+	}
+	if p.unsealKey != nil {
+		return ShamirProgress{Submitted: f.Threshold, Threshold: f.Threshold}, false, nil
+	}
+
+	pending, unsealKey, err := collectShare(p.pending, share, f)
+	if err != nil {
+		p.resetUnsealLocked()
+		return ShamirProgress{Threshold: f.Threshold}, false, err
+	}
+	p.pending = pending
+	progress := ShamirProgress{Submitted: len(pending), Threshold: f.Threshold}
+	if unsealKey == nil {
+		return progress, false, nil
+	}
+	p.resetUnsealLocked()
+	p.unsealKey = unsealKey
+	return progress, true, nil
+}
+
+// ResetUnseal discards shares submitted toward unsealing.
+func (p *ShamirKeyProvider) ResetUnseal() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resetUnsealLocked()
+}
+
+// Seal seals the attached store, if any, then forgets the reconstructed
+// unseal key and any pending shares.
+func (p *ShamirKeyProvider) Seal(ctx context.Context) error {
+	p.mu.Lock()
+	store := p.store
+	p.mu.Unlock()
+
+	var err error
+	if store != nil {
+		err = store.Seal(ctx)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resetUnsealLocked()
+	if p.unsealKey != nil {
+		p.unsealKey.destroy()
+		p.unsealKey = nil
+	}
+	return err
+}
+
+// Sealed reports whether the unseal key still needs to be reconstructed.
+func (p *ShamirKeyProvider) Sealed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.unsealKey == nil
+}
+
+// BeginRekey starts a ceremony that replaces the unseal key and issues a new
+// set of shares with the given configuration. The current threshold of
+// existing shares must then be submitted through SubmitRekeyShare.
+func (p *ShamirKeyProvider) BeginRekey(shares, threshold int) error {
+	if threshold < 2 || threshold > shares || shares > 255 {
+		return fmt.Errorf("invalid share configuration: %d of %d (need 2 <= threshold <= shares <= 255)", threshold, shares)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resetRekeyLocked()
+	p.rekeyShares = shares
+	p.rekeyThreshold = threshold
+	return nil
+}
+
+// SubmitRekeyShare adds one current share toward authorizing the re-key. When
+// the threshold is reached the master key is re-wrapped under a new unseal key
+// and the new shares are returned; the old shares stop working immediately.
+func (p *ShamirKeyProvider) SubmitRekeyShare(share []byte) (ShamirProgress, [][]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rekeyShares == 0 {
+		return ShamirProgress{}, nil, fmt.Errorf("no re-key in progress")
+	}
+	f, err := p.load()
+	if err != nil {
+		return ShamirProgress{}, nil, err
+	}
+
+	pending, oldUnsealKey, err := collectShare(p.rekeyPending, share, f)
+	if err != nil {
+		p.resetRekeyLocked()
+		return ShamirProgress{Threshold: f.Threshold}, nil, err
+	}
+	p.rekeyPending = pending
+	progress := ShamirProgress{Submitted: len(pending), Threshold: f.Threshold}
+	if oldUnsealKey == nil {
+		return progress, nil, nil
+	}
+	defer oldUnsealKey.destroy()
+
+	newKey, parts, err := newUnsealKey(p.rekeyShares, p.rekeyThreshold)
+	if err != nil {
+		p.resetRekeyLocked()
+		return progress, nil, err
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			newKey.destroy()
+		}
+	}()
+
+	next := shamirFile{
+		Version:   shamirFileVersion,
+		Shares:    p.rekeyShares,
+		Threshold: p.rekeyThreshold,
+		Check:     shamirCheck(newKey.bytes()),
+	}
+	if next.WrappedKey, err = rewrapKey(oldUnsealKey.bytes(), newKey.bytes(), f.WrappedKey); err != nil {
+		p.resetRekeyLocked()
+		return progress, nil, err
+	}
+	for _, wrapped := range f.Retired {
+		rewrapped, err := rewrapKey(oldUnsealKey.bytes(), newKey.bytes(), wrapped)
+		if err != nil {
+			p.resetRekeyLocked()
+			return progress, nil, fmt.Errorf("retired key: %w", err)
+		}
+		next.Retired = append(next.Retired, rewrapped)
+	}
+
+	if err := p.save(next); err != nil {
+		p.resetRekeyLocked()
+		return progress, nil, fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	p.resetRekeyLocked()
+	if p.unsealKey != nil {
+		p.unsealKey.destroy()
+		p.unsealKey = newKey
+		keep = true
+	}
+	return progress, parts, nil
+}
+
+// CancelRekey abandons a re-key ceremony in progress.
+func (p *ShamirKeyProvider) CancelRekey() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resetRekeyLocked()
+}
+
+func (p *ShamirKeyProvider) GetKey(ctx context.Context) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	return p.unwrapLocked(f.WrappedKey)
+}
+
+func (p *ShamirKeyProvider) RotateKey(ctx context.Context) (oldKey, newKey []byte, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	oldKey, err = p.unwrapLocked(f.WrappedKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read old key: %w", err)
+	}
+
+	newKey = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, newKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate new key: %w", err)
+	}
+	wrapped, err := sealKey(p.unsealKey.bytes(), newKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to wrap new key: %w", err)
+	}
+
+	f.Retired = append([][]byte{f.WrappedKey}, f.Retired...)
+	f.WrappedKey = wrapped
+	if err := p.save(f); err != nil {
+		return nil, nil, fmt.Errorf("failed to write new key: %w", err)
+	}
+	return oldKey, newKey, nil
+}
+
+// RestoreKey reinstates a previous master key after a failed rotation.
+func (p *ShamirKeyProvider) RestoreKey(ctx context.Context, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(key))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.unsealKey == nil {
+		return p.sealedError()
+	}
+	f, err := p.load()
+	if err != nil {
+		return err
+	}
+	wrapped, err := sealKey(p.unsealKey.bytes(), key)
+	if err != nil {
+		return fmt.Errorf("failed to wrap key: %w", err)
+	}
+	f.WrappedKey = wrapped
+	if err := p.save(f); err != nil {
+		return fmt.Errorf("failed to restore key: %w", err)
+	}
+	return nil
+}
+
+// RetiredKeys returns master keys replaced by RotateKey, newest first.
+func (p *ShamirKeyProvider) RetiredKeys(ctx context.Context) ([][]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, 0, len(f.Retired))
+	for _, wrapped := range f.Retired {
+		key, err := p.unwrapLocked(wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap retired key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (p *ShamirKeyProvider) HealthCheck(ctx context.Context) error {
+	key, err := p.GetKey(ctx)
+	defer wipe(key)
+	if err != nil {
+		return err
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("invalid key size: %d", len(key))
+	}
+	return nil
+}
+
+// unwrapLocked opens a wrapped master key. Callers must hold p.mu.
+func (p *ShamirKeyProvider) unwrapLocked(wrapped []byte) ([]byte, error) {
+	if p.unsealKey == nil {
+		return nil, p.sealedError()
+	}
+	key, err := openKey(p.unsealKey.bytes(), wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key: %w", err)
+	}
+	if len(key) != 32 {
+		wipe(key)
+		return nil, fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// sealedError reports how many shares are still missing. Callers must hold p.mu.
+func (p *ShamirKeyProvider) sealedError() error {
+	f, err := p.load()
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %d of %d shares submitted", ErrSealed, len(p.pending), f.Threshold)
+}
+
+func (p *ShamirKeyProvider) resetUnsealLocked() {
+	for _, s := range p.pending {
+		wipe(s)
+	}
+	p.pending = nil
+}
+
+func (p *ShamirKeyProvider) resetRekeyLocked() {
+	for _, s := range p.rekeyPending {
+		wipe(s)
+	}
+	p.rekeyPending = nil
+	p.rekeyShares = 0
+	p.rekeyThreshold = 0
+}
+
+func (p *ShamirKeyProvider) load() (shamirFile, error) {
+	data, err := os.ReadFile(p.keyPath)
+	if err != nil {
+		return shamirFile{}, fmt.Errorf("failed to read key file: %w", err)
+	}
+	var f shamirFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return shamirFile{}, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	if f.Version != shamirFileVersion {
+		return shamirFile{}, fmt.Errorf("unsupported key file version: %d", f.Version)
+	}
+	if f.Threshold < 2 || f.Threshold > f.Shares {
+		return shamirFile{}, fmt.Errorf("invalid share configuration in key file: %d of %d", f.Threshold, f.Shares)
+	}
+	return f, nil
+}
+
+func (p *ShamirKeyProvider) save(f shamirFile) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.keyPath, data, 0600)
+}
+
+// collectShare appends share to pending and, once the file's threshold is
+// reached, combines the shares and verifies the result. It returns the updated
+// pending list and the reconstructed unseal key in locked memory (nil until
+// complete).
+func collectShare(pending [][]byte, share []byte, f shamirFile) ([][]byte, *lockedBuffer, error) {
+	if len(share) != 33 {
+		return pending, nil, fmt.Errorf("invalid share length: expected 33 bytes, got %d", len(share))
+	}
+	for _, existing := range pending {
+		if existing[len(existing)-1] == share[len(share)-1] {
+			return pending, nil, fmt.Errorf("share already submitted")
+		}
+	}
+	pending = append(pending, append([]byte(nil), share...))
+	if len(pending) < f.Threshold {
+		return pending, nil, nil
+	}
+
+	unsealKey, err := combineShares(pending)
+	if err != nil {
+		return pending, nil, err
+	}
+	if !hmac.Equal(shamirCheck(unsealKey), f.Check) {
+		wipe(unsealKey)
+		return pending, nil, fmt.Errorf("shares do not reconstruct the unseal key")
+	}
+	locked, err := moveToLockedBuffer(unsealKey)
+	if err != nil {
+		return pending, nil, err
+	}
+	return pending, locked, nil
+}
+
+// newUnsealKey generates a random unseal key in locked memory and splits it
+// into shares.
+func newUnsealKey(shares, threshold int) (*lockedBuffer, [][]byte, error) {
+	unsealKey, err := newLockedBuffer(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, unsealKey.bytes()); err != nil {
+		unsealKey.destroy()
+		return nil, nil, fmt.Errorf("failed to generate unseal key: %w", err)
+	}
+	parts, err := splitSecret(unsealKey.bytes(), shares, threshold)
+	if err != nil {
+		unsealKey.destroy()
+		return nil, nil, err
+	}
+	return unsealKey, parts, nil
+}
+
+// rewrapKey moves a wrapped key from one unseal key to another.
+func rewrapKey(oldUnsealKey, newUnsealKey, wrapped []byte) ([]byte, error) {
+	key, err := openKey(oldUnsealKey, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key: %w", err)
+	}
+	defer wipe(key)
+	return sealKey(newUnsealKey, key)
+}
+
+func shamirCheck(unsealKey []byte) []byte {
+	mac := hmac.New(sha256.New, unsealKey)
+	mac.Write(shamirCheckLabel)
+	return mac.Sum(nil)
+}
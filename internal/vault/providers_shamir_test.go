@@ -0,0 +1,231 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShamirSplitCombine(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	shares, err := splitSecret(secret, 5, 3)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+
+	cases := map[string][][]byte{
+		"first three":    {shares[0], shares[1], shares[2]},
+		"last three":     {shares[2], shares[3], shares[4]},
+		"out of order":   {shares[4], shares[0], shares[3]},
+		"all five":       shares,
+		"four of five":   {shares[1], shares[2], shares[3], shares[4]},
+		"non-contiguous": {shares[0], shares[2], shares[4]},
+	}
+	for name, subset := range cases {
+		subset := subset
+		t.Run(name, func(t *testing.T) {
+			got, err := combineShares(subset)
+			require.NoError(t, err)
+			require.Equal(t, secret, got)
+		})
+	}
+
+	t.Run("below threshold does not reveal the secret", func(t *testing.T) {
+		got, err := combineShares(shares[:2])
+		require.NoError(t, err)
+		require.NotEqual(t, secret, got)
+	})
+
+	t.Run("invalid inputs", func(t *testing.T) {
+		_, err := splitSecret(secret, 3, 4)
+		require.Error(t, err)
+		_, err = splitSecret(secret, 3, 1)
+		require.Error(t, err)
+		_, err = splitSecret(nil, 3, 2)
+		require.Error(t, err)
+		_, err = combineShares([][]byte{shares[0], shares[0]})
+		require.ErrorContains(t, err, "duplicate share")
+		_, err = combineShares([][]byte{shares[0], shares[1][:10]})
+		require.ErrorContains(t, err, "different lengths")
+	})
+}
+
+func TestGF256(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		require.Equal(t, byte(1), gfMul(byte(a), gfInv(byte(a))), "a=%d", a)
+	}
+	require.Equal(t, byte(0xc1), gfMul(0x57, 0x83), "FIPS-197 example")
+}
+
+func TestShamirKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "master.key")
+
+	initial, shares, err := InitShamirKeyProvider(path, 5, 3)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+	masterKey, err := initial.GetKey(ctx)
+	require.NoError(t, err)
+
+	_, _, err = InitShamirKeyProvider(path, 5, 3)
+	require.Error(t, err, "must not overwrite an existing key file")
+
+	p, err := NewShamirKeyProvider(path)
+	require.NoError(t, err)
+	require.True(t, p.Sealed())
+
+	_, err = p.GetKey(ctx)
+	require.ErrorIs(t, err, ErrSealed)
+	require.ErrorContains(t, err, "0 of 3 shares submitted")
+
+	t.Run("unseal one share at a time", func(t *testing.T) {
+		progress, err := p.SubmitShare(ctx, shares[4])
+		require.NoError(t, err)
+		require.Equal(t, ShamirProgress{Submitted: 1, Threshold: 3}, progress)
+
+		_, err = p.SubmitShare(ctx, shares[4])
+		require.ErrorContains(t, err, "already submitted")
+		require.True(t, p.Sealed(), "a bad share resets the ceremony")
+
+		for i, share := range [][]byte{shares[0], shares[2], shares[3]} {
+			progress, err = p.SubmitShare(ctx, share)
+			require.NoError(t, err)
+			require.Equal(t, i+1, progress.Submitted)
+		}
+		require.True(t, progress.Complete())
+		require.False(t, p.Sealed())
+
+		key, err := p.GetKey(ctx)
+		require.NoError(t, err)
+		require.Equal(t, masterKey, key)
+	})
+
+	t.Run("shares from another ceremony are rejected", func(t *testing.T) {
+		_, foreign, err := InitShamirKeyProvider(filepath.Join(t.TempDir(), "other.key"), 3, 3)
+		require.NoError(t, err)
+
+		other, err := NewShamirKeyProvider(path)
+		require.NoError(t, err)
+		_, err = other.SubmitShare(ctx, shares[0])
+		require.NoError(t, err)
+		_, err = other.SubmitShare(ctx, foreign[1])
+		require.NoError(t, err)
+		_, err = other.SubmitShare(ctx, foreign[2])
+		require.ErrorContains(t, err, "do not reconstruct")
+		require.True(t, other.Sealed())
+	})
+
+	t.Run("re-key issues new shares for the same master key", func(t *testing.T) {
+		require.NoError(t, p.BeginRekey(3, 2))
+		for _, share := range shares[:2] {
+			_, newShares, err := p.SubmitRekeyShare(share)
+			require.NoError(t, err)
+			require.Nil(t, newShares)
+		}
+		progress, newShares, err := p.SubmitRekeyShare(shares[2])
+		require.NoError(t, err)
+		require.True(t, progress.Complete())
+		require.Len(t, newShares, 3)
+
+		// Old shares no longer unseal.
+		stale, err := NewShamirKeyProvider(path)
+		require.NoError(t, err)
+		_, err = stale.SubmitShare(ctx, shares[0])
+		require.NoError(t, err)
+		_, err = stale.SubmitShare(ctx, shares[1])
+		require.Error(t, err)
+
+		// New shares unseal to the unchanged master key.
+		fresh, err := NewShamirKeyProvider(path)
+		require.NoError(t, err)
+		_, err = fresh.SubmitShare(ctx, newShares[2])
+		require.NoError(t, err)
+		_, err = fresh.SubmitShare(ctx, newShares[0])
+		require.NoError(t, err)
+
+		key, err := fresh.GetKey(ctx)
+		require.NoError(t, err)
+		require.Equal(t, masterKey, key)
+	})
+}
+
+func TestShamirKeyProvider_StoreUnseal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "master.key")
+	storePath := filepath.Join(dir, "secrets.json")
+
+	initial, shares, err := InitShamirKeyProvider(keyPath, 3, 2)
+	require.NoError(t, err)
+	store, err := NewFileStore(storePath, initial, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "token", []byte("value")))
+
+	// Daemon restart: provider and store both start sealed.
+	p, err := NewShamirKeyProvider(keyPath)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	restarted, err := internalStore(NewFileStore(storePath, p, nil, StartSealed()))
+	require.NoError(t, err)
+
+	p.Attach(restarted)
+
+	_, err = p.SubmitShare(ctx, shares[1])
+	require.NoError(t, err)
+	require.Error(t, restarted.Unseal(ctx), "one share is not enough")
+	require.True(t, restarted.Sealed())
+
+	_, err = p.SubmitShare(ctx, shares[2])
+	require.NoError(t, err)
+	require.False(t, restarted.Sealed(), "completing the ceremony unseals the store")
+
+	got, err := restarted.Get(ctx, "token")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), got)
+
+	require.NoError(t, p.Seal(ctx))
+	require.True(t, p.Sealed())
+	require.True(t, restarted.Sealed(), "sealing the provider seals the store")
+	_, err = restarted.Get(ctx, "token")
+	require.ErrorIs(t, err, ErrSealed)
+}
+
+func TestShamirKeyProvider_SharesStayInProcess(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "master.key")
+	_, shares, err := InitShamirKeyProvider(path, 5, 3)
+	require.NoError(t, err)
+
+	p, err := NewShamirKeyProvider(path)
+	require.NoError(t, err)
+	for _, share := range shares[:2] {
+		_, err := p.SubmitShare(ctx, share)
+		require.NoError(t, err)
+	}
+
+	// Nothing the pending shares could be recovered from is written out.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	for _, share := range shares[:2] {
+		require.NotContains(t, string(raw), base64.StdEncoding.EncodeToString(share))
+	}
+
+	// Another process starts from scratch.
+	other, err := NewShamirKeyProvider(path)
+	require.NoError(t, err)
+	_, err = other.SubmitShare(ctx, shares[2])
+	require.NoError(t, err)
+	require.True(t, other.Sealed())
+
+	_, err = p.SubmitShare(ctx, shares[2])
+	require.NoError(t, err)
+	require.False(t, p.Sealed())
+}
@@ -0,0 +1,523 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transitFileVersion is the current format of the Transit key file.
+const transitFileVersion = 1
+
+// TransitConfig configures a TransitKeyProvider.
+type TransitConfig struct {
+	// Address is the Vault server URL, e.g. https://vault.example.com:8200.
+	Address string
+
+	// Mount is the path the Transit engine is mounted at. Defaults to "transit".
+	Mount string
+
+	// KeyName is the Transit key that wraps the CloudMoor master key.
+	KeyName string
+
+	// Namespace is sent as X-Vault-Namespace when set (Vault Enterprise).
+	Namespace string
+
+	// Token authenticates directly with a Vault token. Ignored when AppRole is set.
+	Token string
+
+	// AppRole authenticates with a role ID and secret ID instead of a static token.
+	AppRole *AppRoleAuth
+
+	// TLS configures server verification and optional client certificates.
+	TLS ClientTLSConfig
+
+	// Timeout bounds each request. Defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// AppRoleAuth holds AppRole login credentials.
+type AppRoleAuth struct {
+	// Mount is the path the AppRole auth method is mounted at. Defaults to "approle".
+	Mount    string
+	RoleID   string
+	SecretID string
+}
+
+// transitFile is the on-disk representation of a Transit-protected key. It only
+// holds Transit ciphertexts ("vault:vN:..."); the master key never touches disk.
+type transitFile struct {
+	Version int    `json:"version"`
+	KeyName string `json:"key_name"`
+
+	// WrappedKey is the master key encrypted by the Transit key.
+	WrappedKey string `json:"wrapped_key"`
+
+	// Retired holds master keys replaced by rotation, newest first, wrapped the same way.
+	Retired []string `json:"retired,omitempty"`
+}
+
+// TransitKeyProvider keeps the master key wrapped by a HashiCorp Vault Transit
+// key. The master key is generated by Transit's datakey endpoint, and every
+// GetKey asks Transit to decrypt the stored ciphertext, so revoking the Vault
+// policy cuts off access to the secrets.
+//
+// RotateKey rotates the Transit key itself, then issues a new master key under
+// the new Transit key version and re-wraps retired keys to that version.
+type TransitKeyProvider struct {
+	keyPath string
+	cfg     TransitConfig
+	client  *http.Client
+
+	mu sync.Mutex // Serializes key file updates
+
+	authMu      sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewTransitKeyProvider opens or creates a Transit-wrapped key file. When the
+// file does not exist a new master key is generated through Transit.
+func NewTransitKeyProvider(ctx context.Context, keyPath string, cfg TransitConfig) (*TransitKeyProvider, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("transit: address is required")
+	}
+	if cfg.KeyName == "" {
+		return nil, fmt.Errorf("transit: key name is required")
+	}
+	if cfg.Token == "" && cfg.AppRole == nil {
+		return nil, fmt.Errorf("transit: token or AppRole credentials are required")
+	}
+	if cfg.Mount == "" {
+		cfg.Mount = "transit"
+	}
+	if cfg.AppRole != nil && cfg.AppRole.Mount == "" {
+		role := *cfg.AppRole
+		role.Mount = "approle"
+		cfg.AppRole = &role
+	}
+	cfg.Address = strings.TrimRight(cfg.Address, "/")
+
+	client, err := newHTTPClient(cfg.TLS, cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("transit: %w", err)
+	}
+	p := &TransitKeyProvider{keyPath: keyPath, cfg: cfg, client: client}
+
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := removeStaleTempFiles(keyPath); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
+		if err := p.generateKey(ctx); err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		return p, nil
+	}
+
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	if f.KeyName != cfg.KeyName {
+		return nil, fmt.Errorf("transit: key file is wrapped by %q, not %q", f.KeyName, cfg.KeyName)
+	}
+	return p, nil
+}
+
+func (p *TransitKeyProvider) GetKey(ctx context.Context) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	return p.decrypt(ctx, f.WrappedKey)
+}
+
+func (p *TransitKeyProvider) RotateKey(ctx context.Context) (oldKey, newKey []byte, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	oldKey, err = p.decrypt(ctx, f.WrappedKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read old key: %w", err)
+	}
+
+	if err := p.do(ctx, http.MethodPost, p.transitPath("keys", "rotate"), nil, nil); err != nil {
+		return nil, nil, fmt.Errorf("failed to rotate transit key: %w", err)
+	}
+
+	// Move every ciphertext to the new Transit key version so old versions can
+	// be retired with min_decryption_version.
+	retired := append([]string{f.WrappedKey}, f.Retired...)
+	for i, ciphertext := range retired {
+		if retired[i], err = p.rewrap(ctx, ciphertext); err != nil {
+			return nil, nil, fmt.Errorf("failed to rewrap retired key: %w", err)
+		}
+	}
+
+	newKey, wrapped, err := p.dataKey(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate new key: %w", err)
+	}
+
+	f.WrappedKey = wrapped
+	f.Retired = retired
+	if err := p.save(f); err != nil {
+		return nil, nil, fmt.Errorf("failed to write new key: %w", err)
+	}
+
+	return oldKey, newKey, nil
+}
+
+// RestoreKey reinstates a previous master key after a failed rotation.
+func (p *TransitKeyProvider) RestoreKey(ctx context.Context, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(key))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return err
+	}
+	wrapped, err := p.encrypt(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to wrap key: %w", err)
+	}
+	f.WrappedKey = wrapped
+	if err := p.save(f); err != nil {
+		return fmt.Errorf("failed to restore key: %w", err)
+	}
+	return nil
+}
+
+// RetiredKeys returns master keys replaced by RotateKey, newest first.
+func (p *TransitKeyProvider) RetiredKeys(ctx context.Context) ([][]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, 0, len(f.Retired))
+	for _, wrapped := range f.Retired {
+		key, err := p.decrypt(ctx, wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap retired key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Rewrap moves the stored ciphertexts to the latest Transit key version
+// without changing the master key. Use it after the Transit key has been
+// rotated outside CloudMoor.
+func (p *TransitKeyProvider) Rewrap(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.load()
+	if err != nil {
+		return err
+	}
+	if f.WrappedKey, err = p.rewrap(ctx, f.WrappedKey); err != nil {
+		return fmt.Errorf("failed to rewrap key: %w", err)
+	}
+	for i, ciphertext := range f.Retired {
+		if f.Retired[i], err = p.rewrap(ctx, ciphertext); err != nil {
+			return fmt.Errorf("failed to rewrap retired key: %w", err)
+		}
+	}
+	if err := p.save(f); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return nil
+}
+
+// HealthCheck verifies that Vault is reachable, the credentials are accepted
+// and the Transit key still decrypts the stored master key.
+func (p *TransitKeyProvider) HealthCheck(ctx context.Context) error {
+	key, err := p.GetKey(ctx)
+	if err != nil {
+		return err
+	}
+	defer wipe(key)
+	if len(key) != 32 {
+		return fmt.Errorf("invalid key size: %d", len(key))
+	}
+	return nil
+}
+
+// generateKey creates a new key file from a Transit-generated data key.
+func (p *TransitKeyProvider) generateKey(ctx context.Context) error {
+	key, wrapped, err := p.dataKey(ctx)
+	if err != nil {
+		return err
+	}
+	wipe(key)
+	return p.save(transitFile{
+		Version:    transitFileVersion,
+		KeyName:    p.cfg.KeyName,
+		WrappedKey: wrapped,
+	})
+}
+
+// load reads the key file. Callers must hold p.mu except during construction.
+func (p *TransitKeyProvider) load() (transitFile, error) {
+	data, err := os.ReadFile(p.keyPath)
+	if err != nil {
+		return transitFile{}, fmt.Errorf("failed to read key file: %w", err)
+	}
+	var f transitFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return transitFile{}, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	if f.Version != transitFileVersion {
+		return transitFile{}, fmt.Errorf("unsupported key file version: %d", f.Version)
+	}
+	return f, nil
+}
+
+// save writes the key file atomically.
+func (p *TransitKeyProvider) save(f transitFile) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.keyPath, data, 0600)
+}
+
+// dataKey asks Transit for a new 256-bit key, returning it in plaintext and wrapped.
+func (p *TransitKeyProvider) dataKey(ctx context.Context) (key []byte, wrapped string, err error) {
+	var resp struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	body := map[string]interface{}{"bits": 256}
+	if err := p.do(ctx, http.MethodPost, p.transitPath("datakey", "plaintext"), body, &resp); err != nil {
+		return nil, "", err
+	}
+	key, err = decodeTransitKey(resp.Plaintext)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, resp.Ciphertext, nil
+}
+
+func (p *TransitKeyProvider) encrypt(ctx context.Context, key []byte) (string, error) {
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	body := map[string]interface{}{"plaintext": base64.StdEncoding.EncodeToString(key)}
+	if err := p.do(ctx, http.MethodPost, p.transitPath("encrypt"), body, &resp); err != nil {
+		return "", err
+	}
+	return resp.Ciphertext, nil
+}
+
+func (p *TransitKeyProvider) decrypt(ctx context.Context, ciphertext string) ([]byte, error) {
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	body := map[string]interface{}{"ciphertext": ciphertext}
+	if err := p.do(ctx, http.MethodPost, p.transitPath("decrypt"), body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to unwrap key: %w", err)
+	}
+	return decodeTransitKey(resp.Plaintext)
+}
+
+func (p *TransitKeyProvider) rewrap(ctx context.Context, ciphertext string) (string, error) {
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	body := map[string]interface{}{"ciphertext": ciphertext}
+	if err := p.do(ctx, http.MethodPost, p.transitPath("rewrap"), body, &resp); err != nil {
+		return "", err
+	}
+	return resp.Ciphertext, nil
+}
+
+// transitPath builds /v1/<mount>/<op>/<key>[/<suffix>]. The "keys" endpoints
+// put the action after the key name; all others put the key name last.
+func (p *TransitKeyProvider) transitPath(op string, suffix ...string) string {
+	parts := []string{"/v1", p.cfg.Mount, op}
+	if op == "keys" {
+		parts = append(parts, url.PathEscape(p.cfg.KeyName))
+		parts = append(parts, suffix...)
+	} else {
+		parts = append(parts, suffix...)
+		parts = append(parts, url.PathEscape(p.cfg.KeyName))
+	}
+	return strings.Join(parts, "/")
+}
+
+// vaultResponse is the common envelope of Vault API responses.
+type vaultResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Auth   *vaultAuth      `json:"auth"`
+	Errors []string        `json:"errors"`
+}
+
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+}
+
+// do sends an authenticated request and decodes the response data into out.
+// With AppRole auth a rejected token triggers one fresh login and retry.
+func (p *TransitKeyProvider) do(ctx context.Context, method, path string, body, out interface{}) error {
+	token, err := p.authToken(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := p.send(ctx, method, path, token, body)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusForbidden && p.cfg.AppRole != nil {
+		p.clearToken(token)
+		if token, err = p.authToken(ctx); err != nil {
+			return err
+		}
+		resp, err = p.send(ctx, method, path, token, body)
+	}
+	if err != nil {
+		return err
+	}
+	if out == nil || len(resp.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		return fmt.Errorf("transit: invalid response from %s: %w", path, err)
+	}
+	return nil
+}
+
+// transitResult carries the HTTP status alongside the decoded response.
+type transitResult struct {
+	vaultResponse
+	StatusCode int
+}
+
+func (p *TransitKeyProvider) send(ctx context.Context, method, path, token string, body interface{}) (*transitResult, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.cfg.Address+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("transit: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if p.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.cfg.Namespace)
+	}
+
+	httpResp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("transit: %s %s: %w", method, path, err)
+	}
+	defer httpResp.Body.Close()
+
+	result := &transitResult{StatusCode: httpResp.StatusCode}
+	data, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return result, fmt.Errorf("transit: %s %s: %w", method, path, err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &result.vaultResponse); err != nil && httpResp.StatusCode < 300 {
+			return result, fmt.Errorf("transit: invalid response from %s: %w", path, err)
+		}
+	}
+	if httpResp.StatusCode >= 300 {
+		msg := strings.Join(result.Errors, "; ")
+		if msg == "" {
+			msg = http.StatusText(httpResp.StatusCode)
+		}
+		return result, fmt.Errorf("transit: %s %s: %s (status %d)", method, path, msg, httpResp.StatusCode)
+	}
+	return result, nil
+}
+
+// authToken returns the static token, or a cached AppRole token, logging in
+// again once the lease has expired.
+func (p *TransitKeyProvider) authToken(ctx context.Context) (string, error) {
+	if p.cfg.AppRole == nil {
+		return p.cfg.Token, nil
+	}
+
+	p.authMu.Lock()
+	defer p.authMu.Unlock()
+	if p.token != "" && (p.tokenExpiry.IsZero() || time.Now().Before(p.tokenExpiry)) {
+		return p.token, nil
+	}
+
+	role := p.cfg.AppRole
+	body := map[string]string{"role_id": role.RoleID, "secret_id": role.SecretID}
+	resp, err := p.send(ctx, http.MethodPost, "/v1/auth/"+role.Mount+"/login", "", body)
+	if err != nil {
+		return "", fmt.Errorf("approle login failed: %w", err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("approle login failed: no client token in response")
+	}
+
+	p.token = resp.Auth.ClientToken
+	p.tokenExpiry = time.Time{}
+	if resp.Auth.LeaseDuration > 0 {
+		// Renew a little early so in-flight requests do not race the expiry.
+		lease := time.Duration(resp.Auth.LeaseDuration) * time.Second
+		p.tokenExpiry = time.Now().Add(lease - lease/10)
+	}
+	return p.token, nil
+}
+
+// clearToken drops a cached AppRole token unless another request already replaced it.
+func (p *TransitKeyProvider) clearToken(token string) {
+	p.authMu.Lock()
+	defer p.authMu.Unlock()
+	if p.token == token {
+		p.token = ""
+	}
+}
+
+// decodeTransitKey decodes a base64 plaintext returned by Transit into a master key.
+func decodeTransitKey(plaintext string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("transit: invalid plaintext encoding: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid key size: expected 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
@@ -0,0 +1,370 @@
+package vault
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// transitStub is a minimal stand-in for the Vault Transit engine and AppRole
+// login. Ciphertexts use Vault's "vault:vN:<base64>" format.
+type transitStub struct {
+	mu       sync.Mutex
+	roleID   string
+	secretID string
+	lease    int
+	logins   int
+	keys     map[string][][]byte // Transit key name -> versions (index 0 is v1)
+	tokens   map[string]bool
+}
+
+func newTransitStub(t *testing.T) (*transitStub, *httptest.Server) {
+	t.Helper()
+	stub := &transitStub{
+		roleID:   "role",
+		secretID: "secret",
+		keys:     map[string][][]byte{},
+		tokens:   map[string]bool{"root-token": true},
+	}
+	stub.createKey("cloudmoor")
+	srv := httptest.NewTLSServer(stub)
+	t.Cleanup(srv.Close)
+	return stub, srv
+}
+
+func (s *transitStub) createKey(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[name] = [][]byte{randomKey()}
+}
+
+func (s *transitStub) revokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]bool{}
+}
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func (s *transitStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var body map[string]interface{}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+	str := func(k string) string { v, _ := body[k].(string); return v }
+
+	if r.URL.Path == "/v1/auth/approle/login" {
+		if str("role_id") != s.roleID || str("secret_id") != s.secretID {
+			writeVaultError(w, http.StatusBadRequest, "invalid role or secret ID")
+			return
+		}
+		s.logins++
+		token := fmt.Sprintf("approle-token-%d", s.logins)
+		s.tokens[token] = true
+		writeVault(w, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": token, "lease_duration": s.lease},
+		})
+		return
+	}
+
+	if !s.tokens[r.Header.Get("X-Vault-Token")] {
+		writeVaultError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/transit/"), "/")
+	var name string
+	switch {
+	case len(parts) == 3 && parts[0] == "keys" && parts[2] == "rotate":
+		name = parts[1]
+	case len(parts) == 3 && parts[0] == "datakey":
+		name = parts[2]
+	case len(parts) == 2:
+		name = parts[1]
+	default:
+		writeVaultError(w, http.StatusNotFound, "no handler for route")
+		return
+	}
+	versions, ok := s.keys[name]
+	if !ok {
+		writeVaultError(w, http.StatusBadRequest, "encryption key not found")
+		return
+	}
+
+	switch {
+	case parts[0] == "keys":
+		s.keys[name] = append(versions, randomKey())
+		writeVault(w, map[string]interface{}{})
+	case parts[0] == "datakey":
+		key := randomKey()
+		writeVault(w, map[string]interface{}{"data": map[string]string{
+			"plaintext":  base64.StdEncoding.EncodeToString(key),
+			"ciphertext": stubSeal(versions, key),
+		}})
+	case parts[0] == "encrypt":
+		plaintext, err := base64.StdEncoding.DecodeString(str("plaintext"))
+		if err != nil {
+			writeVaultError(w, http.StatusBadRequest, "invalid plaintext")
+			return
+		}
+		writeVault(w, map[string]interface{}{"data": map[string]string{"ciphertext": stubSeal(versions, plaintext)}})
+	case parts[0] == "decrypt" || parts[0] == "rewrap":
+		plaintext, err := stubOpen(versions, str("ciphertext"))
+		if err != nil {
+			writeVaultError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if parts[0] == "rewrap" {
+			writeVault(w, map[string]interface{}{"data": map[string]string{"ciphertext": stubSeal(versions, plaintext)}})
+			return
+		}
+		writeVault(w, map[string]interface{}{"data": map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plaintext)}})
+	default:
+		writeVaultError(w, http.StatusNotFound, "no handler for route")
+	}
+}
+
+func stubSeal(versions [][]byte, plaintext []byte) string {
+	wrapped, err := sealKey(versions[len(versions)-1], plaintext)
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("vault:v%d:%s", len(versions), base64.StdEncoding.EncodeToString(wrapped))
+}
+
+func stubOpen(versions [][]byte, ciphertext string) ([]byte, error) {
+	fields := strings.SplitN(ciphertext, ":", 3)
+	if len(fields) != 3 || fields[0] != "vault" {
+		return nil, fmt.Errorf("invalid ciphertext")
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(fields[1], "v"))
+	if err != nil || version < 1 || version > len(versions) {
+		return nil, fmt.Errorf("invalid key version")
+	}
+	raw, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	block, _ := aes.NewCipher(versions[version-1])
+	gcm, _ := cipher.NewGCM(block)
+	if len(raw) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid ciphertext")
+	}
+	return gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil)
+}
+
+func writeVault(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeVaultError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {msg}})
+}
+
+// writeServerCA writes the test server's certificate as a PEM CA bundle.
+func writeServerCA(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+func TestTransitKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	stub, srv := newTransitStub(t)
+	caFile := writeServerCA(t, srv)
+
+	tests := []struct {
+		name string
+		cfg  TransitConfig
+	}{
+		{
+			name: "token auth",
+			cfg:  TransitConfig{Address: srv.URL, KeyName: "cloudmoor", Token: "root-token", TLS: ClientTLSConfig{CACertFile: caFile}},
+		},
+		{
+			name: "approle auth",
+			cfg: TransitConfig{Address: srv.URL, KeyName: "cloudmoor", TLS: ClientTLSConfig{CACertFile: caFile},
+				AppRole: &AppRoleAuth{RoleID: "role", SecretID: "secret"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyPath := filepath.Join(t.TempDir(), "master.key")
+			p, err := NewTransitKeyProvider(ctx, keyPath, tt.cfg)
+			require.NoError(t, err)
+			require.NoError(t, p.HealthCheck(ctx))
+
+			key, err := p.GetKey(ctx)
+			require.NoError(t, err)
+			require.Len(t, key, 32)
+
+			data, err := os.ReadFile(keyPath)
+			require.NoError(t, err)
+			require.Contains(t, string(data), "vault:v1:")
+			require.NotContains(t, string(data), base64.StdEncoding.EncodeToString(key), "master key must not be on disk")
+
+			reopened, err := NewTransitKeyProvider(ctx, keyPath, tt.cfg)
+			require.NoError(t, err)
+			again, err := reopened.GetKey(ctx)
+			require.NoError(t, err)
+			require.Equal(t, key, again)
+		})
+	}
+
+	t.Run("approle re-login after token revocation", func(t *testing.T) {
+		cfg := tests[1].cfg
+		p, err := NewTransitKeyProvider(ctx, filepath.Join(t.TempDir(), "master.key"), cfg)
+		require.NoError(t, err)
+
+		stub.mu.Lock()
+		before := stub.logins
+		stub.mu.Unlock()
+		stub.revokeTokens()
+
+		_, err = p.GetKey(ctx)
+		require.NoError(t, err)
+		stub.mu.Lock()
+		require.Equal(t, before+1, stub.logins)
+		stub.mu.Unlock()
+	})
+}
+
+func TestTransitKeyProvider_Errors(t *testing.T) {
+	ctx := context.Background()
+	_, srv := newTransitStub(t)
+	caFile := writeServerCA(t, srv)
+	base := TransitConfig{Address: srv.URL, KeyName: "cloudmoor", Token: "root-token", TLS: ClientTLSConfig{CACertFile: caFile}}
+
+	t.Run("untrusted server certificate", func(t *testing.T) {
+		cfg := base
+		cfg.TLS = ClientTLSConfig{}
+		_, err := NewTransitKeyProvider(ctx, filepath.Join(t.TempDir(), "master.key"), cfg)
+		require.ErrorContains(t, err, "certificate")
+	})
+
+	t.Run("bad token", func(t *testing.T) {
+		cfg := base
+		cfg.Token = "wrong"
+		_, err := NewTransitKeyProvider(ctx, filepath.Join(t.TempDir(), "master.key"), cfg)
+		require.ErrorContains(t, err, "permission denied")
+	})
+
+	t.Run("bad approle credentials", func(t *testing.T) {
+		cfg := base
+		cfg.AppRole = &AppRoleAuth{RoleID: "role", SecretID: "nope"}
+		_, err := NewTransitKeyProvider(ctx, filepath.Join(t.TempDir(), "master.key"), cfg)
+		require.ErrorContains(t, err, "approle login failed")
+	})
+
+	t.Run("unknown transit key", func(t *testing.T) {
+		cfg := base
+		cfg.KeyName = "missing"
+		_, err := NewTransitKeyProvider(ctx, filepath.Join(t.TempDir(), "master.key"), cfg)
+		require.ErrorContains(t, err, "encryption key not found")
+	})
+
+	t.Run("key file bound to another transit key", func(t *testing.T) {
+		keyPath := filepath.Join(t.TempDir(), "master.key")
+		_, err := NewTransitKeyProvider(ctx, keyPath, base)
+		require.NoError(t, err)
+
+		cfg := base
+		cfg.KeyName = "other"
+		_, err = NewTransitKeyProvider(ctx, keyPath, cfg)
+		require.ErrorContains(t, err, `wrapped by "cloudmoor"`)
+	})
+
+	t.Run("missing configuration", func(t *testing.T) {
+		_, err := NewTransitKeyProvider(ctx, "unused", TransitConfig{KeyName: "k", Token: "t"})
+		require.Error(t, err)
+		_, err = NewTransitKeyProvider(ctx, "unused", TransitConfig{Address: srv.URL, Token: "t"})
+		require.Error(t, err)
+		_, err = NewTransitKeyProvider(ctx, "unused", TransitConfig{Address: srv.URL, KeyName: "k"})
+		require.Error(t, err)
+	})
+}
+
+func TestTransitKeyProvider_Rotation(t *testing.T) {
+	ctx := context.Background()
+	stub, srv := newTransitStub(t)
+	cfg := TransitConfig{Address: srv.URL, KeyName: "cloudmoor", Token: "root-token", TLS: ClientTLSConfig{CACertFile: writeServerCA(t, srv)}}
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "master.key")
+
+	p, err := NewTransitKeyProvider(ctx, keyPath, cfg)
+	require.NoError(t, err)
+	store, err := internalStore(NewFileStore(filepath.Join(dir, "secrets.json"), p, nil))
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "token", []byte("value")))
+	oldKey, err := p.GetKey(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Rotate(ctx))
+
+	stub.mu.Lock()
+	require.Len(t, stub.keys["cloudmoor"], 2, "rotation must rotate the Transit key")
+	stub.mu.Unlock()
+
+	newKey, err := p.GetKey(ctx)
+	require.NoError(t, err)
+	require.NotEqual(t, oldKey, newKey)
+
+	retired, err := p.RetiredKeys(ctx)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{oldKey}, retired)
+
+	data, err := os.ReadFile(keyPath)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "vault:v1:", "all ciphertexts move to the new Transit version")
+
+	got, err := store.Get(ctx, "token")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), got)
+
+	t.Run("rewrap after external rotation keeps the master key", func(t *testing.T) {
+		stub.mu.Lock()
+		stub.keys["cloudmoor"] = append(stub.keys["cloudmoor"], randomKey())
+		stub.mu.Unlock()
+
+		require.NoError(t, p.Rewrap(ctx))
+		data, err := os.ReadFile(keyPath)
+		require.NoError(t, err)
+		require.Contains(t, string(data), "vault:v3:")
+		require.NotContains(t, string(data), "vault:v2:")
+
+		key, err := p.GetKey(ctx)
+		require.NoError(t, err)
+		require.Equal(t, newKey, key)
+	})
+}
@@ -0,0 +1,296 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Rotate replaces the master key and re-wraps the data key of every retained
+// secret version as one transaction, re-signing each entry under the new key.
+// Secret payloads are left untouched.
+//
+// All data keys are unwrapped with the current key before the provider is asked
+// to rotate, so a corrupt entry aborts the operation without touching the key.
+// The re-wrapped entries are swapped in only after they have all been sealed and
+// persisted; if that fails, the previous key is reinstated. Rotation is refused
+// for providers that do not implement KeyRestorer, since a failure after the
+// provider commits would leave entries under a key it no longer returns.
+func (s *aesgcmStore) Rotate(ctx context.Context) error {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "rotate",
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	fail := func(sentinel, err error) error {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", sentinel, err)
+		return fmt.Errorf("%w: %v", sentinel, err)
+	}
+
+	// Block all readers and writers so no entry is sealed under a stale key.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	currentID, err := s.refreshKey(ctx)
+	if errors.Is(err, ErrSealed) {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
+	if err != nil {
+		return fail(ErrKeyProvider, err)
+	}
+	restorer, ok := s.keyProvider.(KeyRestorer)
+	if !ok {
+		return fail(ErrKeyProvider, fmt.Errorf("key provider cannot restore a previous key, so a failed rotation could not be rolled back"))
+	}
+
+	type unwrapped struct{ dek, payload []byte }
+	entries := make(map[string][]unwrapped, len(s.secrets))
+	defer func() {
+		for _, versions := range entries {
+			for _, v := range versions {
+				wipe(v.dek)
+			}
+		}
+	}()
+	for key, entry := range s.secrets {
+		if err := s.verifyEntry(key, entry); err != nil {
+			event.Success = false
+			event.Error = err.Error()
+			return err
+		}
+		versions := make([]unwrapped, len(entry.Versions))
+		entries[key] = versions
+		for i, v := range entry.Versions {
+			dek, payload, err := s.unwrap(key, v.Data)
+			if err != nil {
+				return fail(ErrDecryption, fmt.Errorf("entry %q version %d: %w", key, v.Number, err))
+			}
+			versions[i] = unwrapped{dek: dek, payload: payload}
+		}
+	}
+
+	oldKey, newKey, err := s.keyProvider.RotateKey(ctx)
+	if err != nil {
+		return fail(ErrKeyProvider, err)
+	}
+	// The keyring keeps its own copies in locked memory.
+	defer wipe(oldKey)
+	defer wipe(newKey)
+	if KeyID(oldKey) != currentID {
+		// Someone else rotated between GetKey and RotateKey; the unwrapped
+		// data keys are still valid, but the key to restore is oldKey.
+		if currentID, err = s.keyring.Add(oldKey); err != nil {
+			return fail(ErrKeyProvider, err)
+		}
+	}
+
+	newID, err := s.keyring.Add(newKey)
+	if err != nil {
+		return fail(ErrKeyProvider, s.rollbackRotation(ctx, restorer, currentID, err))
+	}
+	if err := s.keyring.SetActive(newID); err != nil {
+		return fail(ErrKeyProvider, s.rollbackRotation(ctx, restorer, currentID, err))
+	}
+
+	rotated := make(map[string]*secretEntry, len(entries))
+	for key, versions := range entries {
+		data := make([][]byte, len(versions))
+		for i, v := range versions {
+			encrypted, err := s.wrap(key, newID, v.dek, v.payload)
+			if err != nil {
+				return fail(ErrEncryption, s.rollbackRotation(ctx, restorer, currentID, fmt.Errorf("entry %q: %w", key, err)))
+			}
+			data[i] = encrypted
+		}
+		entry := s.secrets[key].withData(data)
+		if err := s.signEntry(key, entry, newID); err != nil {
+			return fail(ErrEncryption, s.rollbackRotation(ctx, restorer, currentID, fmt.Errorf("entry %q: %w", key, err)))
+		}
+		rotated[key] = entry
+	}
+
+	previous := s.secrets
+	s.secrets = rotated
+	if err := s.persistLocked(); err != nil {
+		s.secrets = previous
+		return fail(ErrPersistence, s.rollbackRotation(ctx, restorer, currentID, err))
+	}
+
+	s.publish(WatchEvent{Type: EventRotate, Revision: s.revision})
+	event.Success = true
+	event.Metadata = map[string]string{"count": fmt.Sprintf("%d", len(rotated))}
+	return nil
+}
+
+// rollbackRotation reinstates the key oldID after a failed rotation and returns
+// cause, annotated with any restore failure so operators know the key is out of
+// sync.
+func (s *aesgcmStore) rollbackRotation(ctx context.Context, restorer KeyRestorer, oldID string, cause error) error {
+	if err := s.keyring.SetActive(oldID); err != nil {
+		return errors.Join(cause, err)
+	}
+	oldKey, _ := s.keyring.Key(oldID)
+	defer wipe(oldKey)
+	if err := restorer.RestoreKey(ctx, oldKey); err != nil {
+		return errors.Join(cause, fmt.Errorf("failed to restore previous key: %w", err))
+	}
+	return cause
+}
+
+// Migrate re-wraps every entry whose data key is not already under the active
+// key, upgrading entries in older envelope formats (no per-secret data key, or
+// no key-name binding) along the way, and signs entries that carry no MAC or
+// one under another key. The work is done outside the data lock and committed
+// with one write, so a failure leaves the file untouched.
+func (s *aesgcmStore) Migrate(ctx context.Context) error {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "migrate",
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	activeID, err := s.refreshKey(ctx)
+	if errors.Is(err, ErrSealed) {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
+	if err != nil {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", ErrKeyProvider, err)
+		return fmt.Errorf("%w: %v", ErrKeyProvider, err)
+	}
+
+	migrated := 0
+	for {
+		n, retry, err := s.migrateStale(ctx, activeID)
+		migrated += n
+		if err != nil {
+			event.Success = false
+			event.Error = err.Error()
+			event.Metadata = map[string]string{"count": fmt.Sprintf("%d", migrated)}
+			return err
+		}
+		if !retry {
+			break
+		}
+	}
+
+	event.Success = true
+	event.Metadata = map[string]string{"count": fmt.Sprintf("%d", migrated)}
+	return nil
+}
+
+// migrateStale re-seals every version not already under activeID from a
+// snapshot of the store, without holding the data lock, then swaps the results
+// in and persists them with a single write. Entries written or rotated in the
+// meantime are left alone and reported through retry so the caller can take
+// another pass.
+func (s *aesgcmStore) migrateStale(ctx context.Context, activeID string) (migrated int, retry bool, err error) {
+	s.mu.RLock()
+	snapshot := make(map[string]*secretEntry, len(s.secrets))
+	for k, entry := range s.secrets {
+		snapshot[k] = entry
+	}
+	s.mu.RUnlock()
+
+	keys := make([]string, 0, len(snapshot))
+	for k := range snapshot {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	resealed := make(map[string]*secretEntry)
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return 0, false, err
+		}
+		updated, err := s.resealEntry(key, snapshot[key], activeID)
+		if err != nil {
+			return 0, false, err
+		}
+		if updated != nil {
+			resealed[key] = updated
+		}
+	}
+	if len(resealed) == 0 {
+		return 0, false, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := make(map[string]*secretEntry, len(resealed))
+	for key, updated := range resealed {
+		current, exists := s.secrets[key]
+		if current != snapshot[key] {
+			retry = retry || exists // Deleted entries need no second pass
+			continue
+		}
+		previous[key] = current
+		s.secrets[key] = updated
+	}
+	if len(previous) == 0 {
+		return 0, retry, nil
+	}
+	if err := s.persistLocked(); err != nil {
+		for key, entry := range previous {
+			s.secrets[key] = entry
+		}
+		return 0, false, fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+	return len(previous), retry, nil
+}
+
+// resealEntry returns a copy of entry with every version that is not under the
+// active key re-wrapped under it and signed under that key, or nil if the entry
+// is already fully under it.
+func (s *aesgcmStore) resealEntry(key string, entry *secretEntry, activeID string) (*secretEntry, error) {
+	if err := s.verifyEntry(key, entry); err != nil {
+		return nil, err
+	}
+	data := make([][]byte, len(entry.Versions))
+	changed := len(entry.MAC) == 0 || entry.MACKey != activeID
+	for i, v := range entry.Versions {
+		if env, err := parseEnvelope(v.Data); err == nil &&
+			env.Version == envelopeVersion3 && env.KeyID == activeID {
+			data[i] = v.Data
+			continue
+		}
+
+		dek, payload, err := s.unwrap(key, v.Data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: entry %q version %d: %v", ErrDecryption, key, v.Number, err)
+		}
+		resealed, err := s.wrap(key, activeID, dek, payload)
+		wipe(dek)
+		if err != nil {
+			return nil, fmt.Errorf("%w: entry %q version %d: %v", ErrEncryption, key, v.Number, err)
+		}
+		data[i] = resealed
+		changed = true
+	}
+	if !changed {
+		return nil, nil
+	}
+	updated := entry.withData(data)
+	if err := s.signEntry(key, updated, activeID); err != nil {
+		return nil, fmt.Errorf("%w: entry %q: %v", ErrEncryption, key, err)
+	}
+	return updated, nil
+}
+
+// wipe overwrites b with zeros.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,152 @@
+package vault
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAESGCMStore_Rotate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("re-encrypts all entries", func(t *testing.T) {
+		keyProvider, err := NewInMemoryKeyProvider()
+		require.NoError(t, err)
+
+		var auditEvents []AuditEvent
+		store := NewAESGCMStore(keyProvider, func(e AuditEvent) {
+			auditEvents = append(auditEvents, e)
+		}).(*aesgcmStore)
+
+		require.NoError(t, store.Put(ctx, "a", []byte("alpha")))
+		require.NoError(t, store.Put(ctx, "b", []byte("bravo")))
+
+		oldKey, err := keyProvider.GetKey(ctx)
+		require.NoError(t, err)
+
+		auditEvents = nil
+		require.NoError(t, store.Rotate(ctx))
+
+		newKey, err := keyProvider.GetKey(ctx)
+		require.NoError(t, err)
+		require.NotEqual(t, oldKey, newKey)
+
+		require.Len(t, auditEvents, 1)
+		require.Equal(t, "rotate", auditEvents[0].Operation)
+		require.True(t, auditEvents[0].Success)
+		require.Equal(t, "2", auditEvents[0].Metadata["count"])
+
+		for key, want := range map[string]string{"a": "alpha", "b": "bravo"} {
+			got, err := store.Get(ctx, key)
+			require.NoError(t, err)
+			require.Equal(t, []byte(want), got)
+		}
+	})
+
+	t.Run("corrupt entry aborts before rotating", func(t *testing.T) {
+		keyProvider, err := NewInMemoryKeyProvider()
+		require.NoError(t, err)
+
+		store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+		require.NoError(t, store.Put(ctx, "good", []byte("value")))
+		putUnsigned(store, "bad", newSecretEntry([]byte("not a ciphertext")))
+
+		before, err := keyProvider.GetKey(ctx)
+		require.NoError(t, err)
+
+		err = store.Rotate(ctx)
+		require.ErrorIs(t, err, ErrDecryption)
+
+		after, err := keyProvider.GetKey(ctx)
+		require.NoError(t, err)
+		require.Equal(t, before, after, "key must not rotate when an entry is unreadable")
+
+		got, err := store.Get(ctx, "good")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), got)
+	})
+
+	t.Run("persist failure restores previous key", func(t *testing.T) {
+		keyProvider, err := NewInMemoryKeyProvider()
+		require.NoError(t, err)
+
+		store, err := internalStore(NewFileStore(filepath.Join(t.TempDir(), "secrets.json"), keyProvider, nil))
+		require.NoError(t, err)
+		require.NoError(t, store.Put(ctx, "key", []byte("value")))
+
+		before, err := keyProvider.GetKey(ctx)
+		require.NoError(t, err)
+
+		store.path = filepath.Join(t.TempDir(), "missing", "secrets.json")
+		err = store.Rotate(ctx)
+		require.ErrorIs(t, err, ErrPersistence)
+
+		after, err := keyProvider.GetKey(ctx)
+		require.NoError(t, err)
+		require.Equal(t, before, after)
+
+		got, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), got)
+	})
+	t.Run("provider without restore is refused", func(t *testing.T) {
+		inner, err := NewInMemoryKeyProvider()
+		require.NoError(t, err)
+		keyProvider := &countingKeyProvider{KeyProvider: inner}
+		store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+		require.NoError(t, store.Put(ctx, "key", []byte("value")))
+
+		before, err := inner.GetKey(ctx)
+		require.NoError(t, err)
+		require.ErrorIs(t, store.Rotate(ctx), ErrKeyProvider)
+		after, err := inner.GetKey(ctx)
+		require.NoError(t, err)
+		require.Equal(t, before, after, "the provider must not rotate")
+	})
+}
+
+func TestAESGCMStore_MigrateCommitsOnce(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	store, err := internalStore(NewFileStore(path, keyProvider, nil))
+	require.NoError(t, err)
+	defer store.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, store.Put(ctx, key, []byte("value-"+key)))
+	}
+	oldKey, newKey, err := keyProvider.RotateKey(ctx)
+	require.NoError(t, err)
+
+	keyIDs := func() []string {
+		store.mu.RLock()
+		defer store.mu.RUnlock()
+		var ids []string
+		for _, key := range []string{"a", "b", "c"} {
+			env, err := parseEnvelope(store.secrets[key].current().Data)
+			require.NoError(t, err)
+			ids = append(ids, env.KeyID)
+		}
+		return ids
+	}
+
+	// A failed write leaves every entry as it was, not just the later ones.
+	store.path = filepath.Join(t.TempDir(), "missing", "secrets.json")
+	require.ErrorIs(t, store.Migrate(ctx), ErrPersistence)
+	old := KeyID(oldKey)
+	require.Equal(t, []string{old, old, old}, keyIDs())
+
+	store.path = path
+	require.NoError(t, store.Migrate(ctx))
+	current := KeyID(newKey)
+	require.Equal(t, []string{current, current, current}, keyIDs())
+	for _, key := range []string{"a", "b", "c"} {
+		got, err := store.Get(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, []byte("value-"+key), got)
+	}
+}
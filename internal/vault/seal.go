@@ -0,0 +1,214 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// StoreOption customizes a Store created by NewAESGCMStore or NewFileStore.
+type StoreOption func(*aesgcmStore)
+
+// StartSealed creates the store in the sealed state. No master key is loaded
+// and every operation returns ErrSealed until Unseal is called.
+func StartSealed() StoreOption {
+	return func(s *aesgcmStore) {
+		s.sealed = true
+	}
+}
+
+// WithAutoSeal seals the store automatically once no operation has used it for
+// the given idle period. A zero duration disables auto-sealing.
+func WithAutoSeal(idle time.Duration) StoreOption {
+	return func(s *aesgcmStore) {
+		s.autoSeal = idle
+	}
+}
+
+// Seal wipes all master keys from memory and lets the process write core
+// dumps again. Until Unseal is called, every operation returns ErrSealed.
+// Operations already in flight complete first.
+func (s *aesgcmStore) Seal(ctx context.Context) error {
+	s.sealWithReason(ctx, "manual")
+	return nil
+}
+
+// Unseal loads the master key (and any retired keys) from the KeyProvider into
+// locked memory so operations can proceed without contacting the provider each
+// time. Core dumps are disabled until the store is sealed again.
+func (s *aesgcmStore) Unseal(ctx context.Context) error {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "unseal",
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if _, err := s.currentKey(ctx); err != nil {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", ErrKeyProvider, err)
+		return fmt.Errorf("%w: %v", ErrKeyProvider, err)
+	}
+
+	s.sealed = false
+	s.lastUsed = time.Now()
+	s.startIdleTimerLocked()
+
+	event.Success = true
+	return nil
+}
+
+// Sealed reports whether the store is currently sealed.
+func (s *aesgcmStore) Sealed() bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.sealed
+}
+
+// sealWithReason performs the seal and records why it happened.
+func (s *aesgcmStore) sealWithReason(ctx context.Context, reason string) {
+	defer s.auditSeal(ctx, reason)
+
+	// Taking the data lock first waits out in-flight writes and rotations.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.sealLocked()
+}
+
+// auditSeal records a seal and why it happened.
+func (s *aesgcmStore) auditSeal(ctx context.Context, reason string) {
+	s.audit(ctx, AuditEvent{
+		Timestamp: time.Now(),
+		Operation: "seal",
+		Success:   true,
+		Metadata:  map[string]string{"reason": reason},
+	})
+}
+
+// sealLocked wipes the keys and marks the store sealed. Callers must hold s.mu
+// and s.stateMu.
+func (s *aesgcmStore) sealLocked() {
+	s.sealed = true
+	s.keyring.Wipe()
+	if s.holdsCoreDumps {
+		coreDumps.release()
+		s.holdsCoreDumps = false
+	}
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+}
+
+// checkUnsealed returns ErrSealed if the store is sealed, or
+// ErrAuditUnavailable if a fail-closed audit pipeline cannot take more events,
+// and otherwise records activity for the idle timer.
+func (s *aesgcmStore) checkUnsealed() error {
+	if s.auditGate != nil {
+		if err := s.auditGate(); err != nil {
+			return err
+		}
+	}
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.sealed {
+		return ErrSealed
+	}
+	s.lastUsed = time.Now()
+	return nil
+}
+
+// activeKey returns the ID of the in-memory active master key. A store that
+// was not created sealed loads the key from the provider on first use.
+func (s *aesgcmStore) activeKey(ctx context.Context) (string, error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.sealed {
+		return "", ErrSealed
+	}
+	s.lastUsed = time.Now()
+	return s.loadKeysLocked(ctx)
+}
+
+// loadKeys makes sure the keyring is loaded, like activeKey, without counting
+// as use of the store. Failures other than ErrSealed wrap ErrKeyProvider.
+func (s *aesgcmStore) loadKeys(ctx context.Context) error {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.sealed {
+		return ErrSealed
+	}
+	if _, err := s.loadKeysLocked(ctx); err != nil {
+		return fmt.Errorf("%w: %v", ErrKeyProvider, err)
+	}
+	return nil
+}
+
+// loadKeysLocked returns the active key ID, loading the keyring from the
+// provider if it is empty. Callers must hold s.stateMu.
+func (s *aesgcmStore) loadKeysLocked(ctx context.Context) (string, error) {
+	if id, err := s.keyring.ActiveID(); err == nil {
+		return id, nil
+	}
+	id, err := s.currentKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.startIdleTimerLocked()
+	return id, nil
+}
+
+// refreshKey re-reads the provider's current master key into the keyring and
+// returns its ID. Used by operations that must track the provider, such as
+// rotation.
+func (s *aesgcmStore) refreshKey(ctx context.Context) (string, error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.sealed {
+		return "", ErrSealed
+	}
+	s.lastUsed = time.Now()
+	return s.currentKey(ctx)
+}
+
+// startIdleTimerLocked arms the auto-seal timer. Callers must hold s.stateMu.
+func (s *aesgcmStore) startIdleTimerLocked() {
+	if s.autoSeal <= 0 || s.idleTimer != nil {
+		return
+	}
+	s.idleTimer = time.AfterFunc(s.autoSeal, s.sealIfIdle)
+}
+
+// sealIfIdle runs when the idle timer fires. It seals the store if nothing has
+// used it for the auto-seal period and otherwise re-arms the timer.
+func (s *aesgcmStore) sealIfIdle() {
+	if s.trySealIdle() {
+		s.auditSeal(context.Background(), "idle")
+	}
+}
+
+// trySealIdle seals the store if it has been idle for the auto-seal period and
+// reports whether it did. The check and the seal happen under one hold of the
+// locks, so an operation starting in between either counts as use or finds
+// the store sealed.
+func (s *aesgcmStore) trySealIdle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if s.sealed || s.idleTimer == nil {
+		return false
+	}
+	if idle := time.Since(s.lastUsed); idle < s.autoSeal {
+		s.idleTimer.Reset(s.autoSeal - idle)
+		return false
+	}
+	s.sealLocked()
+	return true
+}
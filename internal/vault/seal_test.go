@@ -0,0 +1,154 @@
+package vault
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingKeyProvider records how often the master key is fetched.
+type countingKeyProvider struct {
+	KeyProvider
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *countingKeyProvider) GetKey(ctx context.Context) ([]byte, error) {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+	return p.KeyProvider.GetKey(ctx)
+}
+
+func (p *countingKeyProvider) getKeyCalls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func TestAESGCMStore_SealUnseal(t *testing.T) {
+	ctx := context.Background()
+	inner, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	keyProvider := &countingKeyProvider{KeyProvider: inner}
+
+	var mu sync.Mutex
+	var operations []string
+	store := NewAESGCMStore(keyProvider, func(e AuditEvent) {
+		mu.Lock()
+		operations = append(operations, e.Operation)
+		mu.Unlock()
+	}, StartSealed()).(*aesgcmStore)
+
+	require.True(t, store.Sealed())
+	require.Zero(t, keyProvider.getKeyCalls(), "sealed store must not load the key")
+
+	t.Run("operations fail while sealed", func(t *testing.T) {
+		require.ErrorIs(t, store.Put(ctx, "key", []byte("value")), ErrSealed)
+		_, err := store.Get(ctx, "key")
+		require.ErrorIs(t, err, ErrSealed)
+		require.ErrorIs(t, store.Delete(ctx, "key"), ErrSealed)
+		_, err = store.List(ctx)
+		require.ErrorIs(t, err, ErrSealed)
+		require.ErrorIs(t, store.Rotate(ctx), ErrSealed)
+		require.ErrorIs(t, store.Migrate(ctx), ErrSealed)
+		require.ErrorIs(t, store.HealthCheck(ctx), ErrSealed)
+	})
+
+	t.Run("unsealed store keeps the key in memory", func(t *testing.T) {
+		require.NoError(t, store.Unseal(ctx))
+		require.False(t, store.Sealed())
+		calls := keyProvider.getKeyCalls()
+
+		require.NoError(t, store.Put(ctx, "key", []byte("value")))
+		got, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), got)
+		require.Equal(t, calls, keyProvider.getKeyCalls(), "put/get must not hit the provider once unsealed")
+	})
+
+	t.Run("seal wipes keys", func(t *testing.T) {
+		require.NoError(t, store.Seal(ctx))
+		require.True(t, store.Sealed())
+		require.Empty(t, store.keyring.IDs())
+
+		_, err := store.Get(ctx, "key")
+		require.ErrorIs(t, err, ErrSealed)
+
+		require.NoError(t, store.Unseal(ctx))
+		got, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), got)
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Contains(t, operations, "unseal")
+	require.Contains(t, operations, "seal")
+}
+
+func TestAESGCMStore_AutoSeal(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var sealEvents []AuditEvent
+	store := NewAESGCMStore(keyProvider, func(e AuditEvent) {
+		if e.Operation == "seal" {
+			mu.Lock()
+			sealEvents = append(sealEvents, e)
+			mu.Unlock()
+		}
+	}, StartSealed(), WithAutoSeal(50*time.Millisecond)).(*aesgcmStore)
+
+	require.NoError(t, store.Unseal(ctx))
+	require.NoError(t, store.Put(ctx, "key", []byte("value")))
+
+	// The seal is audited once the store's locks are released.
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sealEvents) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	require.True(t, store.Sealed())
+	require.Equal(t, "idle", sealEvents[0].Metadata["reason"])
+
+	t.Run("use right before the timer fires keeps it unsealed", func(t *testing.T) {
+		require.NoError(t, store.Unseal(ctx))
+		store.stateMu.Lock()
+		store.lastUsed = time.Now().Add(-time.Hour)
+		store.stateMu.Unlock()
+		_, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+
+		require.False(t, store.trySealIdle(), "the idle check sees the use")
+		require.False(t, store.Sealed())
+
+		store.stateMu.Lock()
+		store.lastUsed = time.Now().Add(-time.Hour)
+		store.stateMu.Unlock()
+		require.True(t, store.trySealIdle())
+		_, err = store.Get(ctx, "key")
+		require.ErrorIs(t, err, ErrSealed)
+	})
+}
+
+func TestAESGCMStore_HealthCheckReportsState(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	var last AuditEvent
+	store := NewAESGCMStore(keyProvider, func(e AuditEvent) { last = e }).(*aesgcmStore)
+
+	require.NoError(t, store.HealthCheck(ctx))
+	require.Equal(t, "unsealed", last.Metadata["state"])
+
+	require.NoError(t, store.Seal(ctx))
+	require.ErrorIs(t, store.HealthCheck(ctx), ErrSealed)
+	require.Equal(t, "sealed", last.Metadata["state"])
+}
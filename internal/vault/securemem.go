@@ -0,0 +1,146 @@
+package vault
+
+import (
+	"sync"
+)
+
+// lockedBuffer holds sensitive bytes outside the Go heap where the platform
+// allows: in their own mapping, locked into RAM so they are never swapped,
+// excluded from core dumps, and flanked by inaccessible guard pages so an
+// overrun faults instead of reading or corrupting a neighbour. The bytes are
+// wiped when the buffer is destroyed. Where memory cannot be locked, because
+// the platform lacks support or RLIMIT_MEMLOCK is exhausted, the buffer still
+// works and is still wiped.
+type lockedBuffer struct {
+	data []byte
+	mem  guardedMemory
+}
+
+// newLockedBuffer allocates a zeroed buffer of n bytes.
+func newLockedBuffer(n int) (*lockedBuffer, error) {
+	if n == 0 {
+		return &lockedBuffer{}, nil
+	}
+	mem, data, err := allocGuarded(n)
+	if err != nil {
+		return nil, err
+	}
+	return &lockedBuffer{data: data, mem: mem}, nil
+}
+
+// newLockedBufferFrom copies b into a new locked buffer. It does not wipe b.
+func newLockedBufferFrom(b []byte) (*lockedBuffer, error) {
+	buf, err := newLockedBuffer(len(b))
+	if err != nil {
+		return nil, err
+	}
+	copy(buf.data, b)
+	return buf, nil
+}
+
+// moveToLockedBuffer copies b into a new locked buffer and wipes b, whether or
+// not the copy succeeds.
+func moveToLockedBuffer(b []byte) (*lockedBuffer, error) {
+	defer wipe(b)
+	return newLockedBufferFrom(b)
+}
+
+// bytes returns the buffer's contents. The slice is only valid until destroy
+// is called; it must not be retained past that.
+func (b *lockedBuffer) bytes() []byte {
+	return b.data
+}
+
+// copy returns the contents in an ordinary heap slice the caller must wipe.
+func (b *lockedBuffer) copy() []byte {
+	return append([]byte(nil), b.data...)
+}
+
+// destroy wipes the buffer and releases its memory. It is safe to call more
+// than once.
+func (b *lockedBuffer) destroy() {
+	if b.data == nil {
+		return
+	}
+	wipe(b.data)
+	freeGuarded(b.mem)
+	b.data = nil
+	b.mem = guardedMemory{}
+}
+
+// SecretValue is a decrypted secret held in locked memory. Call Destroy as
+// soon as the value is no longer needed; it zeroes the bytes, which would
+// otherwise stay readable until the process exits. A SecretValue must not be
+// copied, and is not safe for concurrent use with Destroy.
+type SecretValue struct {
+	buf *lockedBuffer
+}
+
+// newSecretValue moves plaintext into locked memory and wipes the original.
+func newSecretValue(plaintext []byte) (*SecretValue, error) {
+	buf, err := moveToLockedBuffer(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretValue{buf: buf}, nil
+}
+
+// Bytes returns the secret. The slice aliases locked memory: it is zeroed by
+// Destroy and must not be used afterwards. Copy it only if it has to outlive
+// the SecretValue, and wipe the copy yourself.
+func (v *SecretValue) Bytes() []byte {
+	return v.buf.bytes()
+}
+
+// Len returns the length of the secret in bytes.
+func (v *SecretValue) Len() int {
+	return len(v.buf.bytes())
+}
+
+// String returns a placeholder so a secret passed to a logger or fmt by
+// mistake is not revealed.
+func (v *SecretValue) String() string {
+	return "[REDACTED]"
+}
+
+// Destroy zeroes the secret and releases its memory. Bytes returns an empty
+// slice afterwards. It is safe to call more than once.
+func (v *SecretValue) Destroy() {
+	v.buf.destroy()
+}
+
+// coreDumps disables core dumps for the process while at least one store is
+// unsealed, so a crash cannot write master keys or secrets to disk. It
+// restores the previous setting once the last store seals.
+var coreDumps coreDumpGuard
+
+type coreDumpGuard struct {
+	mu      sync.Mutex
+	holders int
+	restore func()
+}
+
+// acquire disables core dumps if this is the first holder. Disabling is best
+// effort: on platforms without the controls it does nothing.
+func (g *coreDumpGuard) acquire() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.holders++
+	if g.holders == 1 {
+		g.restore = disableCoreDumps()
+	}
+}
+
+// release restores core dumps once the last holder is gone.
+func (g *coreDumpGuard) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.holders == 0 {
+		return
+	}
+	g.holders--
+	if g.holders == 0 && g.restore != nil {
+		g.restore()
+		g.restore = nil
+	}
+}
@@ -0,0 +1,10 @@
+//go:build darwin || freebsd
+
+package vault
+
+// excludeFromCoreDump does nothing; RLIMIT_CORE keeps these platforms from
+// writing core files while the vault is unsealed.
+func excludeFromCoreDump(b []byte) {}
+
+// setDumpable does nothing and reports false.
+func setDumpable(dumpable bool) (was bool) { return false }
@@ -0,0 +1,29 @@
+//go:build linux
+
+package vault
+
+import "golang.org/x/sys/unix"
+
+// excludeFromCoreDump asks the kernel to leave b out of core dumps, covering
+// dumps requested explicitly, such as with gcore, despite RLIMIT_CORE.
+func excludeFromCoreDump(b []byte) {
+	_ = unix.Madvise(b, unix.MADV_DONTDUMP)
+}
+
+// setDumpable sets the process's dumpable flag and reports whether it was set
+// before. A non-dumpable process writes no core file and cannot be attached to
+// by unprivileged debuggers.
+func setDumpable(dumpable bool) (was bool) {
+	current, err := unix.PrctlRetInt(unix.PR_GET_DUMPABLE, 0, 0, 0, 0)
+	if err != nil {
+		return false
+	}
+	value := uintptr(0)
+	if dumpable {
+		value = 1
+	}
+	if err := unix.Prctl(unix.PR_SET_DUMPABLE, value, 0, 0, 0); err != nil {
+		return false
+	}
+	return current == 1
+}
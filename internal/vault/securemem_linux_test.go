@@ -0,0 +1,41 @@
+//go:build linux
+
+package vault
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestCoreDumpGuard(t *testing.T) {
+	var before unix.Rlimit
+	require.NoError(t, unix.Getrlimit(unix.RLIMIT_CORE, &before))
+	dumpable, err := unix.PrctlRetInt(unix.PR_GET_DUMPABLE, 0, 0, 0, 0)
+	require.NoError(t, err)
+
+	var g coreDumpGuard
+	g.acquire()
+	g.acquire()
+
+	var limit unix.Rlimit
+	require.NoError(t, unix.Getrlimit(unix.RLIMIT_CORE, &limit))
+	require.Zero(t, limit.Cur)
+	require.Equal(t, before.Max, limit.Max, "the hard limit is left alone")
+	flag, err := unix.PrctlRetInt(unix.PR_GET_DUMPABLE, 0, 0, 0, 0)
+	require.NoError(t, err)
+	require.Zero(t, flag)
+
+	g.release()
+	require.NoError(t, unix.Getrlimit(unix.RLIMIT_CORE, &limit))
+	require.Zero(t, limit.Cur, "still held")
+
+	g.release()
+	g.release()
+	require.NoError(t, unix.Getrlimit(unix.RLIMIT_CORE, &limit))
+	require.Equal(t, before, limit)
+	flag, err = unix.PrctlRetInt(unix.PR_GET_DUMPABLE, 0, 0, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, dumpable, flag)
+}
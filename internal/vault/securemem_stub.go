@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd
+
+package vault
+
+// guardedMemory is unused on platforms without mmap-based locked memory.
+type guardedMemory struct{}
+
+// allocGuarded falls back to an ordinary heap allocation, which is still
+// wiped when the buffer is destroyed but may be swapped or dumped.
+func allocGuarded(n int) (guardedMemory, []byte, error) {
+	return guardedMemory{}, make([]byte, n), nil
+}
+
+// freeGuarded does nothing; the buffer has already been wiped.
+func freeGuarded(mem guardedMemory) {}
+
+// disableCoreDumps does nothing on this platform.
+func disableCoreDumps() (restore func()) { return func() {} }
@@ -0,0 +1,181 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingKeyProvider remembers every key slice it hands out so tests can
+// check the store wiped them.
+type recordingKeyProvider struct {
+	KeyProvider
+	mu     sync.Mutex
+	issued [][]byte
+}
+
+func (p *recordingKeyProvider) record(keys ...[]byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.issued = append(p.issued, keys...)
+}
+
+func (p *recordingKeyProvider) GetKey(ctx context.Context) ([]byte, error) {
+	key, err := p.KeyProvider.GetKey(ctx)
+	p.record(key)
+	return key, err
+}
+
+func (p *recordingKeyProvider) RotateKey(ctx context.Context) ([]byte, []byte, error) {
+	oldKey, newKey, err := p.KeyProvider.RotateKey(ctx)
+	p.record(oldKey, newKey)
+	return oldKey, newKey, err
+}
+
+func (p *recordingKeyProvider) RestoreKey(ctx context.Context, key []byte) error {
+	return p.KeyProvider.(KeyRestorer).RestoreKey(ctx, key)
+}
+
+func (p *recordingKeyProvider) RetiredKeys(ctx context.Context) ([][]byte, error) {
+	keys, err := p.KeyProvider.(RetiredKeyProvider).RetiredKeys(ctx)
+	p.record(keys...)
+	return keys, err
+}
+
+func TestLockedBuffer(t *testing.T) {
+	for _, n := range []int{1, 32, 4096, 5000} {
+		buf, err := newLockedBuffer(n)
+		require.NoError(t, err)
+		require.Len(t, buf.bytes(), n)
+		require.Equal(t, make([]byte, n), buf.bytes(), "new buffers are zeroed")
+
+		for i := range buf.bytes() {
+			buf.bytes()[i] = 0xAA
+		}
+		c := buf.copy()
+		require.Equal(t, bytes.Repeat([]byte{0xAA}, n), c)
+
+		buf.destroy()
+		require.Empty(t, buf.bytes())
+		buf.destroy()
+	}
+
+	empty, err := newLockedBuffer(0)
+	require.NoError(t, err)
+	require.Empty(t, empty.bytes())
+	empty.destroy()
+}
+
+func TestSecretValue(t *testing.T) {
+	plaintext := []byte("hunter2")
+	v, err := newSecretValue(plaintext)
+	require.NoError(t, err)
+	require.Equal(t, make([]byte, 7), plaintext, "the heap copy is wiped")
+
+	require.Equal(t, []byte("hunter2"), v.Bytes())
+	require.Equal(t, 7, v.Len())
+	require.Equal(t, "[REDACTED]", v.String())
+	require.Equal(t, "secret=[REDACTED]", fmt.Sprintf("secret=%v", v))
+
+	v.Destroy()
+	require.Empty(t, v.Bytes())
+	require.Zero(t, v.Len())
+	v.Destroy()
+}
+
+func TestKeyring_ReturnsCopies(t *testing.T) {
+	k := NewKeyring()
+	key := bytes.Repeat([]byte{1}, 32)
+	id, err := k.Add(key)
+	require.NoError(t, err)
+	require.NoError(t, k.SetActive(id))
+
+	_, active, err := k.Active()
+	require.NoError(t, err)
+	wipe(active)
+	got, ok := k.Key(id)
+	require.True(t, ok)
+	require.Equal(t, key, got, "wiping a returned key leaves the ring intact")
+
+	require.Error(t, k.use("missing", func([]byte) error { return nil }))
+	require.NoError(t, k.use(id, func(b []byte) error {
+		require.Equal(t, key, b)
+		return nil
+	}))
+
+	k.Wipe()
+	_, err = k.ActiveID()
+	require.Error(t, err)
+}
+
+func TestAESGCMStore_WipesProviderKeys(t *testing.T) {
+	ctx := context.Background()
+	inner, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	defer inner.Destroy()
+	keyProvider := &recordingKeyProvider{KeyProvider: inner}
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+
+	require.NoError(t, store.Put(ctx, "token", []byte("v1")))
+	require.NoError(t, store.Rotate(ctx))
+	require.NoError(t, store.Seal(ctx))
+	require.NoError(t, store.Unseal(ctx))
+	require.NoError(t, store.HealthCheck(ctx))
+
+	value, err := store.GetSecret(ctx, "token")
+	require.NoError(t, err)
+	require.Equal(t, []byte("v1"), value.Bytes())
+	value.Destroy()
+
+	keyProvider.mu.Lock()
+	defer keyProvider.mu.Unlock()
+	require.NotEmpty(t, keyProvider.issued)
+	for i, key := range keyProvider.issued {
+		require.Equal(t, make([]byte, len(key)), key, "key %d handed out by the provider was not wiped", i)
+	}
+}
+
+func TestAESGCMStore_GetSecret(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+
+	_, err = store.GetSecret(ctx, "missing")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, store.Put(ctx, "token", []byte("v1")))
+	value, err := store.GetSecret(ctx, "token")
+	require.NoError(t, err)
+	require.Equal(t, []byte("v1"), value.Bytes())
+	value.Destroy()
+
+	require.NoError(t, store.Seal(ctx))
+	_, err = store.GetSecret(ctx, "token")
+	require.ErrorIs(t, err, ErrSealed)
+}
+
+func TestAESGCMStore_SealReleasesCoreDumps(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil, StartSealed()).(*aesgcmStore)
+
+	holders := func() int {
+		coreDumps.mu.Lock()
+		defer coreDumps.mu.Unlock()
+		return coreDumps.holders
+	}
+	before := holders()
+
+	require.NoError(t, store.Unseal(ctx))
+	require.NoError(t, store.Put(ctx, "token", []byte("v1")))
+	require.Equal(t, before+1, holders(), "an unsealed store holds core dumps off once")
+
+	require.NoError(t, store.Seal(ctx))
+	require.Equal(t, before, holders())
+}
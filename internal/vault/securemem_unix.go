@@ -0,0 +1,79 @@
+//go:build linux || darwin || freebsd
+
+package vault
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// guardedMemory is the mapping behind a lockedBuffer: a guard page, the data
+// pages and another guard page.
+type guardedMemory struct {
+	mapping []byte
+	data    []byte // The data pages, excluding the guards
+	locked  bool
+}
+
+// allocGuarded maps enough pages for n bytes between two guard pages, locks
+// them and returns the last n bytes, so a write past the end faults on the
+// trailing guard page.
+func allocGuarded(n int) (guardedMemory, []byte, error) {
+	page := os.Getpagesize()
+	size := (n + page - 1) / page * page
+	mapping, err := unix.Mmap(-1, 0, size+2*page, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return guardedMemory{}, nil, fmt.Errorf("failed to allocate locked memory: %w", err)
+	}
+	mem := guardedMemory{mapping: mapping, data: mapping[page : page+size]}
+	if err := unix.Mprotect(mapping[:page], unix.PROT_NONE); err != nil {
+		freeGuarded(mem)
+		return guardedMemory{}, nil, fmt.Errorf("failed to protect guard page: %w", err)
+	}
+	if err := unix.Mprotect(mapping[page+size:], unix.PROT_NONE); err != nil {
+		freeGuarded(mem)
+		return guardedMemory{}, nil, fmt.Errorf("failed to protect guard page: %w", err)
+	}
+	// Locking fails once RLIMIT_MEMLOCK is used up; the memory is still
+	// guarded and wiped, just swappable.
+	mem.locked = unix.Mlock(mem.data) == nil
+	excludeFromCoreDump(mem.data)
+	return mem, mem.data[size-n:], nil
+}
+
+// freeGuarded wipes, unlocks and unmaps memory from allocGuarded.
+func freeGuarded(mem guardedMemory) {
+	if mem.mapping == nil {
+		return
+	}
+	wipe(mem.data)
+	if mem.locked {
+		_ = unix.Munlock(mem.data)
+	}
+	_ = unix.Munmap(mem.mapping)
+}
+
+// disableCoreDumps sets the soft core file size limit to zero and, where the
+// platform has it, marks the process non-dumpable. Only the soft limit is
+// lowered so the returned function can raise it again without privileges.
+func disableCoreDumps() (restore func()) {
+	var previous unix.Rlimit
+	haveLimit := unix.Getrlimit(unix.RLIMIT_CORE, &previous) == nil
+	if haveLimit {
+		limit := previous
+		limit.Cur = 0
+		haveLimit = unix.Setrlimit(unix.RLIMIT_CORE, &limit) == nil
+	}
+	wasDumpable := setDumpable(false)
+
+	return func() {
+		if haveLimit {
+			_ = unix.Setrlimit(unix.RLIMIT_CORE, &previous)
+		}
+		if wasDumpable {
+			setDumpable(true)
+		}
+	}
+}
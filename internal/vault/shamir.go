@@ -0,0 +1,131 @@
+package vault
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// Shamir secret sharing over GF(2^8), as used by ShamirKeyProvider.
+//
+// Each byte of the secret is the constant term of its own random polynomial of
+// degree threshold-1. A share holds the polynomial values at one x coordinate,
+// encoded as y[0] || y[1] || ... || y[len(secret)-1] || x. Any threshold shares
+// recover the secret by Lagrange interpolation at x = 0; fewer reveal nothing.
+
+// splitSecret divides secret into parts shares, any threshold of which can
+// reconstruct it.
+func splitSecret(secret []byte, parts, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("cannot split an empty secret")
+	}
+	if threshold < 2 || threshold > parts || parts > 255 {
+		return nil, fmt.Errorf("invalid share configuration: %d of %d (need 2 <= threshold <= shares <= 255)", threshold, parts)
+	}
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1) // x coordinates 1..parts; 0 would reveal the secret
+	}
+
+	coeffs := make([]byte, threshold)
+	defer wipe(coeffs)
+	for b, s := range secret {
+		coeffs[0] = s
+		if _, err := io.ReadFull(rand.Reader, coeffs[1:]); err != nil {
+			return nil, fmt.Errorf("failed to generate polynomial: %w", err)
+		}
+		for _, share := range shares {
+			share[b] = evalPolynomial(coeffs, share[len(secret)])
+		}
+	}
+	return shares, nil
+}
+
+// combineShares reconstructs a secret from at least threshold distinct shares.
+// With too few shares the result is garbage rather than an error, so callers
+// must verify the output.
+func combineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("at least 2 shares are required, got %d", len(shares))
+	}
+	size := len(shares[0])
+	if size < 2 {
+		return nil, fmt.Errorf("share too short")
+	}
+
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool, len(shares))
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, fmt.Errorf("shares have different lengths")
+		}
+		x := share[size-1]
+		if x == 0 {
+			return nil, fmt.Errorf("share %d has invalid x coordinate", i+1)
+		}
+		if seen[x] {
+			return nil, fmt.Errorf("duplicate share")
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+
+	secret := make([]byte, size-1)
+	for b := range secret {
+		var value byte
+		for i, share := range shares {
+			// Lagrange basis polynomial for xs[i], evaluated at 0.
+			basis := byte(1)
+			for j := range shares {
+				if i == j {
+					continue
+				}
+				basis = gfMul(basis, gfDiv(xs[j], xs[j]^xs[i]))
+			}
+			value ^= gfMul(share[b], basis)
+		}
+		secret[b] = value
+	}
+	return secret, nil
+}
+
+// evalPolynomial evaluates coeffs (lowest degree first) at x using Horner's rule.
+func evalPolynomial(coeffs []byte, x byte) byte {
+	var result byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ coeffs[i]
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x + 1 without
+// data-dependent branches.
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		a = a<<1 ^ (0x1b & -(a >> 7))
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the multiplicative inverse of a (a^254); gfInv(0) is 0.
+func gfInv(a byte) byte {
+	result := byte(1)
+	base := a
+	for e := 254; e > 0; e >>= 1 {
+		if e&1 == 1 {
+			result = gfMul(result, base)
+		}
+		base = gfMul(base, base)
+	}
+	return result
+}
+
+// gfDiv divides a by b in GF(2^8). b must be non-zero.
+func gfDiv(a, b byte) byte {
+	return gfMul(a, gfInv(b))
+}
@@ -4,36 +4,186 @@ package vault
 import (
 	"context"
 	"fmt"
+	"io"
 	"time"
 )
 
 // Store defines the interface for secure credential storage.
 // All operations emit structured audit events for compliance tracking.
+//
+// Features beyond basic storage are described by the optional interfaces
+// below (VersionedStore, MetadataStore, SealableStore and so on). Every store
+// returned by this package implements all of them; reach them with a type
+// assertion, as with KeyRestorer on a KeyProvider.
 type Store interface {
-	// Put stores a secret under the given key. Returns an error if encryption fails.
+	// Put stores a secret under the given key as a new version. Returns an error
+	// if encryption fails.
 	Put(ctx context.Context, key string, value []byte) error
 
-	// Get retrieves a secret by key. Returns ErrNotFound if the key doesn't exist.
+	// Get retrieves a secret by key. Returns ErrNotFound if the key doesn't exist
+	// and ErrExpired once its expiry time has passed.
 	Get(ctx context.Context, key string) ([]byte, error)
 
-	// Delete removes a secret. Returns ErrNotFound if the key doesn't exist.
+	// Delete removes a secret and all of its versions. Returns ErrNotFound if the
+	// key doesn't exist.
 	Delete(ctx context.Context, key string) error
 
 	// List returns all stored keys in alphabetical order.
 	List(ctx context.Context) ([]string, error)
 
 	// HealthCheck verifies the vault is operational and the master key is accessible.
+	// Returns ErrSealed while the vault is sealed.
 	HealthCheck(ctx context.Context) error
+
+	// Close releases the store's backing file so another store can open it.
+	// Writes fail afterwards.
+	Close() error
+}
+
+// SecretReader is implemented by stores that can return secrets in locked
+// memory.
+type SecretReader interface {
+	// GetSecret retrieves a secret like Get, but returns it in locked memory.
+	// The caller must Destroy the value once done with it.
+	GetSecret(ctx context.Context, key string) (*SecretValue, error)
+}
+
+// RevisionedStore is implemented by stores that support optimistic
+// concurrency and atomic batches.
+type RevisionedStore interface {
+	// GetWithRevision retrieves a secret together with its revision, which
+	// changes on every write to the secret.
+	GetWithRevision(ctx context.Context, key string) ([]byte, int64, error)
+
+	// PutIfRevision stores a secret only if it is still at the given revision,
+	// returning ErrConflict otherwise. Revision 0 means the key must not exist.
+	PutIfRevision(ctx context.Context, key string, value []byte, revision int64) (int64, error)
+
+	// ApplyBatch applies several puts and deletes atomically under one new
+	// revision, which it returns.
+	ApplyBatch(ctx context.Context, ops []BatchOp) (int64, error)
+}
+
+// MetadataStore is implemented by stores that keep metadata next to secrets.
+type MetadataStore interface {
+	// PutWithMetadata stores a secret as a new version and replaces its metadata.
+	// Returns ErrInvalidMetadata if the type is unknown.
+	PutWithMetadata(ctx context.Context, key string, value []byte, meta SecretMetadata) error
+
+	// GetMetadata returns a secret's metadata without decrypting it.
+	GetMetadata(ctx context.Context, key string) (SecretMetadata, error)
+
+	// SetMetadata replaces a secret's metadata without writing a new version.
+	SetMetadata(ctx context.Context, key string, meta SecretMetadata) error
+
+	// ListWithFilter returns the keys and metadata of secrets matching filter,
+	// in alphabetical order.
+	ListWithFilter(ctx context.Context, filter SecretFilter) ([]SecretInfo, error)
+}
+
+// ExpiringStore is implemented by stores that track secret expiry.
+type ExpiringStore interface {
+	// ListExpiring returns the secrets whose expiry or rotate-by date falls
+	// within the given window from now, including those already past it.
+	ListExpiring(ctx context.Context, within time.Duration) ([]SecretInfo, error)
+
+	// PurgeExpired deletes every expired secret, emitting an "expired" audit
+	// event for each, and returns the purged keys.
+	PurgeExpired(ctx context.Context) ([]string, error)
+}
+
+// VersionedStore is implemented by stores that retain earlier versions of
+// secrets.
+type VersionedStore interface {
+	// GetVersion retrieves a specific version of a secret. Returns ErrNotFound if
+	// the key doesn't exist and ErrVersionNotFound if the version isn't retained.
+	GetVersion(ctx context.Context, key string, version int) ([]byte, error)
+
+	// ListVersions describes the retained versions of a secret, oldest first.
+	ListVersions(ctx context.Context, key string) ([]VersionInfo, error)
+
+	// Rollback makes an earlier version current again by writing its value as a
+	// new version. Like Put, it clears the expiry of the value it replaces.
+	Rollback(ctx context.Context, key string, version int) error
+}
+
+// NamespacedStore is implemented by stores that treat "/"-separated keys as
+// namespaces.
+type NamespacedStore interface {
+	// ListPrefix returns one page of the keys starting with a prefix, in
+	// alphabetical order.
+	ListPrefix(ctx context.Context, opts ListOptions) (KeyPage, error)
+
+	// DeleteNamespace removes every secret in a namespace, including nested
+	// namespaces, and returns how many were removed.
+	DeleteNamespace(ctx context.Context, namespace string) (int, error)
+}
+
+// WatchableStore is implemented by stores that publish change notifications.
+type WatchableStore interface {
+	// Watch streams change notifications for keys starting with prefix until
+	// ctx is done. Events carry keys and revisions, never values.
+	Watch(ctx context.Context, prefix string) (<-chan WatchEvent, error)
+}
+
+// BundleStore is implemented by stores that can export and import encrypted
+// bundles.
+type BundleStore interface {
+	// Export writes the secrets in a namespace, with their metadata and
+	// versions, to w as a bundle encrypted under a passphrase or recipient keys.
+	Export(ctx context.Context, w io.Writer, opts ExportOptions) (int, error)
+
+	// Import applies a bundle written by Export, merging it with or replacing
+	// the existing secrets. Returns ErrInvalidBundle if it cannot be decrypted
+	// or has been altered.
+	Import(ctx context.Context, r io.Reader, opts ImportOptions) (ImportResult, error)
+}
+
+// RotatingStore is implemented by stores that can move their secrets to a new
+// master key.
+type RotatingStore interface {
+	// Rotate replaces the master key through the KeyProvider and re-wraps every
+	// secret's data key under the new key. Either all entries are re-wrapped or none are.
+	Rotate(ctx context.Context) error
+
+	// Migrate re-wraps entries written under a retired master key, upgrades
+	// legacy ciphertext formats and signs entries that carry no MAC, using the
+	// active key. Entries are re-sealed
+	// without blocking other operations and committed with a single write, so it
+	// can run in the background and a failed write migrates nothing.
+	Migrate(ctx context.Context) error
+}
+
+// SealableStore is implemented by stores that can drop their master key from
+// memory.
+type SealableStore interface {
+	// Seal wipes the master key from memory; every operation then returns
+	// ErrSealed until Unseal succeeds.
+	Seal(ctx context.Context) error
+
+	// Unseal loads the master key from the KeyProvider into memory.
+	Unseal(ctx context.Context) error
+
+	// Sealed reports whether the vault is currently sealed.
+	Sealed() bool
 }
 
 // AuditEvent captures structured information about vault operations.
 type AuditEvent struct {
 	Timestamp time.Time         `json:"timestamp"`
-	Operation string            `json:"operation"` // "put", "get", "delete", "list", "health"
+	Operation string            `json:"operation"` // "put", "get", "get_version", "list_versions", "rollback", "get_metadata", "set_metadata", "list_expiring", "expired", "batch", "delete_namespace", "watch", "export", "import", "delete", "list", "health", "rotate", "migrate", "seal", "unseal", "audit_purge"
 	Key       string            `json:"key"`
 	Success   bool              `json:"success"`
 	Error     string            `json:"error,omitempty"`
 	Metadata  map[string]string `json:"metadata,omitempty"`
+
+	// Who performed the operation and from where, taken from the RequestInfo
+	// in the operation's context.
+	Actor      string    `json:"actor,omitempty"`
+	AuthMethod string    `json:"auth_method,omitempty"`
+	ClientAddr string    `json:"client_addr,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Component  Component `json:"component,omitempty"`
 }
 
 // AuditHook receives audit events for external logging/alerting.
@@ -41,11 +191,27 @@ type AuditHook func(event AuditEvent)
 
 // Common errors returned by Store implementations.
 var (
-	ErrNotFound     = fmt.Errorf("vault: secret not found")
-	ErrKeyEmpty     = fmt.Errorf("vault: key cannot be empty")
-	ErrValueEmpty   = fmt.Errorf("vault: value cannot be empty")
-	ErrUnhealthy    = fmt.Errorf("vault: health check failed")
-	ErrKeyProvider  = fmt.Errorf("vault: key provider error")
-	ErrEncryption   = fmt.Errorf("vault: encryption failed")
-	ErrDecryption   = fmt.Errorf("vault: decryption failed")
+	ErrNotFound          = fmt.Errorf("vault: secret not found")
+	ErrVersionNotFound   = fmt.Errorf("vault: secret version not found")
+	ErrKeyEmpty          = fmt.Errorf("vault: key cannot be empty")
+	ErrInvalidKey        = fmt.Errorf("vault: invalid key")
+	ErrValueEmpty        = fmt.Errorf("vault: value cannot be empty")
+	ErrInvalidMetadata   = fmt.Errorf("vault: invalid secret metadata")
+	ErrUnhealthy         = fmt.Errorf("vault: health check failed")
+	ErrKeyProvider       = fmt.Errorf("vault: key provider error")
+	ErrEncryption        = fmt.Errorf("vault: encryption failed")
+	ErrDecryption        = fmt.Errorf("vault: decryption failed")
+	ErrPersistence       = fmt.Errorf("vault: persistence failed")
+	ErrInvalidPassphrase = fmt.Errorf("vault: invalid passphrase")
+	ErrSealed            = fmt.Errorf("vault: vault is sealed")
+	ErrExpired           = fmt.Errorf("vault: secret has expired")
+	ErrConflict          = fmt.Errorf("vault: revision conflict")
+	ErrInvalidBatch      = fmt.Errorf("vault: invalid batch")
+	ErrInvalidCursor     = fmt.Errorf("vault: invalid list cursor")
+	ErrAccessDenied      = fmt.Errorf("vault: access denied")
+	ErrInvalidBundle     = fmt.Errorf("vault: invalid or corrupt bundle")
+	ErrAuditTampered     = fmt.Errorf("vault: audit log has been tampered with")
+	ErrAuditUnavailable  = fmt.Errorf("vault: audit pipeline unavailable")
+	ErrStoreLocked       = fmt.Errorf("vault: store is in use by another process")
+	ErrTampered          = fmt.Errorf("vault: secret entry has been tampered with")
 )
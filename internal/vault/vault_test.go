@@ -3,11 +3,43 @@ package vault
 import (
 	"context"
 	"errors"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
+// internalStore passes on a constructor's result as the concrete store, so
+// tests can use every optional interface without asserting each one.
+func internalStore(store Store, err error) (*aesgcmStore, error) {
+	if err != nil {
+		return nil, err
+	}
+	return store.(*aesgcmStore), nil
+}
+
+func TestStore_OptionalInterfaces(t *testing.T) {
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	memory := NewAESGCMStore(keyProvider, nil)
+	file, err := NewFileStore(filepath.Join(t.TempDir(), "secrets.json"), keyProvider, nil)
+	require.NoError(t, err)
+	defer file.Close()
+
+	for _, store := range []Store{memory, file} {
+		require.Implements(t, (*SecretReader)(nil), store)
+		require.Implements(t, (*RevisionedStore)(nil), store)
+		require.Implements(t, (*MetadataStore)(nil), store)
+		require.Implements(t, (*ExpiringStore)(nil), store)
+		require.Implements(t, (*VersionedStore)(nil), store)
+		require.Implements(t, (*NamespacedStore)(nil), store)
+		require.Implements(t, (*WatchableStore)(nil), store)
+		require.Implements(t, (*BundleStore)(nil), store)
+		require.Implements(t, (*RotatingStore)(nil), store)
+		require.Implements(t, (*SealableStore)(nil), store)
+	}
+}
+
 func TestAESGCMStore_PutGet(t *testing.T) {
 	ctx := context.Background()
 	keyProvider, err := NewInMemoryKeyProvider()
@@ -143,28 +175,34 @@ func TestAESGCMStore_KeyRotation(t *testing.T) {
 	err = store.Put(ctx, "rotate-test", originalSecret)
 	require.NoError(t, err)
 
-	// Rotate key
+	// Rotate key outside the store
 	oldKey, newKey, err := keyProvider.RotateKey(ctx)
 	require.NoError(t, err)
 	require.NotEqual(t, oldKey, newKey)
 
-	// Old encrypted data should fail to decrypt with new key
-	_, err = store.Get(ctx, "rotate-test")
-	require.Error(t, err, "should fail because data was encrypted with old key")
+	// The keyring still holds the old key, so the entry remains readable
+	retrieved, err := store.Get(ctx, "rotate-test")
+	require.NoError(t, err)
+	require.Equal(t, originalSecret, retrieved)
 
-	// Re-encrypt with new key
-	store.mu.Lock()
-	encryptedWithOldKey := store.secrets["rotate-test"]
-	plaintext, err := store.decrypt(oldKey, encryptedWithOldKey)
+	store.mu.RLock()
+	env, err := parseEnvelope(store.secrets["rotate-test"].current().Data)
+	store.mu.RUnlock()
 	require.NoError(t, err)
+	require.Equal(t, KeyID(oldKey), env.KeyID)
+
+	// Migration re-seals the entry under the new key
+	require.NoError(t, store.Migrate(ctx))
 
-	reEncrypted, err := store.encrypt(newKey, plaintext)
+	store.mu.RLock()
+	env, err = parseEnvelope(store.secrets["rotate-test"].current().Data)
+	store.mu.RUnlock()
 	require.NoError(t, err)
-	store.secrets["rotate-test"] = reEncrypted
-	store.mu.Unlock()
+	require.Equal(t, KeyID(newKey), env.KeyID)
 
-	// Now retrieval should work
-	retrieved, err := store.Get(ctx, "rotate-test")
+	// Once the old key is dropped the migrated entry still opens
+	require.NoError(t, store.keyring.Remove(KeyID(oldKey)))
+	retrieved, err = store.Get(ctx, "rotate-test")
 	require.NoError(t, err)
 	require.Equal(t, originalSecret, retrieved)
 }
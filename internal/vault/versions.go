@@ -0,0 +1,349 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultVersionRetention is the number of versions kept per secret unless
+// WithVersionRetention says otherwise.
+const DefaultVersionRetention = 10
+
+// VersionInfo describes one stored version of a secret. It never carries the value.
+type VersionInfo struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Actor     string    `json:"actor,omitempty"`
+	Current   bool      `json:"current"`
+}
+
+// WithVersionRetention sets how many versions of each secret are kept. Once a
+// put goes over the limit the oldest versions are discarded. Zero or less keeps
+// every version.
+func WithVersionRetention(keep int) StoreOption {
+	return func(s *aesgcmStore) {
+		s.keepVersions = keep
+	}
+}
+
+// secretEntry is the metadata and version history of one secret. Entries are
+// never modified in place: mutations build a new entry so a failed persist can
+// put the old one back.
+type secretEntry struct {
+	Revision int64           `json:"revision"` // Store revision of the last change
+	Metadata SecretMetadata  `json:"metadata"`
+	Versions []secretVersion `json:"versions"` // Oldest first; the last one is current
+
+	// MAC authenticates everything above but Revision under the master key
+	// MACKey names; see entryMAC. Both are empty on entries written before
+	// MACs existed.
+	MACKey string `json:"mac_key,omitempty"`
+	MAC    []byte `json:"mac,omitempty"`
+}
+
+// secretVersion is one encrypted value of a secret.
+type secretVersion struct {
+	Number    int       `json:"number"`
+	CreatedAt time.Time `json:"created_at"`
+	Actor     string    `json:"actor,omitempty"`
+	Data      []byte    `json:"data"` // Encrypted envelope
+}
+
+// newSecretEntry returns an entry holding data as its only version.
+func newSecretEntry(data []byte) *secretEntry {
+	return &secretEntry{Versions: []secretVersion{{Number: 1, Data: data}}}
+}
+
+// current returns the latest version.
+func (e *secretEntry) current() secretVersion {
+	return e.Versions[len(e.Versions)-1]
+}
+
+// version returns the version with the given number, if it is still retained.
+func (e *secretEntry) version(number int) (secretVersion, bool) {
+	for _, v := range e.Versions {
+		if v.Number == number {
+			return v, true
+		}
+	}
+	return secretVersion{}, false
+}
+
+// withVersion returns a copy of e with data appended as the next version,
+// dropping the oldest versions beyond keep. A nil entry starts at version 1.
+func (e *secretEntry) withVersion(data []byte, createdAt time.Time, actor string, keep int) *secretEntry {
+	next := secretVersion{Number: 1, CreatedAt: createdAt, Actor: actor, Data: data}
+	meta := SecretMetadata{CreatedAt: createdAt}
+	var versions []secretVersion
+	if e != nil {
+		next.Number = e.current().Number + 1
+		meta = e.Metadata.clone()
+		versions = append(versions, e.Versions...)
+	}
+	meta.UpdatedAt = createdAt
+	versions = append(versions, next)
+	if keep > 0 && len(versions) > keep {
+		versions = versions[len(versions)-keep:]
+	}
+	return &secretEntry{Metadata: meta, Versions: versions}
+}
+
+// withData returns a copy of e with each version's envelope replaced by the
+// corresponding element of data.
+func (e *secretEntry) withData(data [][]byte) *secretEntry {
+	versions := make([]secretVersion, len(e.Versions))
+	for i, v := range e.Versions {
+		v.Data = data[i]
+		versions[i] = v
+	}
+	return &secretEntry{Revision: e.Revision, Metadata: e.Metadata, Versions: versions}
+}
+
+// GetVersion retrieves a specific version of a secret. Returns ErrNotFound if
+// the key doesn't exist and ErrVersionNotFound if the version was never written
+// or has been discarded.
+func (s *aesgcmStore) GetVersion(ctx context.Context, key string, version int) ([]byte, error) {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "get_version",
+		Key:       key,
+		Metadata:  map[string]string{"version": fmt.Sprintf("%d", version)},
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	if err := s.checkUnsealed(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	}
+	if key == "" {
+		event.Success = false
+		event.Error = ErrKeyEmpty.Error()
+		return nil, ErrKeyEmpty
+	}
+	if err := s.authorize(ctx, event.Operation, key); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	}
+
+	s.mu.RLock()
+	entry, exists := s.secrets[key]
+	var v secretVersion
+	found := false
+	if exists {
+		v, found = entry.version(version)
+	}
+	s.mu.RUnlock()
+
+	if !exists {
+		event.Success = false
+		event.Error = ErrNotFound.Error()
+		return nil, ErrNotFound
+	}
+	if !found {
+		event.Success = false
+		event.Error = ErrVersionNotFound.Error()
+		return nil, ErrVersionNotFound
+	}
+
+	if _, err := s.activeKey(ctx); errors.Is(err, ErrSealed) {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	} else if err != nil {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", ErrKeyProvider, err)
+		return nil, fmt.Errorf("%w: %v", ErrKeyProvider, err)
+	}
+
+	if err := s.verifyEntry(key, entry); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	}
+	if entry.Metadata.expired(time.Now()) {
+		event.Success = false
+		event.Error = ErrExpired.Error()
+		return nil, ErrExpired
+	}
+
+	plaintext, _, err := s.open(key, v.Data)
+	if err != nil {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", ErrDecryption, err)
+		return nil, fmt.Errorf("%w: %v", ErrDecryption, err)
+	}
+
+	event.Success = true
+	event.Metadata["size"] = fmt.Sprintf("%d", len(plaintext))
+	return plaintext, nil
+}
+
+// ListVersions returns the retained versions of a secret, oldest first.
+// Returns ErrNotFound if the key doesn't exist.
+func (s *aesgcmStore) ListVersions(ctx context.Context, key string) ([]VersionInfo, error) {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "list_versions",
+		Key:       key,
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	if err := s.checkUnsealed(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	}
+	if key == "" {
+		event.Success = false
+		event.Error = ErrKeyEmpty.Error()
+		return nil, ErrKeyEmpty
+	}
+	if err := s.authorize(ctx, event.Operation, key); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	}
+
+	s.mu.RLock()
+	entry, exists := s.secrets[key]
+	s.mu.RUnlock()
+
+	if !exists {
+		event.Success = false
+		event.Error = ErrNotFound.Error()
+		return nil, ErrNotFound
+	}
+	if err := s.loadKeys(ctx); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	}
+	if err := s.verifyEntry(key, entry); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	}
+
+	infos := make([]VersionInfo, len(entry.Versions))
+	for i, v := range entry.Versions {
+		infos[i] = VersionInfo{
+			Version:   v.Number,
+			CreatedAt: v.CreatedAt,
+			Actor:     v.Actor,
+			Current:   i == len(entry.Versions)-1,
+		}
+	}
+
+	event.Success = true
+	event.Metadata = map[string]string{"count": fmt.Sprintf("%d", len(infos))}
+	return infos, nil
+}
+
+// Rollback makes the value of an earlier version current again by writing it
+// as a new version, so the history stays append-only and the rollback itself
+// can be undone. Like a plain Put, it clears the expiry. Returns ErrNotFound if
+// the key doesn't exist and ErrVersionNotFound if the version is not retained.
+func (s *aesgcmStore) Rollback(ctx context.Context, key string, version int) error {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "rollback",
+		Key:       key,
+		Metadata:  map[string]string{"from_version": fmt.Sprintf("%d", version)},
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	if err := s.checkUnsealed(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
+	if key == "" {
+		event.Success = false
+		event.Error = ErrKeyEmpty.Error()
+		return ErrKeyEmpty
+	}
+	if err := s.authorize(ctx, event.Operation, key); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
+
+	keyID, err := s.activeKey(ctx)
+	if errors.Is(err, ErrSealed) {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
+	if err != nil {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", ErrKeyProvider, err)
+		return fmt.Errorf("%w: %v", ErrKeyProvider, err)
+	}
+
+	// Hold the write lock from read to write so a concurrent put cannot be
+	// numbered in between.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, exists := s.secrets[key]
+	if !exists {
+		event.Success = false
+		event.Error = ErrNotFound.Error()
+		return ErrNotFound
+	}
+	if err := s.verifyEntry(key, previous); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return err
+	}
+	if previous.Metadata.expired(time.Now()) {
+		event.Success = false
+		event.Error = ErrExpired.Error()
+		return ErrExpired
+	}
+	target, found := previous.version(version)
+	if !found {
+		event.Success = false
+		event.Error = ErrVersionNotFound.Error()
+		return ErrVersionNotFound
+	}
+
+	plaintext, _, err := s.open(key, target.Data)
+	if err != nil {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", ErrDecryption, err)
+		return fmt.Errorf("%w: %v", ErrDecryption, err)
+	}
+	defer wipe(plaintext)
+
+	// Re-seal rather than copy the envelope so the new version is under the
+	// active key even if the old one was written before a rotation.
+	encrypted, err := s.seal(key, keyID, plaintext)
+	if err != nil {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", ErrEncryption, err)
+		return fmt.Errorf("%w: %v", ErrEncryption, err)
+	}
+
+	// The expiry belongs to the value being replaced, as with a plain put.
+	entry := previous.withVersion(encrypted, time.Now(), ActorFromContext(ctx), s.keepVersions)
+	entry.Metadata.ExpiresAt = time.Time{}
+	revision, err := s.commitLocked(map[string]*secretEntry{key: entry})
+	if err != nil {
+		event.Success = false
+		event.Error = fmt.Sprintf("%v: %v", ErrPersistence, err)
+		return fmt.Errorf("%w: %v", ErrPersistence, err)
+	}
+
+	event.Success = true
+	event.Metadata["version"] = fmt.Sprintf("%d", entry.current().Number)
+	event.Metadata["revision"] = fmt.Sprintf("%d", revision)
+	return nil
+}
@@ -0,0 +1,190 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAESGCMStore_Versions(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	var auditEvents []AuditEvent
+	store := NewAESGCMStore(keyProvider, func(e AuditEvent) {
+		auditEvents = append(auditEvents, e)
+	}).(*aesgcmStore)
+
+	require.NoError(t, store.Put(WithActor(ctx, "alice"), "token", []byte("v1")))
+	require.NoError(t, store.Put(WithActor(ctx, "bob"), "token", []byte("v2")))
+	require.NoError(t, store.Put(ctx, "token", []byte("v3")))
+	require.Equal(t, "3", auditEvents[len(auditEvents)-1].Metadata["version"])
+
+	versions, err := store.ListVersions(ctx, "token")
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	for i, v := range versions {
+		require.Equal(t, i+1, v.Version)
+		require.False(t, v.CreatedAt.IsZero())
+		require.Equal(t, i == 2, v.Current)
+	}
+	require.Equal(t, "alice", versions[0].Actor)
+	require.Equal(t, "bob", versions[1].Actor)
+	require.Empty(t, versions[2].Actor)
+
+	for version, want := range map[int]string{1: "v1", 2: "v2", 3: "v3"} {
+		got, err := store.GetVersion(ctx, "token", version)
+		require.NoError(t, err)
+		require.Equal(t, []byte(want), got)
+	}
+
+	_, err = store.GetVersion(ctx, "token", 4)
+	require.ErrorIs(t, err, ErrVersionNotFound)
+	_, err = store.GetVersion(ctx, "missing", 1)
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = store.ListVersions(ctx, "missing")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	t.Run("rollback writes a new version", func(t *testing.T) {
+		auditEvents = nil
+		require.NoError(t, store.Rollback(WithActor(ctx, "carol"), "token", 1))
+		require.Len(t, auditEvents, 1)
+		require.Equal(t, "rollback", auditEvents[0].Operation)
+		require.True(t, auditEvents[0].Success)
+		require.Equal(t, "1", auditEvents[0].Metadata["from_version"])
+		require.Equal(t, "4", auditEvents[0].Metadata["version"])
+
+		got, err := store.Get(ctx, "token")
+		require.NoError(t, err)
+		require.Equal(t, []byte("v1"), got)
+
+		versions, err := store.ListVersions(ctx, "token")
+		require.NoError(t, err)
+		require.Len(t, versions, 4)
+		require.Equal(t, "carol", versions[3].Actor)
+
+		require.ErrorIs(t, store.Rollback(ctx, "token", 9), ErrVersionNotFound)
+		require.ErrorIs(t, store.Rollback(ctx, "missing", 1), ErrNotFound)
+	})
+
+	t.Run("rotation re-wraps every version", func(t *testing.T) {
+		require.NoError(t, store.Rotate(ctx))
+		newKey, err := keyProvider.GetKey(ctx)
+		require.NoError(t, err)
+
+		for _, v := range store.secrets["token"].Versions {
+			env, err := parseEnvelope(v.Data)
+			require.NoError(t, err)
+			require.Equal(t, KeyID(newKey), env.KeyID)
+		}
+		got, err := store.GetVersion(ctx, "token", 2)
+		require.NoError(t, err)
+		require.Equal(t, []byte("v2"), got)
+	})
+
+	t.Run("delete removes all versions", func(t *testing.T) {
+		require.NoError(t, store.Delete(ctx, "token"))
+		_, err := store.GetVersion(ctx, "token", 1)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		require.NoError(t, store.Put(ctx, "token", []byte("fresh")))
+		versions, err := store.ListVersions(ctx, "token")
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+		require.Equal(t, 1, versions[0].Version)
+	})
+}
+
+func TestAESGCMStore_VersionRetention(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	store := NewAESGCMStore(keyProvider, nil, WithVersionRetention(2)).(*aesgcmStore)
+	for _, v := range []string{"v1", "v2", "v3"} {
+		require.NoError(t, store.Put(ctx, "token", []byte(v)))
+	}
+
+	versions, err := store.ListVersions(ctx, "token")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, 2, versions[0].Version)
+	require.Equal(t, 3, versions[1].Version)
+
+	_, err = store.GetVersion(ctx, "token", 1)
+	require.ErrorIs(t, err, ErrVersionNotFound)
+	require.ErrorIs(t, store.Rollback(ctx, "token", 1), ErrVersionNotFound)
+
+	// Rolling back to the oldest retained version survives its own pruning.
+	require.NoError(t, store.Rollback(ctx, "token", 2))
+	got, err := store.Get(ctx, "token")
+	require.NoError(t, err)
+	require.Equal(t, []byte("v2"), got)
+}
+
+func TestFileStore_Versions(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	store, err := NewFileStore(path, keyProvider, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(WithActor(ctx, "alice"), "token", []byte("v1")))
+	require.NoError(t, store.Put(ctx, "token", []byte("v2")))
+
+	require.NoError(t, store.Close())
+	reopened, err := internalStore(NewFileStore(path, keyProvider, nil))
+	require.NoError(t, err)
+	versions, err := reopened.ListVersions(ctx, "token")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, "alice", versions[0].Actor)
+
+	got, err := reopened.GetVersion(ctx, "token", 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("v1"), got)
+}
+
+func TestFileStore_UpgradesVersion1File(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	// Seal an entry with a memory store and write it in the version 1 layout.
+	memory := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+	require.NoError(t, memory.Put(ctx, "token", []byte("legacy")))
+	data, err := json.Marshal(storeFileV1{
+		Version: storeFileVersion1,
+		Secrets: map[string][]byte{"token": memory.secrets["token"].current().Data},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+
+	store, err := internalStore(NewFileStore(path, keyProvider, nil))
+	require.NoError(t, err)
+	versions, err := store.ListVersions(ctx, "token")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.Equal(t, 1, versions[0].Version)
+	_, rev, err := store.GetWithRevision(ctx, "token")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), rev, "upgraded entries start at revision 1")
+
+	require.NoError(t, store.Put(ctx, "token", []byte("updated")))
+	got, err := store.GetVersion(ctx, "token", 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("legacy"), got)
+
+	var f storeFile
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &f))
+	require.Equal(t, storeFileVersion, f.Version, "the next write upgrades the file")
+}
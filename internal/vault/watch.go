@@ -0,0 +1,120 @@
+package vault
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchBufferSize is how many undelivered events a watcher may fall behind by
+// before its channel is closed.
+const watchBufferSize = 64
+
+// WatchEventType says what happened to a secret.
+type WatchEventType string
+
+// Watch event types.
+const (
+	// EventPut reports a new version or new metadata for a secret.
+	EventPut WatchEventType = "put"
+	// EventDelete reports that a secret and its versions were removed.
+	EventDelete WatchEventType = "delete"
+	// EventRotate reports a master key rotation. It has no key and is sent
+	// to every watcher; secret values are unchanged.
+	EventRotate WatchEventType = "rotate"
+	// EventExpire reports that an expired secret was purged.
+	EventExpire WatchEventType = "expire"
+)
+
+// WatchEvent is a change notification. It never carries secret values.
+type WatchEvent struct {
+	Type     WatchEventType `json:"type"`
+	Key      string         `json:"key,omitempty"`
+	Revision int64          `json:"revision"`          // Store revision of the change
+	Version  int            `json:"version,omitempty"` // Current version after a put
+}
+
+// watcher is one Watch subscription.
+type watcher struct {
+	prefix  string
+	allowed func(key string) bool
+	ch      chan WatchEvent
+}
+
+// watchers is the set of live subscriptions. Sends happen while the store's
+// data lock is held, so events reach each watcher in revision order.
+type watchers struct {
+	mu  sync.Mutex
+	set map[*watcher]struct{}
+}
+
+// Watch streams changes to keys starting with prefix until ctx is done, at
+// which point the channel is closed. Receivers that fall more than a buffer's
+// worth of events behind are dropped the same way and should call Watch again
+// and re-read what they cache. Keys the namespace policy does not let the actor
+// in ctx watch are left out.
+func (s *aesgcmStore) Watch(ctx context.Context, prefix string) (<-chan WatchEvent, error) {
+	start := time.Now()
+	event := AuditEvent{
+		Timestamp: start,
+		Operation: "watch",
+		Key:       prefix,
+	}
+	defer func() { s.audit(ctx, event) }()
+
+	if err := s.checkUnsealed(); err != nil {
+		event.Success = false
+		event.Error = err.Error()
+		return nil, err
+	}
+
+	w := &watcher{
+		prefix:  prefix,
+		allowed: s.permits(ctx, "watch"),
+		ch:      make(chan WatchEvent, watchBufferSize),
+	}
+	s.watchers.mu.Lock()
+	if s.watchers.set == nil {
+		s.watchers.set = make(map[*watcher]struct{})
+	}
+	s.watchers.set[w] = struct{}{}
+	s.watchers.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.watchers.mu.Lock()
+		defer s.watchers.mu.Unlock()
+		if _, ok := s.watchers.set[w]; ok {
+			delete(s.watchers.set, w)
+			close(w.ch)
+		}
+	}()
+
+	event.Success = true
+	return w.ch, nil
+}
+
+// publish delivers events to every matching watcher without blocking. Callers
+// must hold s.mu so events are published in revision order.
+func (s *aesgcmStore) publish(events ...WatchEvent) {
+	s.watchers.mu.Lock()
+	defer s.watchers.mu.Unlock()
+
+	for w := range s.watchers.set {
+		for _, e := range events {
+			if e.Type != EventRotate && (!strings.HasPrefix(e.Key, w.prefix) || !w.allowed(e.Key)) {
+				continue
+			}
+			select {
+			case w.ch <- e:
+				continue
+			default:
+			}
+			// Too far behind: drop the watcher rather than stall writers.
+			delete(s.watchers.set, w)
+			close(w.ch)
+			break
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// nextEvent receives one event or fails the test after a timeout.
+func nextEvent(t *testing.T, ch <-chan WatchEvent) WatchEvent {
+	t.Helper()
+	select {
+	case e, ok := <-ch:
+		require.True(t, ok, "watch channel closed")
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for watch event")
+		return WatchEvent{}
+	}
+}
+
+func TestAESGCMStore_Watch(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := store.Watch(watchCtx, "mounts/abc/")
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(ctx, "oauth/dropbox/refresh", []byte("ignored")))
+	require.NoError(t, store.Put(ctx, "mounts/abc/token", []byte("v1")))
+	_, rev, err := store.GetWithRevision(ctx, "mounts/abc/token")
+	require.NoError(t, err)
+
+	e := nextEvent(t, events)
+	require.Equal(t, WatchEvent{Type: EventPut, Key: "mounts/abc/token", Revision: rev, Version: 1}, e)
+
+	require.NoError(t, store.Rollback(ctx, "mounts/abc/token", 1))
+	e = nextEvent(t, events)
+	require.Equal(t, EventPut, e.Type)
+	require.Equal(t, 2, e.Version)
+
+	require.NoError(t, store.Rotate(ctx))
+	e = nextEvent(t, events)
+	require.Equal(t, EventRotate, e.Type)
+	require.Empty(t, e.Key)
+
+	_, err = store.ApplyBatch(ctx, []BatchOp{PutOp("mounts/abc/secret", []byte("s")), DeleteOp("mounts/abc/token")})
+	require.NoError(t, err)
+	first, second := nextEvent(t, events), nextEvent(t, events)
+	require.Equal(t, "mounts/abc/secret", first.Key)
+	require.Equal(t, EventPut, first.Type)
+	require.Equal(t, "mounts/abc/token", second.Key)
+	require.Equal(t, EventDelete, second.Type)
+	require.Equal(t, first.Revision, second.Revision, "batch events share one revision")
+
+	require.NoError(t, store.PutWithMetadata(ctx, "mounts/abc/session", []byte("s"), SecretMetadata{ExpiresAt: time.Now().Add(-time.Second)}))
+	require.Equal(t, EventPut, nextEvent(t, events).Type)
+	_, err = store.PurgeExpired(ctx)
+	require.NoError(t, err)
+	e = nextEvent(t, events)
+	require.Equal(t, EventExpire, e.Type)
+	require.Equal(t, "mounts/abc/session", e.Key)
+
+	cancel()
+	require.Eventually(t, func() bool {
+		select {
+		case _, ok := <-events:
+			return !ok
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond, "channel closes when ctx is done")
+}
+
+func TestAESGCMStore_WatchDropsSlowReceivers(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+	store := NewAESGCMStore(keyProvider, nil).(*aesgcmStore)
+
+	events, err := store.Watch(ctx, "")
+	require.NoError(t, err)
+
+	// Writers are never blocked by a receiver that stopped reading.
+	for i := 0; i < watchBufferSize+1; i++ {
+		require.NoError(t, store.Put(ctx, "token", []byte("v")))
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+	require.Equal(t, watchBufferSize, received, "buffered events are delivered before the close")
+}
+
+func TestAESGCMStore_WatchPolicy(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewInMemoryKeyProvider()
+	require.NoError(t, err)
+
+	policy := func(ctx context.Context, operation, namespace string) error {
+		if ActorFromContext(ctx) == "worker" && namespace != "mounts/abc" {
+			return errors.New("forbidden")
+		}
+		return nil
+	}
+	store := NewAESGCMStore(keyProvider, nil, WithNamespacePolicy(policy)).(*aesgcmStore)
+
+	watchCtx, cancel := context.WithCancel(WithActor(ctx, "worker"))
+	defer cancel()
+	events, err := store.Watch(watchCtx, "mounts/")
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(ctx, "mounts/xyz/token", []byte("v")))
+	require.NoError(t, store.Put(ctx, "mounts/abc/token", []byte("v")))
+	require.Equal(t, "mounts/abc/token", nextEvent(t, events).Key, "refused namespaces are skipped")
+}